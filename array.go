@@ -0,0 +1,119 @@
+package athena
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StringArray is a sql.Scanner of array columns, e.g. array(varchar).
+// database/sql can't scan the []interface{} arrays are returned as into a
+// *[]string, so scan them into a StringArray instead.
+//
+//	var names athena.StringArray
+//	err := rows.Scan(&names)
+//
+// Elements of other types are formatted with fmt.Sprint. NULL elements can't
+// be scanned, and a NULL array leaves a nil StringArray.
+type StringArray []string
+
+// Scan implements sql.Scanner.
+func (a *StringArray) Scan(src interface{}) error {
+	elems, err := arrayElements(src)
+	if err != nil {
+		return err
+	}
+	if elems == nil {
+		*a = nil
+		return nil
+	}
+
+	ret := make(StringArray, len(elems))
+	for i, elem := range elems {
+		switch v := elem.(type) {
+		case nil:
+			return fmt.Errorf("cannot scan NULL element %d into StringArray", i)
+		case string:
+			ret[i] = v
+		default:
+			ret[i] = fmt.Sprint(v)
+		}
+	}
+	*a = ret
+	return nil
+}
+
+// IntArray is a sql.Scanner of array columns of integer types, e.g. array(bigint).
+//
+//	var ids athena.IntArray
+//	err := rows.Scan(&ids)
+//
+// NULL elements can't be scanned, and a NULL array leaves a nil IntArray.
+type IntArray []int64
+
+// Scan implements sql.Scanner.
+func (a *IntArray) Scan(src interface{}) error {
+	elems, err := arrayElements(src)
+	if err != nil {
+		return err
+	}
+	if elems == nil {
+		*a = nil
+		return nil
+	}
+
+	ret := make(IntArray, len(elems))
+	for i, elem := range elems {
+		switch v := elem.(type) {
+		case nil:
+			return fmt.Errorf("cannot scan NULL element %d into IntArray", i)
+		case int64:
+			ret[i] = v
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("cannot scan element %d into IntArray: %w", i, err)
+			}
+			ret[i] = n
+		default:
+			return fmt.Errorf("cannot scan element %d of type %T into IntArray", i, elem)
+		}
+	}
+	*a = ret
+	return nil
+}
+
+// arrayElements returns the elements of an array value, which is either a
+// []interface{} as the driver returns arrays or the array as Athena renders
+// it, e.g. `[1, 2]`.
+// The elements of the latter are strings, or nil for `null`.
+func arrayElements(src interface{}) ([]interface{}, error) {
+	switch v := src.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return v, nil
+	case []byte:
+		return parseArrayText(string(v))
+	case string:
+		return parseArrayText(v)
+	default:
+		return nil, fmt.Errorf("cannot scan %T as an array", src)
+	}
+}
+
+func parseArrayText(val string) ([]interface{}, error) {
+	if len(val) < 2 || val[0] != '[' || val[len(val)-1] != ']' {
+		return nil, fmt.Errorf("cannot parse '%s' as array", val)
+	}
+	body := val[1 : len(val)-1]
+
+	elems := make([]interface{}, 0)
+	if body == "" {
+		return elems, nil
+	}
+	for _, elem := range splitElements(body, ',') {
+		elems = append(elems, nullableElement(strings.TrimPrefix(elem, " ")))
+	}
+	return elems, nil
+}
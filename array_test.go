@@ -0,0 +1,94 @@
+package athena
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringArray_Scan(t *testing.T) {
+	tests := []struct {
+		src     interface{}
+		want    StringArray
+		wantErr bool
+	}{
+		{src: "[a, b, c]", want: StringArray{"a", "b", "c"}},
+		{src: []byte("[a]"), want: StringArray{"a"}},
+		{src: "[]", want: StringArray{}},
+		{src: "[[a, b], [c]]", want: StringArray{"[a, b]", "[c]"}},
+		{src: "[a<b, c]", want: StringArray{"a<b", "c"}},
+		{src: "[f(x, y>z]", want: StringArray{"f(x", "y>z"}},
+		{src: []interface{}{"a", int64(1)}, want: StringArray{"a", "1"}},
+		{src: nil, want: nil},
+		{src: "[a, null]", wantErr: true},
+		{src: "a, b", wantErr: true},
+		{src: int64(1), wantErr: true},
+	}
+	for _, tt := range tests {
+		var got StringArray
+		err := got.Scan(tt.src)
+		if tt.wantErr {
+			assert.Error(t, err, tt.src)
+			continue
+		}
+		require.NoError(t, err, tt.src)
+		assert.Equal(t, tt.want, got, tt.src)
+	}
+}
+
+func TestIntArray_Scan(t *testing.T) {
+	tests := []struct {
+		src     interface{}
+		want    IntArray
+		wantErr bool
+	}{
+		{src: "[1, -2, 3]", want: IntArray{1, -2, 3}},
+		{src: "[]", want: IntArray{}},
+		{src: []interface{}{int64(1), "2"}, want: IntArray{1, 2}},
+		{src: nil, want: nil},
+		{src: "[1, null]", wantErr: true},
+		{src: "[1.5]", wantErr: true},
+		{src: []interface{}{1.5}, wantErr: true},
+	}
+	for _, tt := range tests {
+		var got IntArray
+		err := got.Scan(tt.src)
+		if tt.wantErr {
+			assert.Error(t, err, tt.src)
+			continue
+		}
+		require.NoError(t, err, tt.src)
+		assert.Equal(t, tt.want, got, tt.src)
+	}
+}
+
+func TestScanComplexColumns(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{
+				genTypedColumnInfo("names", "array"),
+				genTypedColumnInfo("ids", "array"),
+				genTypedColumnInfo("attrs", "map"),
+				genTypedColumnInfo("point", "row(x integer, y integer)"),
+			},
+			[]*string{aws.String("[a, b]"), aws.String("[1, 2, 3]"), aws.String("{k=v}"), aws.String("{x=1, y=2}")},
+		),
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	var names StringArray
+	var ids IntArray
+	var attrs map[string]interface{}
+	var point map[string]interface{}
+	err := db.QueryRowContext(context.Background(), "SELECT names, ids, attrs, point FROM t").Scan(&names, &ids, &attrs, &point)
+	require.NoError(t, err)
+	assert.Equal(t, StringArray{"a", "b"}, names)
+	assert.Equal(t, IntArray{1, 2, 3}, ids)
+	assert.Equal(t, map[string]interface{}{"k": "v"}, attrs)
+	assert.Equal(t, map[string]interface{}{"x": int64(1), "y": int64(2)}, point)
+}
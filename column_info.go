@@ -0,0 +1,68 @@
+package athena
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// ColumnInfo is the metadata of a result column.
+// In GZIP DL Mode only Name and Type are known, they come from the metadata
+// of the CTAS table instead of the query result.
+type ColumnInfo struct {
+	Name  string
+	Label string
+	Type  string
+
+	// CatalogName, SchemaName and TableName are the table a column is read
+	// from, which tells apart the columns of joined tables with the same name.
+	// They are empty for computed columns and in GZIP DL Mode, where the only
+	// table known is the temporary CTAS table.
+	CatalogName   string
+	SchemaName    string
+	TableName     string
+	Precision     int64
+	Scale         int64
+	Nullable      string
+	CaseSensitive bool
+}
+
+// columnInfoCapture holds the result column metadata of a query.
+type columnInfoCapture struct {
+	infos []ColumnInfo
+}
+
+func (c *columnInfoCapture) captureResultSet(columns []*athena.ColumnInfo) {
+	if c == nil || c.infos != nil {
+		return
+	}
+
+	c.infos = make([]ColumnInfo, 0, len(columns))
+	for _, col := range columns {
+		c.infos = append(c.infos, ColumnInfo{
+			Name:          aws.StringValue(col.Name),
+			Label:         aws.StringValue(col.Label),
+			Type:          aws.StringValue(col.Type),
+			CatalogName:   aws.StringValue(col.CatalogName),
+			SchemaName:    aws.StringValue(col.SchemaName),
+			TableName:     aws.StringValue(col.TableName),
+			Precision:     aws.Int64Value(col.Precision),
+			Scale:         aws.Int64Value(col.Scale),
+			Nullable:      aws.StringValue(col.Nullable),
+			CaseSensitive: aws.BoolValue(col.CaseSensitive),
+		})
+	}
+}
+
+func (c *columnInfoCapture) captureTable(columns []*athena.Column) {
+	if c == nil || c.infos != nil {
+		return
+	}
+
+	c.infos = make([]ColumnInfo, 0, len(columns))
+	for _, col := range columns {
+		c.infos = append(c.infos, ColumnInfo{
+			Name: aws.StringValue(col.Name),
+			Type: aws.StringValue(col.Type),
+		})
+	}
+}
@@ -2,21 +2,31 @@ package athena
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
 	"database/sql/driver"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
+	"math/rand"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	uuid "github.com/satori/go.uuid"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/text/encoding"
 )
 
+// conn isn't modified after it's made by the connector and its Athena client
+// is safe for concurrent use, so queries may run on a conn concurrently.
 type conn struct {
 	athena         athenaiface.AthenaAPI
 	db             string
@@ -25,10 +35,65 @@ type conn struct {
 
 	pollFrequency time.Duration
 
+	// pollBackoffMax is the longest interval of polling, 0 to keep pollFrequency
+	pollBackoffMax time.Duration
+
 	resultMode ResultMode
-	session    *session.Session
+	s3         s3iface.S3API
 	timeout    uint
 	catalog    string
+
+	ctasProperties map[string]string
+
+	idempotencyKeyFunc func(query string) string
+	resultKeyTemplate  string
+	maxQueryLength     int
+
+	resultEncoding encoding.Encoding
+	logger         *log.Logger
+
+	// engineVersion is the major Athena engine version of the workgroup, 0 if unknown
+	engineVersion int
+
+	// clock is the time source of polling, which tests replace
+	clock clock
+
+	readOnly bool
+
+	retryOnInternalError bool
+
+	// bytesScannedCutoff is the bytes a query may scan, 0 for no limit
+	bytesScannedCutoff uint64
+
+	fallbackToAPIOnCTASError bool
+
+	rowProfiler func(rowIndex int, dur time.Duration)
+
+	// csvDelimiter and csvQuote of the result CSV, 0 for the defaults
+	csvDelimiter rune
+	csvQuote     rune
+
+	// resultReuseMaxAge is the age of results a SELECT may reuse, 0 to never reuse them
+	resultReuseMaxAge time.Duration
+
+	// downloadConcurrency is how many objects of a CTAS table are downloaded at once
+	downloadConcurrency int
+
+	// maxRetries is how many times a throttled Athena API call is retried
+	maxRetries int
+
+	// queueTimeout is how long a query may stay queued, 0 for no limit
+	queueTimeout time.Duration
+
+	// autoIdempotency derives the tokens of queries from their hash
+	autoIdempotency bool
+
+	// encryptionType and kmsKey of query results, if any
+	encryptionType string
+	kmsKey         string
+
+	// slots are shared with the other connections of the connector
+	slots querySlots
 }
 
 func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
@@ -45,25 +110,58 @@ func (c *conn) ExecContext(ctx context.Context, query string, args []driver.Name
 		panic("Athena doesn't support prepared statements. Format your own arguments.")
 	}
 
+	if multi, _ := getMultiStatement(ctx); multi {
+		return nil, c.execMultiStatement(ctx, query)
+	}
+
 	_, err := c.runQuery(ctx, query)
 	return nil, err
 }
 
 func (c *conn) runQuery(ctx context.Context, query string) (driver.Rows, error) {
+	// a query started elsewhere, whose result is read rather than run
+	attachedQueryID, attached := getAttachedQueryID(ctx)
+	if attached {
+		execution, err := c.getQueryExecution(ctx, attachedQueryID)
+		if err != nil {
+			return nil, err
+		}
+		query = aws.StringValue(execution.Query)
+	}
+
+	if c.readOnly && !attached && !isReadOnlyQuery(query) {
+		return nil, ErrReadOnly
+	}
+
 	// result mode
 	isSelect := isSelectQuery(query)
 	resultMode := c.resultMode
-	if rmode, ok := getResultMode(ctx); ok {
+	rmode, modeOfQuery := getResultMode(ctx)
+	if modeOfQuery {
 		resultMode = rmode
 	}
 	if !isSelect {
+		// the mode of the connection applies to SELECT only, but the mode
+		// of the query was asked for this statement
+		if modeOfQuery && rmode != ResultModeAPI {
+			c.logf("%v: running the statement in API mode rather than %s mode", ErrResultModeNotApplicable, rmode)
+		}
 		resultMode = ResultModeAPI
 	}
-
-	// timeout
-	timeout := c.timeout
-	if to, ok := getTimeout(ctx); ok {
-		timeout = to
+	if attached && resultMode == ResultModeGzipDL {
+		// the query wasn't wrapped in CTAS, but its result CSV can be downloaded
+		resultMode = ResultModeDL
+	}
+	outputLocation, err := c.outputLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if resultMode != ResultModeAPI && outputLocation == "" {
+		// fail before the query is billed rather than when downloading the result
+		return nil, ErrOutputLocationRequired
+	}
+	if resultMode != ResultModeAPI && c.encryptionType == athena.EncryptionOptionCseKms {
+		return nil, fmt.Errorf("%w in %s mode", ErrClientSideEncryption, resultMode)
 	}
 
 	// catalog
@@ -72,107 +170,626 @@ func (c *conn) runQuery(ctx context.Context, query string) (driver.Rows, error)
 		catalog = cat
 	}
 
+	// raw response
+	rawResponse, _ := getRawResponse(ctx)
+	columnInfos, _ := getColumnInfoCapture(ctx)
+	downloadedBytes, _ := getDownloadedBytes(ctx)
+	stats, _ := getStatistics(ctx)
+	queryIDs, _ := getQueryIDCapture(ctx)
+	statementTypes, _ := getStatementTypeCapture(ctx)
+
+	// csv
+	trimSpaceAsNull, _ := getTrimSpaceAsNull(ctx)
+	resultCompression, _ := getResultCompression(ctx)
+	normalizeColumnNames, _ := getNormalizeColumnNames(ctx)
+
+	convertOptions := getConvertOptions(ctx)
+
+	wait := c.waitOptions(ctx)
+	wait.keepRunning = attached
+
+	// ctas properties
+	ctasProperties := c.ctasProperties
+	if props, ok := getCTASProperties(ctx); ok {
+		ctasProperties = mergeCTASProperties(ctasProperties, props)
+	}
+
 	// mode ctas
+	selectQuery := query
 	var ctasTable string
 	var afterDownload func() error
+	var describeCTASTable func() ([]*athena.Column, error)
 	if isSelect && resultMode == ResultModeGzipDL {
 		// Create AS Select
 		ctasTable = fmt.Sprintf("tmp_ctas_%v", strings.Replace(uuid.NewV4().String(), "-", "", -1))
-		query = fmt.Sprintf("CREATE TABLE %s WITH (format='TEXTFILE') AS %s", ctasTable, query)
-		afterDownload = c.dropCTASTable(ctx, ctasTable)
+		ctasQuery, err := buildCTASQuery(ctasTable, query, ctasProperties)
+		if err != nil {
+			return nil, err
+		}
+		query = ctasQuery
+		// the token of ctx is the CTAS statement's
+		afterDownload = c.dropCTASTable(withoutClientRequestToken(ctx), ctasTable)
+		describeCTASTable = c.describeCTASTable(withoutClientRequestToken(ctx), ctasTable)
 	}
 
-	queryID, err := c.startQuery(query)
+	queryID := attachedQueryID
+	var execution *athena.QueryExecution
+	// attempt counts the statements run for the query, each with its own token
+	var attempt int
+	if attached {
+		queryIDs.set(queryID)
+		execution, err = c.waitOnQuery(ctx, queryID, wait)
+	} else {
+		// the slot is held until the result is read, which is only its first
+		// page in API mode
+		if err := c.slots.acquire(ctx); err != nil {
+			return nil, err
+		}
+		defer c.slots.release()
+
+		queryID, execution, attempt, err = c.execute(ctx, query, wait)
+	}
+	if err != nil && ctasTable != "" && c.fallbackToAPIOnCTASError && isQueryFailed(err) {
+		// some SELECTs can't be wrapped in CTAS, their results are read by the API instead
+		c.logf("CTAS of query %s failed, running the query in API mode: %v", queryID, err)
+		query = selectQuery
+		resultMode = ResultModeAPI
+		ctasTable = ""
+		afterDownload = nil
+		describeCTASTable = nil
+
+		attempt++
+		queryID, err = c.startQueryAttempt(ctx, query, attempt)
+		if err != nil {
+			return nil, err
+		}
+		queryIDs.set(queryID)
+		execution, err = c.waitOnQuery(ctx, queryID, wait)
+	}
 	if err != nil {
 		return nil, err
 	}
+	stats.capture(execution)
+	if ctasTable != "" {
+		// the CTAS statement the SELECT was wrapped in
+		statementTypes.set(StatementTypeSelect)
+	} else {
+		statementTypes.set(statementTypeOf(execution, query))
+	}
 
-	if err := c.waitOnQuery(ctx, queryID); err != nil {
-		return nil, err
+	var resultLocation string
+	if execution.ResultConfiguration != nil {
+		resultLocation = aws.StringValue(execution.ResultConfiguration.OutputLocation)
 	}
 
-	return newRows(rowsConfig{
-		Athena:         c.athena,
-		QueryID:        queryID,
-		SkipHeader:     !isDDLQuery(query),
-		ResultMode:     resultMode,
-		Session:        c.session,
-		OutputLocation: c.OutputLocation,
-		Timeout:        timeout,
-		AfterDownload:  afterDownload,
-		CTASTable:      ctasTable,
-		DB:             c.db,
-		Catalog:        catalog,
+	return newRows(ctx, rowsConfig{
+		Athena:            c.athena,
+		QueryID:           queryID,
+		SkipHeader:        !isDDLQuery(query),
+		ResultMode:        resultMode,
+		S3:                c.s3,
+		OutputLocation:    outputLocation,
+		ResultLocation:    resultLocation,
+		ResultKeyTemplate: c.resultKeyTemplate,
+		Timeout:           wait.timeout,
+		AfterDownload:     afterDownload,
+		CTASTable:         ctasTable,
+		DB:                c.db,
+		Catalog:           catalog,
+		RawResponse:       rawResponse,
+		ColumnInfos:       columnInfos,
+		DownloadedBytes:   downloadedBytes,
+		ResultEncoding:    c.resultEncoding,
+		TrimSpaceAsNull:   trimSpaceAsNull,
+		ResultCompression: resultCompression,
+		DescribeCTASTable: describeCTASTable,
+		Logf:              c.logf,
+		RowProfiler:       c.rowProfiler,
+		CSVDelimiter:      c.csvDelimiter,
+		CSVQuote:          c.csvQuote,
+		ConvertOptions:    convertOptions,
+
+		DownloadConcurrency:  c.downloadConcurrency,
+		NormalizeColumnNames: normalizeColumnNames,
+		Retrier:              c.retrier(),
 	})
 }
 
+var (
+	ctasPropertyNumberRegex = regexp.MustCompile(`^[0-9]+$`)
+	// ctasPropertyColumnsRegex matches ARRAY['a', 'b'] of column names, which
+	// can't hold anything but the list
+	ctasPropertyColumnsRegex = regexp.MustCompile(`^(?i:ARRAY)\[\s*'[A-Za-z0-9_]+'(\s*,\s*'[A-Za-z0-9_]+')*\s*\]$`)
+)
+
+// buildCTASQuery wraps query in a CREATE TABLE AS statement writing to table.
+// props are added to the WITH clause next to the TEXTFILE format the driver
+// relies on. Only the properties keeping the objects readable by GZIP DL
+// mode are allowed: bucketed_by of ARRAY['column', ...], bucket_count of a
+// number, and write_compression of GZIP. The others, e.g. field_delimiter
+// or partitioned_by, would change how the rows are written or where, so
+// they're rejected, as are values other than those.
+func buildCTASQuery(table, query string, props map[string]string) (string, error) {
+	values := make(map[string]string, len(props))
+	for name, val := range props {
+		lower := strings.ToLower(name)
+		if _, ok := values[lower]; ok {
+			return "", fmt.Errorf("ctas property %s is set more than once", lower)
+		}
+
+		switch lower {
+		case "bucketed_by":
+			if !ctasPropertyColumnsRegex.MatchString(val) {
+				return "", fmt.Errorf("ctas property bucketed_by must be an ARRAY of column names: %s", val)
+			}
+		case "bucket_count":
+			if !ctasPropertyNumberRegex.MatchString(val) {
+				return "", fmt.Errorf("ctas property bucket_count must be a number: %s", val)
+			}
+		case "write_compression":
+			if !strings.EqualFold(val, "GZIP") {
+				return "", fmt.Errorf("ctas property write_compression must be GZIP: %s", val)
+			}
+			val = "'GZIP'"
+		default:
+			return "", fmt.Errorf("ctas property %s is not supported in GZIP DL mode", name)
+		}
+		values[lower] = val
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	properties := []string{"format='TEXTFILE'"}
+	for _, name := range names {
+		properties = append(properties, fmt.Sprintf("%s=%s", name, values[name]))
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s WITH (%s) AS %s", table, strings.Join(properties, ", "), query), nil
+}
+
+// mergeCTASProperties returns base overlaid with override.
+func mergeCTASProperties(base, override map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
 func (c *conn) dropCTASTable(ctx context.Context, table string) func() error {
 	return func() error {
 		query := fmt.Sprintf("DROP TABLE %s", table)
 
-		queryID, err := c.startQuery(query)
+		queryID, err := c.startQuery(ctx, query)
 		if err != nil {
 			return err
 		}
 
-		return c.waitOnQuery(ctx, queryID)
+		_, err = c.waitOnQuery(ctx, queryID, waitOptions{timeout: c.timeout})
+		return err
 	}
 }
 
+// describeCTASTable returns a function reading the columns of table from the
+// result metadata of an empty SELECT, for when GetTableMetadata isn't permitted.
+func (c *conn) describeCTASTable(ctx context.Context, table string) func() ([]*athena.Column, error) {
+	return func() ([]*athena.Column, error) {
+		queryID, err := c.startQuery(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", table))
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := c.waitOnQuery(ctx, queryID, waitOptions{timeout: c.timeout}); err != nil {
+			return nil, err
+		}
+
+		var resp *athena.GetQueryResultsOutput
+		err = c.retrier().do(ctx, func() (err error) {
+			resp, err = c.athena.GetQueryResultsWithContext(ctx, &athena.GetQueryResultsInput{
+				QueryExecutionId: aws.String(queryID),
+				MaxResults:       aws.Int64(1),
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var columns []*athena.Column
+		for _, colInfo := range resp.ResultSet.ResultSetMetadata.ColumnInfo {
+			columns = append(columns, &athena.Column{Name: colInfo.Name, Type: colInfo.Type})
+		}
+		return columns, nil
+	}
+}
+
+// logf logs a notice of the driver with Config.Logger, or the standard logger.
+func (c *conn) logf(format string, v ...interface{}) {
+	if c.logger != nil {
+		c.logger.Printf(format, v...)
+		return
+	}
+	log.Printf("athena: "+format, v...)
+}
+
+// waitOptions returns the limits and the progress callback of waiting on a
+// query run with ctx.
+func (c *conn) waitOptions(ctx context.Context) waitOptions {
+	wait := waitOptions{
+		timeout:            c.timeout,
+		queueTimeout:       c.queueTimeout,
+		bytesScannedCutoff: c.bytesScannedCutoff,
+	}
+	if to, ok := getTimeout(ctx); ok {
+		wait.timeout = to
+	}
+	if cutoff, ok := getBytesScannedCutoff(ctx); ok {
+		wait.bytesScannedCutoff = cutoff
+	}
+	wait.progress, _ = getProgressCallback(ctx)
+	return wait
+}
+
+// execute starts query and waits on it. A query failing with an internal
+// error of Athena is retried once when RetryOnInternalError is set. attempt
+// is that of the last execution, for startQueryAttempt.
+func (c *conn) execute(ctx context.Context, query string, wait waitOptions) (queryID string, execution *athena.QueryExecution, attempt int, err error) {
+	queryIDs, _ := getQueryIDCapture(ctx)
+
+	queryID, err = c.startQuery(ctx, query)
+	if err != nil {
+		return "", nil, attempt, err
+	}
+	queryIDs.set(queryID)
+	execution, err = c.waitOnQuery(ctx, queryID, wait)
+
+	if err != nil && c.retryOnInternalError && isInternalError(err) {
+		// Athena's internal errors are usually transient, the query is retried once
+		c.logf("query %s failed with an internal error, retrying: %v", queryID, err)
+		attempt++
+		queryID, err = c.startQueryAttempt(ctx, query, attempt)
+		if err != nil {
+			return "", nil, attempt, err
+		}
+		queryIDs.set(queryID)
+		execution, err = c.waitOnQuery(ctx, queryID, wait)
+	}
+	return queryID, execution, attempt, err
+}
+
 // startQuery starts an Athena query and returns its ID.
-func (c *conn) startQuery(query string) (string, error) {
-	resp, err := c.athena.StartQueryExecution(&athena.StartQueryExecutionInput{
-		QueryString: aws.String(query),
+func (c *conn) startQuery(ctx context.Context, query string) (string, error) {
+	return c.startQueryAttempt(ctx, query, 0)
+}
+
+// startQueryAttempt starts a query as a new execution for every attempt,
+// 0 being the first submission.
+func (c *conn) startQueryAttempt(ctx context.Context, query string, attempt int) (string, error) {
+	if comment, ok := getQueryComment(ctx); ok {
+		query = commentQuery(comment, query)
+	}
+
+	maxQueryLength := c.maxQueryLength
+	if maxQueryLength == 0 {
+		maxQueryLength = maxQueryLengthDefault
+	}
+	if len(query) > maxQueryLength {
+		return "", &QueryTooLongError{Length: len(query), Limit: maxQueryLength}
+	}
+
+	token, err := c.clientRequestToken(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	outputLocation, err := c.outputLocation(ctx)
+	if err != nil {
+		return "", err
+	}
+	if attempt > 0 {
+		// the token of the failed execution would return it again
+		suffix := fmt.Sprintf("-retry%d", attempt)
+		if len(token)+len(suffix) > clientRequestTokenMaxLength {
+			token = token[:clientRequestTokenMaxLength-len(suffix)]
+		}
+		token += suffix
+	}
+
+	input := &athena.StartQueryExecutionInput{
+		ClientRequestToken: aws.String(token),
+		QueryString:        aws.String(query),
 		QueryExecutionContext: &athena.QueryExecutionContext{
 			Database: aws.String(c.db),
 		},
 		ResultConfiguration: &athena.ResultConfiguration{
-			OutputLocation: aws.String(c.OutputLocation),
+			OutputLocation: aws.String(outputLocation),
 		},
 		WorkGroup: aws.String(c.workgroup),
+	}
+	if c.encryptionType != "" {
+		input.ResultConfiguration.EncryptionConfiguration = &athena.EncryptionConfiguration{
+			EncryptionOption: aws.String(c.encryptionType),
+		}
+		if c.kmsKey != "" {
+			input.ResultConfiguration.EncryptionConfiguration.KmsKey = aws.String(c.kmsKey)
+		}
+	}
+
+	// only the results of SELECT can be reused, which excludes the CTAS of GZIP DL mode
+	resultReuseMaxAge := c.resultReuseMaxAge
+	if maxAge, ok := getResultReuse(ctx); ok {
+		resultReuseMaxAge = maxAge
+	}
+	if resultReuseMaxAge > 0 && isSelectQuery(query) {
+		if c.engineSupports(resultReuseMinEngineVersion) {
+			input.ResultReuseConfiguration = &athena.ResultReuseConfiguration{
+				ResultReuseByAgeConfiguration: &athena.ResultReuseByAgeConfiguration{
+					Enabled:         aws.Bool(true),
+					MaxAgeInMinutes: aws.Int64(resultReuseMinutes(resultReuseMaxAge)),
+				},
+			}
+		} else {
+			c.logf("engine version %d can't reuse results, running the query without reusing them", c.engineVersion)
+		}
+	}
+
+	// retrying is safe since the ClientRequestToken makes Athena start the
+	// query at most once
+	var resp *athena.StartQueryExecutionOutput
+	err = c.retrier().do(ctx, func() (err error) {
+		resp, err = c.athena.StartQueryExecutionWithContext(ctx, input)
+		return err
 	})
 	if err != nil {
-		return "", err
+		return "", wrapAPIError(err)
 	}
 
 	return *resp.QueryExecutionId, nil
 }
 
+// resultReuseMinutes returns maxAge in minutes, rounded up since Athena
+// counts it in whole minutes.
+func resultReuseMinutes(maxAge time.Duration) int64 {
+	return int64((maxAge + time.Minute - 1) / time.Minute)
+}
+
+// commentQuery prepends comment to query as a block comment.
+func commentQuery(comment, query string) string {
+	return "/* " + strings.Replace(comment, "*/", "* /", -1) + " */\n" + query
+}
+
+// resultReuseMinEngineVersion is the first Athena engine version able to reuse results.
+const resultReuseMinEngineVersion = 3
+
+// engineSupports reports whether the engine of the workgroup is at least
+// version, which is assumed when the version isn't known.
+func (c *conn) engineSupports(version int) bool {
+	return c.engineVersion == 0 || c.engineVersion >= version
+}
+
+// resultReuseMaxAgeLimit is the longest max age of reused results Athena accepts
+const resultReuseMaxAgeLimit = 7 * 24 * time.Hour
+
+const (
+	// maxQueryLengthDefault is the maximum size of a query string Athena accepts in bytes
+	maxQueryLengthDefault = 262144
+
+	clientRequestTokenMinLength = 32
+	clientRequestTokenMaxLength = 128
+)
+
+// clientRequestToken returns the idempotency token of query, which is the
+// token of ctx, the key of idempotencyKeyFunc, the hash of query with
+// autoIdempotency, or a random one, in that order.
+// Athena accepts tokens of 32 to 128 characters, longer keys are truncated.
+func (c *conn) clientRequestToken(ctx context.Context, query string) (string, error) {
+	if token, ok := getClientRequestToken(ctx); ok {
+		if err := validateClientRequestToken(token); err != nil {
+			return "", err
+		}
+		return token, nil
+	}
+	if c.idempotencyKeyFunc == nil {
+		if c.autoIdempotency {
+			sum := sha256.Sum256([]byte(query))
+			return hex.EncodeToString(sum[:]), nil
+		}
+		return uuid.NewV4().String(), nil
+	}
+
+	token := c.idempotencyKeyFunc(query)
+	if len(token) > clientRequestTokenMaxLength {
+		token = token[:clientRequestTokenMaxLength]
+	}
+	if len(token) < clientRequestTokenMinLength {
+		return "", fmt.Errorf("idempotency key must be at least %d characters: %s", clientRequestTokenMinLength, token)
+	}
+
+	return token, nil
+}
+
+// validateClientRequestToken checks a token has the length Athena accepts.
+func validateClientRequestToken(token string) error {
+	if len(token) < clientRequestTokenMinLength || len(token) > clientRequestTokenMaxLength {
+		return fmt.Errorf("client request token must be %d to %d characters: %s",
+			clientRequestTokenMinLength, clientRequestTokenMaxLength, token)
+	}
+	return nil
+}
+
+// waitOptions are the limits and the callback of waiting on a query.
+type waitOptions struct {
+	// timeout is the seconds the query may run, 0 for no limit
+	timeout uint
+
+	// queueTimeout is how long the query may stay queued, 0 for no limit
+	queueTimeout time.Duration
+
+	// bytesScannedCutoff is the bytes the query may scan, 0 for no limit
+	bytesScannedCutoff uint64
+
+	// progress is called on every poll, if any
+	progress func(QueryProgress)
+
+	// keepRunning leaves the query running when it exceeds the limits or ctx
+	// is done, for queries started elsewhere
+	keepRunning bool
+}
+
 // waitOnQuery blocks until a query finishes, returning an error if it failed.
-func (c *conn) waitOnQuery(ctx context.Context, queryID string) error {
+// The execution of the finished query is returned on success.
+// The query is stopped when it exceeds the limits of opts, unless opts.keepRunning.
+func (c *conn) waitOnQuery(ctx context.Context, queryID string, opts waitOptions) (*athena.QueryExecution, error) {
+	stop := func() {
+		if !opts.keepRunning {
+			c.stopQuery(ctx, queryID)
+		}
+	}
+	clock := c.getClock()
+	start := clock.Now()
+	tracker := newProgressTracker(queryID, start, opts.progress)
+	backoff := pollBackoff{interval: c.pollFrequency, max: c.pollBackoffMax}
 	for {
-		statusResp, err := c.athena.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{
-			QueryExecutionId: aws.String(queryID),
-		})
+		execution, err := c.getQueryExecution(ctx, queryID)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		tracker.report(execution, clock.Now())
 
-		switch *statusResp.QueryExecution.Status.State {
+		switch *execution.Status.State {
 		case athena.QueryExecutionStateCancelled:
-			return context.Canceled
+			reason := aws.StringValue(execution.Status.StateChangeReason)
+			if bytesScannedLimitRegex.MatchString(reason) {
+				// the cutoff of the workgroup
+				return nil, &BytesScannedExceededError{QueryID: queryID, Reason: reason}
+			}
+			return nil, &QueryFailedError{QueryID: queryID, State: athena.QueryExecutionStateCancelled, Reason: reason}
 		case athena.QueryExecutionStateFailed:
-			reason := *statusResp.QueryExecution.Status.StateChangeReason
-			return errors.New(reason)
+			reason := aws.StringValue(execution.Status.StateChangeReason)
+			return nil, &QueryFailedError{QueryID: queryID, State: athena.QueryExecutionStateFailed, Reason: reason}
 		case athena.QueryExecutionStateSucceeded:
-			return nil
+			return execution, nil
 		case athena.QueryExecutionStateQueued:
+			if opts.queueTimeout > 0 && clock.Since(start) >= opts.queueTimeout {
+				stop()
+				return nil, &QueuedTooLongError{QueryID: queryID, QueueTimeout: opts.queueTimeout}
+			}
 		case athena.QueryExecutionStateRunning:
 		}
 
+		limit := time.Duration(opts.timeout) * time.Second
+		if opts.timeout > 0 && clock.Since(start) >= limit {
+			stop()
+			return nil, &QueryTimeoutError{QueryID: queryID, Timeout: limit}
+		}
+
+		if stats := execution.Statistics; opts.bytesScannedCutoff > 0 && stats != nil {
+			scanned := aws.Int64Value(stats.DataScannedInBytes)
+			if scanned > 0 && uint64(scanned) > opts.bytesScannedCutoff {
+				stop()
+				return nil, &BytesScannedExceededError{
+					QueryID: queryID,
+					Cutoff:  opts.bytesScannedCutoff,
+					Scanned: scanned,
+				}
+			}
+		}
+
 		select {
 		case <-ctx.Done():
-			c.athena.StopQueryExecution(&athena.StopQueryExecutionInput{
-				QueryExecutionId: aws.String(queryID),
-			})
-
-			return ctx.Err()
-		case <-time.After(c.pollFrequency):
+			stop()
+			return nil, ctx.Err()
+		case <-clock.After(backoff.next()):
 			continue
 		}
 	}
 }
 
+// outputLocation returns the output location of the queries run with ctx.
+func (c *conn) outputLocation(ctx context.Context) (string, error) {
+	location, ok := getContextOutputLocation(ctx)
+	if !ok {
+		return c.OutputLocation, nil
+	}
+	if !strings.HasPrefix(location, "s3://") {
+		return "", fmt.Errorf("invalid output location %q: it must start with s3://", location)
+	}
+	return location, nil
+}
+
+// getQueryExecution returns the execution of a query.
+func (c *conn) getQueryExecution(ctx context.Context, queryID string) (*athena.QueryExecution, error) {
+	var resp *athena.GetQueryExecutionOutput
+	err := c.retrier().do(ctx, func() (err error) {
+		resp, err = c.athena.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{
+			QueryExecutionId: aws.String(queryID),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, wrapAPIError(err)
+	}
+	return resp.QueryExecution, nil
+}
+
+// pollBackoff is the interval of polling a query, which doubles from interval
+// up to max with a jitter of up to 10%. It stays interval when max is 0.
+type pollBackoff struct {
+	interval time.Duration
+	max      time.Duration
+}
+
+// next returns how long to wait before the next poll.
+func (b *pollBackoff) next() time.Duration {
+	if b.max <= 0 {
+		return b.interval
+	}
+
+	wait := b.interval + time.Duration(rand.Int63n(int64(b.interval)/10+1))
+	if wait > b.max {
+		wait = b.max
+	}
+	b.interval *= 2
+	if b.interval > b.max {
+		b.interval = b.max
+	}
+	return wait
+}
+
+// stopQuery stops a query with the values of ctx, e.g. a tracing span, but
+// not its deadline, since the query is often stopped because ctx is done.
+func (c *conn) stopQuery(ctx context.Context, queryID string) {
+	c.athena.StopQueryExecutionWithContext(detachedContext{ctx}, &athena.StopQueryExecutionInput{
+		QueryExecutionId: aws.String(queryID),
+	})
+}
+
+// detachedContext is a context with the values of Context, which is never done.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c *conn) getClock() clock {
+	if c.clock == nil {
+		return realClock{}
+	}
+	return c.clock
+}
+
+func (c *conn) retrier() retrier {
+	return retrier{maxRetries: c.maxRetries, clock: c.getClock()}
+}
+
 func (c *conn) Prepare(query string) (driver.Stmt, error) {
 	panic("Athena doesn't support prepared statements")
 }
@@ -181,10 +798,29 @@ func (c *conn) Begin() (driver.Tx, error) {
 	panic("Athena doesn't support transactions")
 }
 
+// Close doesn't close the Athena client, which is shared with the other
+// connections made by the same connector.
 func (c *conn) Close() error {
 	return nil
 }
 
+// withConn runs fn with the athena connection underlying a connection of db.
+func withConn(ctx context.Context, db *sql.DB, fn func(c *conn) error) error {
+	sc, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+
+	return sc.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*conn)
+		if !ok {
+			return errors.New("not an athena connection")
+		}
+		return fn(c)
+	})
+}
+
 var _ driver.QueryerContext = (*conn)(nil)
 var _ driver.ExecerContext = (*conn)(nil)
 
@@ -202,18 +838,62 @@ func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
 var _ driver.Queryer = (*conn)(nil)
 var _ driver.Execer = (*conn)(nil)
 
+// trimLeadingComments removes the whitespace and comments before the first
+// statement of query, so that it can be classified by its first keyword.
+func trimLeadingComments(query string) string {
+	for {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+		switch {
+		case strings.HasPrefix(query, "--"):
+			i := strings.IndexByte(query, '\n')
+			if i < 0 {
+				return ""
+			}
+			query = query[i+1:]
+		case strings.HasPrefix(query, "/*"):
+			i := strings.Index(query[2:], "*/")
+			if i < 0 {
+				return ""
+			}
+			query = query[i+4:]
+		default:
+			return query
+		}
+	}
+}
+
 // supported DDL statements by Athena
 // https://docs.aws.amazon.com/athena/latest/ug/language-reference.html
 var ddlQueryRegex = regexp.MustCompile(`(?i)^(ALTER|CREATE|DESCRIBE|DROP|MSCK|SHOW)`)
 
 func isDDLQuery(query string) bool {
-	return ddlQueryRegex.Match([]byte(query))
+	return ddlQueryRegex.MatchString(trimLeadingComments(query))
 }
 
+// selectQueryRegex matches SELECT queries, including those with a WITH clause.
+var selectQueryRegex = regexp.MustCompile(`(?i)^(SELECT|WITH)\b`)
+
 func isSelectQuery(query string) bool {
-	return regexp.MustCompile(`(?i)^SELECT`).Match([]byte(query))
+	return selectQueryRegex.MatchString(trimLeadingComments(query))
 }
 
+// readOnlyQueryRegex matches the statements allowed on a read-only connection.
+var readOnlyQueryRegex = regexp.MustCompile(`(?i)^(SELECT|WITH|SHOW|DESCRIBE|DESC|EXPLAIN)\b`)
+
+// explainAnalyzeRegex matches EXPLAIN ANALYZE, which runs the statement it explains.
+var explainAnalyzeRegex = regexp.MustCompile(`(?i)^EXPLAIN\s+ANALYZE(\s+VERBOSE)?\b`)
+
+func isReadOnlyQuery(query string) bool {
+	query = trimLeadingComments(query)
+	if m := explainAnalyzeRegex.FindString(query); m != "" {
+		return isReadOnlyQuery(query[len(m):])
+	}
+	return readOnlyQueryRegex.MatchString(query)
+}
+
+// ctasQueryRegex matches CREATE TABLE AS SELECT, but not CREATE VIEW AS SELECT.
+var ctasQueryRegex = regexp.MustCompile(`(?is)^CREATE\s+TABLE\s.+\sAS\s+SELECT`)
+
 func isCTASQuery(query string) bool {
-	return regexp.MustCompile(`(?i)^CREATE.+AS\s+SELECT`).Match([]byte(query))
+	return ctasQueryRegex.MatchString(trimLeadingComments(query))
 }
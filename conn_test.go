@@ -0,0 +1,1298 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockAthenaConnClient struct {
+	athenaiface.AthenaAPI
+
+	mu          sync.Mutex
+	startInputs []*athena.StartQueryExecutionInput
+
+	// results is returned for every query
+	results *athena.GetQueryResultsOutput
+
+	// state is the state of every query, which defaults to SUCCEEDED
+	state   string
+	reason  string
+	stopped []string
+
+	// states are reported by the polls before state, one per poll
+	states []string
+
+	// failQuery returns the reason of queries that should fail, if any
+	failQuery func(query string) string
+
+	// statistics is reported for every query
+	statistics *athena.QueryExecutionStatistics
+
+	// submitted and completed are the times reported for every query, if any
+	submitted *time.Time
+	completed *time.Time
+
+	// query is the statement of the executions the mock didn't start
+	query string
+
+	// resultLocation is the location of the result of every query, if any
+	resultLocation string
+}
+
+func (m *mockAthenaConnClient) StartQueryExecutionWithContext(_ aws.Context, input *athena.StartQueryExecutionInput, _ ...request.Option) (*athena.StartQueryExecutionOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.startInputs = append(m.startInputs, input)
+	queryID := fmt.Sprintf("query_%d", len(m.startInputs))
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: &queryID}, nil
+}
+
+func (m *mockAthenaConnClient) GetQueryExecutionWithContext(_ aws.Context, input *athena.GetQueryExecutionInput, _ ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	status := &athena.QueryExecutionStatus{
+		State:              aws.String(m.state),
+		SubmissionDateTime: m.submitted,
+		CompletionDateTime: m.completed,
+	}
+	if m.reason != "" {
+		status.StateChangeReason = aws.String(m.reason)
+	}
+	if m.state == "" {
+		status.State = aws.String(athena.QueryExecutionStateSucceeded)
+	}
+	m.mu.Lock()
+	if len(m.states) > 0 {
+		status.State = aws.String(m.states[0])
+		m.states = m.states[1:]
+	}
+	m.mu.Unlock()
+	if m.failQuery != nil {
+		var n int
+		fmt.Sscanf(*input.QueryExecutionId, "query_%d", &n)
+		m.mu.Lock()
+		query := *m.startInputs[n-1].QueryString
+		m.mu.Unlock()
+		if reason := m.failQuery(query); reason != "" {
+			status.State = aws.String(athena.QueryExecutionStateFailed)
+			status.StateChangeReason = aws.String(reason)
+		}
+	}
+	query := m.query
+	var n int
+	if _, err := fmt.Sscanf(*input.QueryExecutionId, "query_%d", &n); err == nil {
+		m.mu.Lock()
+		if n <= len(m.startInputs) {
+			query = *m.startInputs[n-1].QueryString
+		}
+		m.mu.Unlock()
+	}
+	execution := &athena.QueryExecution{
+		QueryExecutionId: input.QueryExecutionId,
+		Query:            aws.String(query),
+		Status:           status,
+		Statistics:       m.statistics,
+	}
+	if m.resultLocation != "" {
+		execution.ResultConfiguration = &athena.ResultConfiguration{OutputLocation: aws.String(m.resultLocation)}
+	}
+	return &athena.GetQueryExecutionOutput{QueryExecution: execution}, nil
+}
+
+func (m *mockAthenaConnClient) StopQueryExecutionWithContext(ctx aws.Context, input *athena.StopQueryExecutionInput, _ ...request.Option) (*athena.StopQueryExecutionOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = append(m.stopped, *input.QueryExecutionId)
+	return &athena.StopQueryExecutionOutput{}, nil
+}
+
+func (m *mockAthenaConnClient) GetQueryResultsWithContext(_ aws.Context, input *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	// copy the rows since they are consumed by the caller
+	rs := *m.results.ResultSet
+	return &athena.GetQueryResultsOutput{ResultSet: &rs}, nil
+}
+
+// newMockDB opens a DB whose connections use client.
+func newMockDB(t *testing.T, client athenaiface.AthenaAPI, cfg Config) *sql.DB {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	cfg.Session = sess
+	cfg.Database = AthenaDatabase
+	cfg.OutputLocation = fmt.Sprintf("s3://%s", S3Bucket)
+	c := NewConnector(cfg).(*connector)
+	c.athena = client
+	return sql.OpenDB(c)
+}
+
+// genResults builds a single page result of a select query with a header row.
+func genResults(columns []*athena.ColumnInfo, rows ...[]*string) *athena.GetQueryResultsOutput {
+	header := &athena.Row{}
+	for _, col := range columns {
+		header.Data = append(header.Data, &athena.Datum{VarCharValue: col.Name})
+	}
+
+	out := &athena.GetQueryResultsOutput{
+		ResultSet: &athena.ResultSet{
+			ResultSetMetadata: &athena.ResultSetMetadata{ColumnInfo: columns},
+			Rows:              []*athena.Row{header},
+		},
+	}
+	for _, row := range rows {
+		r := &athena.Row{}
+		for _, val := range row {
+			r.Data = append(r.Data, &athena.Datum{VarCharValue: val})
+		}
+		out.ResultSet.Rows = append(out.ResultSet.Rows, r)
+	}
+	return out
+}
+
+func genTypedColumnInfo(column, columnType string) *athena.ColumnInfo {
+	colInfo := genColumnInfo(column)
+	colInfo.Type = aws.String(columnType)
+	return colInfo
+}
+
+func Test_buildCTASQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		props   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "no properties",
+			want: "CREATE TABLE tmp WITH (format='TEXTFILE') AS SELECT 1",
+		},
+		{
+			name: "extra properties",
+			props: map[string]string{
+				"write_compression": "gzip",
+				"bucket_count":      "10",
+				"bucketed_by":       "ARRAY['id']",
+			},
+			want: "CREATE TABLE tmp WITH (format='TEXTFILE', bucket_count=10, bucketed_by=ARRAY['id'], " +
+				"write_compression='GZIP') AS SELECT 1",
+		},
+		{
+			name:  "several bucket columns",
+			props: map[string]string{"bucketed_by": "array[ 'id' ,'name_2' ]"},
+			want:  "CREATE TABLE tmp WITH (format='TEXTFILE', bucketed_by=array[ 'id' ,'name_2' ]) AS SELECT 1",
+		},
+		{
+			name: "properties injected in an array",
+			props: map[string]string{
+				"bucketed_by": "ARRAY['a'], external_location='s3://elsewhere/', partitioned_by=ARRAY['b']",
+			},
+			wantErr: true,
+		},
+		{
+			name:    "bucket columns that aren't names",
+			props:   map[string]string{"bucketed_by": "ARRAY['a' || 'b']"},
+			wantErr: true,
+		},
+		{
+			name:    "bucket count that isn't a number",
+			props:   map[string]string{"bucket_count": "10, partitioned_by=ARRAY['b']"},
+			wantErr: true,
+		},
+		{
+			name:    "names differing in case",
+			props:   map[string]string{"bucket_count": "10", "BUCKET_COUNT": "20"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid property name",
+			props:   map[string]string{"format='PARQUET', x": "1"},
+			wantErr: true,
+		},
+		{
+			name:    "compression other than gzip",
+			props:   map[string]string{"write_compression": "SNAPPY"},
+			wantErr: true,
+		},
+		{
+			name:    "field delimiter",
+			props:   map[string]string{"field_delimiter": ","},
+			wantErr: true,
+		},
+		{
+			name:    "partitions",
+			props:   map[string]string{"partitioned_by": "ARRAY['dt']"},
+			wantErr: true,
+		},
+		{
+			name:    "external location",
+			props:   map[string]string{"external_location": "s3://elsewhere/"},
+			wantErr: true,
+		},
+		{
+			name:    "format is not configurable",
+			props:   map[string]string{"FORMAT": "PARQUET"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildCTASQuery("tmp", "SELECT 1", tt.props)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_mergeCTASProperties(t *testing.T) {
+	base := map[string]string{"bucket_count": "10", "write_compression": "GZIP"}
+	got := mergeCTASProperties(base, map[string]string{"bucket_count": "20"})
+	assert.Equal(t, map[string]string{"bucket_count": "20", "write_compression": "GZIP"}, got)
+	assert.Equal(t, "10", base["bucket_count"])
+}
+
+func TestConn_clientRequestToken(t *testing.T) {
+	client := new(mockAthenaConnClient)
+	c := &conn{athena: client}
+
+	_, err := c.startQuery(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	_, err = c.startQuery(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	assert.Len(t, *client.startInputs[0].ClientRequestToken, 36)
+	assert.NotEqual(t, *client.startInputs[0].ClientRequestToken, *client.startInputs[1].ClientRequestToken)
+
+	c.idempotencyKeyFunc = func(query string) string {
+		return "request-0123456789abcdef-" + query
+	}
+	_, err = c.startQuery(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	_, err = c.startQuery(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	assert.Equal(t, "request-0123456789abcdef-SELECT 1", *client.startInputs[2].ClientRequestToken)
+	assert.Equal(t, *client.startInputs[2].ClientRequestToken, *client.startInputs[3].ClientRequestToken)
+
+	_, err = c.startQuery(context.Background(), "SELECT '"+strings.Repeat("x", 200)+"'")
+	require.NoError(t, err)
+	assert.Len(t, *client.startInputs[4].ClientRequestToken, clientRequestTokenMaxLength)
+
+	c.idempotencyKeyFunc = func(string) string { return "short" }
+	_, err = c.startQuery(context.Background(), "SELECT 1")
+	assert.Error(t, err)
+	assert.Len(t, client.startInputs, 5)
+}
+
+func TestConn_clientRequestTokenOfContext(t *testing.T) {
+	client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}
+	c := &conn{athena: client, timeout: timeOutLimitDefault}
+	token := "nightly-report-2020-01-01-0123456789"
+
+	ctx := SetClientRequestToken(context.Background(), token)
+	_, err := c.QueryContext(ctx, "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, token, *client.startInputs[0].ClientRequestToken)
+
+	// it overrides the idempotency key
+	c.idempotencyKeyFunc = func(query string) string { return "request-0123456789abcdef-" + query }
+	_, err = c.QueryContext(ctx, "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, token, *client.startInputs[1].ClientRequestToken)
+
+	for _, invalid := range []string{"short", strings.Repeat("x", clientRequestTokenMaxLength+1)} {
+		_, err = c.QueryContext(SetClientRequestToken(context.Background(), invalid), "SELECT name FROM t", nil)
+		assert.Error(t, err)
+	}
+	assert.Len(t, client.startInputs, 2)
+
+	// the DROP of the CTAS table isn't submitted with the token of the query
+	harness := mockAthenaHarnessClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(harnessColumns)}}}
+	db := newHarnessDB(t, harness, map[string][]byte{
+		"bucket/results/tables/query_1-manifest.csv": []byte("s3://bucket/results/tables/query_1/part-0.gz\n"),
+		"bucket/results/tables/query_1/part-0.gz":    gzipData(t, "1\001a\001\\N\n"),
+	}, ResultModeGzipDL)
+	defer db.Close()
+	assert.Equal(t, [][]interface{}{{int64(1), "a", nil}}, scanHarnessRows(t, db, ctx))
+	require.Len(t, harness.startInputs, 2)
+	assert.Equal(t, token, *harness.startInputs[0].ClientRequestToken)
+	assert.True(t, strings.HasPrefix(*harness.startInputs[1].QueryString, "DROP TABLE"))
+	assert.NotEqual(t, token, *harness.startInputs[1].ClientRequestToken)
+}
+
+func TestConn_autoIdempotency(t *testing.T) {
+	client := new(mockAthenaConnClient)
+	c := &conn{athena: client, autoIdempotency: true}
+
+	for _, query := range []string{"SELECT 1", "SELECT 1", "SELECT 2"} {
+		_, err := c.startQuery(context.Background(), query)
+		require.NoError(t, err)
+	}
+	tokens := []string{
+		*client.startInputs[0].ClientRequestToken,
+		*client.startInputs[1].ClientRequestToken,
+		*client.startInputs[2].ClientRequestToken,
+	}
+	assert.Len(t, tokens[0], 64)
+	assert.Equal(t, tokens[0], tokens[1])
+	assert.NotEqual(t, tokens[0], tokens[2])
+
+	// the retry of a failed execution gets a token of its own
+	_, err := c.startQueryAttempt(context.Background(), "SELECT 1", 1)
+	require.NoError(t, err)
+	assert.Equal(t, tokens[0]+"-retry1", *client.startInputs[3].ClientRequestToken)
+}
+
+func Test_isCTASQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{query: "CREATE TABLE t AS SELECT * FROM s", want: true},
+		{query: "create table t with (format='PARQUET') as\nselect * from s", want: true},
+		{query: "CREATE TABLE IF NOT EXISTS t AS SELECT 1", want: true},
+		{query: "CREATE VIEW v AS SELECT * FROM s", want: false},
+		{query: "CREATE OR REPLACE VIEW v AS SELECT * FROM s", want: false},
+		{query: "CREATE EXTERNAL TABLE t (a int) LOCATION 's3://bucket/'", want: false},
+		{query: "SELECT * FROM s", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, isCTASQuery(tt.query), tt.query)
+	}
+}
+
+func Test_trimLeadingComments(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "SELECT 1", want: "SELECT 1"},
+		{query: "  \n\tSELECT 1", want: "SELECT 1"},
+		{query: "-- comment\nSELECT 1", want: "SELECT 1"},
+		{query: "/* comment */ SELECT 1", want: "SELECT 1"},
+		{query: "/* a */\n-- b\n  /* c\n d */SELECT 1 -- e", want: "SELECT 1 -- e"},
+		{query: "-- only a comment", want: ""},
+		{query: "/* unterminated", want: ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, trimLeadingComments(tt.query), tt.query)
+	}
+}
+
+func Test_classifyCommentedQueries(t *testing.T) {
+	tests := []struct {
+		query    string
+		isSelect bool
+		isDDL    bool
+		isCTAS   bool
+	}{
+		{query: "-- daily report\nSELECT * FROM s", isSelect: true},
+		{query: "/* service=reporting */\n  select 1", isSelect: true},
+		{query: "\n\n  WITH t AS (SELECT 1) SELECT * FROM t", isSelect: true},
+		{query: "-- partitions\nSHOW PARTITIONS t", isDDL: true},
+		{query: "/* repair */ MSCK REPAIR TABLE t", isDDL: true},
+		{query: "-- copy\nCREATE TABLE t AS SELECT * FROM s", isDDL: true, isCTAS: true},
+		{query: "  /* a */ /* b */ CREATE TABLE t WITH (format='PARQUET') AS\nSELECT 1", isDDL: true, isCTAS: true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.isSelect, isSelectQuery(tt.query), tt.query)
+		assert.Equal(t, tt.isDDL, isDDLQuery(tt.query), tt.query)
+		assert.Equal(t, tt.isCTAS, isCTASQuery(tt.query), tt.query)
+	}
+}
+
+func Test_isSelectQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{query: "SELECT * FROM s", want: true},
+		{query: "select 1", want: true},
+		{query: "WITH t AS (SELECT 1 AS a) SELECT a FROM t", want: true},
+		{query: "with\nt as (select 1) select * from t", want: true},
+		{query: "WITHDRAW", want: false},
+		{query: "SHOW TABLES", want: false},
+		{query: "INSERT INTO t SELECT * FROM s", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, isSelectQuery(tt.query), tt.query)
+	}
+}
+
+func TestConn_readOnly(t *testing.T) {
+	client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}
+	c := &conn{athena: client, readOnly: true}
+
+	allowed := []string{
+		"SELECT * FROM s",
+		"WITH t AS (SELECT 1 AS a) SELECT a FROM t",
+		"SHOW TABLES",
+		"DESCRIBE t",
+		"EXPLAIN SELECT 1",
+		"EXPLAIN INSERT INTO t SELECT * FROM s",
+		"EXPLAIN ANALYZE SELECT * FROM s",
+		"explain analyze verbose /* v */ SELECT * FROM s",
+	}
+	for _, query := range allowed {
+		_, err := c.QueryContext(context.Background(), query, nil)
+		assert.NoError(t, err, query)
+	}
+
+	rejected := []string{
+		"DROP TABLE t",
+		"CREATE TABLE t AS SELECT * FROM s",
+		"INSERT INTO t SELECT * FROM s",
+		"ALTER TABLE t ADD PARTITION (dt = '2020-01-01')",
+		"MSCK REPAIR TABLE t",
+		"EXPLAIN ANALYZE INSERT INTO t SELECT * FROM s",
+		"EXPLAIN ANALYZE VERBOSE CREATE TABLE t AS SELECT * FROM s",
+	}
+	for _, query := range rejected {
+		_, err := c.ExecContext(context.Background(), query, nil)
+		assert.True(t, errors.Is(err, ErrReadOnly), query)
+		_, err = c.QueryContext(context.Background(), query, nil)
+		assert.True(t, errors.Is(err, ErrReadOnly), query)
+	}
+	assert.Len(t, client.startInputs, len(allowed))
+}
+
+func TestConn_concurrentQueries(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{genTypedColumnInfo("id", "integer")},
+			[]*string{aws.String("1")},
+			[]*string{aws.String("2")},
+		),
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	ctx := context.Background()
+	sc, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer sc.Close()
+
+	err = sc.Raw(func(driverConn interface{}) error {
+		c := driverConn.(*conn)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				rows, err := c.QueryContext(ctx, "SELECT id FROM t", nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer rows.Close()
+
+				dest := make([]driver.Value, 1)
+				for cnt := 0; ; cnt++ {
+					if err := rows.Next(dest); err != nil {
+						if err != io.EOF || cnt != 2 {
+							errs <- fmt.Errorf("read %d rows: %v", cnt, err)
+						}
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		// nil once closed and drained
+		return <-errs
+	})
+	require.NoError(t, err)
+	assert.Len(t, client.startInputs, 10)
+}
+
+func TestConn_captureRawResponse(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{genColumnInfo("name")},
+			[]*string{aws.String("a")},
+		),
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	ctx := context.Background()
+	rows, err := db.QueryContext(ctx, "SELECT name FROM t")
+	require.NoError(t, err)
+	require.NoError(t, DrainRows(rows))
+	_, ok := RawResult(ctx)
+	assert.False(t, ok)
+
+	ctx = SetCaptureRawResponse(ctx)
+	rows, err = db.QueryContext(ctx, "SELECT name FROM t")
+	require.NoError(t, err)
+	require.NoError(t, DrainRows(rows))
+
+	raw, ok := RawResult(ctx)
+	require.True(t, ok)
+	require.Len(t, raw.ResultSet.Rows, 2, "header and a row")
+	assert.Equal(t, "name", *raw.ResultSet.Rows[0].Data[0].VarCharValue)
+	assert.Equal(t, "a", *raw.ResultSet.Rows[1].Data[0].VarCharValue)
+}
+
+func TestConn_queryTooLong(t *testing.T) {
+	client := new(mockAthenaConnClient)
+	c := &conn{athena: client}
+
+	query := "SELECT '" + strings.Repeat("x", maxQueryLengthDefault-len("SELECT ''")) + "'"
+	_, err := c.startQuery(context.Background(), query)
+	require.NoError(t, err)
+
+	_, err = c.startQuery(context.Background(), query+" ")
+	assert.True(t, errors.Is(err, ErrQueryTooLong))
+	var tooLong *QueryTooLongError
+	require.True(t, errors.As(err, &tooLong))
+	assert.Equal(t, maxQueryLengthDefault+1, tooLong.Length)
+	assert.Equal(t, maxQueryLengthDefault, tooLong.Limit)
+
+	// the CTAS statement of gzip mode counts too
+	c.resultMode = ResultModeGzipDL
+	c.OutputLocation = "s3://bucket"
+	_, err = c.runQuery(context.Background(), query)
+	assert.True(t, errors.Is(err, ErrQueryTooLong))
+
+	c.maxQueryLength = 10
+	_, err = c.startQuery(context.Background(), "SELECT 1234")
+	assert.True(t, errors.Is(err, ErrQueryTooLong))
+	assert.Len(t, client.startInputs, 1)
+}
+
+type mockBlockingS3Client struct {
+	s3iface.S3API
+}
+
+func (m *mockBlockingS3Client) GetObjectWithContext(ctx aws.Context, _ *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestConn_queryDeadline(t *testing.T) {
+	t.Run("wait", func(t *testing.T) {
+		client := &mockAthenaConnClient{state: athena.QueryExecutionStateRunning}
+		c := &conn{athena: client, pollFrequency: 10 * time.Millisecond, timeout: timeOutLimitDefault}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := c.QueryContext(ctx, "SELECT 1", nil)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Equal(t, []string{"query_1"}, client.stopped)
+	})
+
+	t.Run("download", func(t *testing.T) {
+		client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}
+		c := &conn{
+			athena:         client,
+			s3:             new(mockBlockingS3Client),
+			OutputLocation: "s3://bucket",
+			resultMode:     ResultModeDL,
+			timeout:        timeOutLimitDefault,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := c.QueryContext(ctx, "SELECT 1", nil)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	})
+}
+
+// fakeClock advances by the requested duration instead of sleeping.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestConn_queryTimeout(t *testing.T) {
+	client := &mockAthenaConnClient{state: athena.QueryExecutionStateRunning}
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := &conn{athena: client, pollFrequency: 5 * time.Second, timeout: 60, clock: clock}
+
+	_, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+	assert.True(t, errors.Is(err, ErrQueryTimeout))
+	var timeoutErr *QueryTimeoutError
+	require.True(t, errors.As(err, &timeoutErr))
+	assert.Equal(t, "query_1", timeoutErr.QueryID)
+	assert.Equal(t, time.Minute, timeoutErr.Timeout)
+	assert.Equal(t, []string{"query_1"}, client.stopped)
+	assert.Equal(t, time.Minute, clock.Since(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	// the timeout of the context takes precedence
+	client.stopped = nil
+	_, err = c.QueryContext(SetTimeout(context.Background(), 10), "SELECT 1", nil)
+	require.True(t, errors.As(err, &timeoutErr))
+	assert.Equal(t, 10*time.Second, timeoutErr.Timeout)
+	assert.Equal(t, []string{"query_2"}, client.stopped)
+}
+
+func TestConn_retryOnInternalError(t *testing.T) {
+	failures := 0
+	client := &mockAthenaConnClient{
+		results: genResults([]*athena.ColumnInfo{genColumnInfo("name")}, []*string{aws.String("a")}),
+		failQuery: func(query string) string {
+			failures++
+			if failures == 1 {
+				return "INTERNAL_ERROR_QUERY_ENGINE: Amazon Athena experienced an internal error while executing this query."
+			}
+			return ""
+		},
+	}
+	var logs strings.Builder
+	c := &conn{
+		athena:               client,
+		retryOnInternalError: true,
+		idempotencyKeyFunc:   func(query string) string { return "request-0123456789abcdef-" + query },
+		logger:               log.New(&logs, "", 0),
+	}
+
+	_, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+	require.NoError(t, err)
+	require.Len(t, client.startInputs, 2)
+	assert.Equal(t, "request-0123456789abcdef-SELECT 1", *client.startInputs[0].ClientRequestToken)
+	assert.Equal(t, "request-0123456789abcdef-SELECT 1-retry1", *client.startInputs[1].ClientRequestToken)
+	assert.Contains(t, logs.String(), "query query_1 failed with an internal error")
+
+	// retried once at most
+	failures = 0
+	client.failQuery = func(string) string { return "INTERNAL_ERROR_QUERY_ENGINE" }
+	_, err = c.QueryContext(context.Background(), "SELECT 1", nil)
+	var failed *QueryFailedError
+	require.True(t, errors.As(err, &failed))
+	assert.Equal(t, "query_4", failed.QueryID)
+	assert.Equal(t, athena.QueryExecutionStateFailed, failed.State)
+	assert.False(t, errors.Is(err, context.Canceled))
+	assert.Len(t, client.startInputs, 4)
+
+	// other failures aren't retried
+	client.failQuery = func(string) string { return "SYNTAX_ERROR: line 1:8: Column 'x' cannot be resolved" }
+	_, err = c.QueryContext(context.Background(), "SELECT x", nil)
+	assert.EqualError(t, err, "SYNTAX_ERROR: line 1:8: Column 'x' cannot be resolved")
+	assert.Len(t, client.startInputs, 5)
+
+	// off by default
+	c.retryOnInternalError = false
+	client.failQuery = func(string) string { return "INTERNAL_ERROR_QUERY_ENGINE" }
+	_, err = c.QueryContext(context.Background(), "SELECT 1", nil)
+	assert.Error(t, err)
+	assert.Len(t, client.startInputs, 6)
+}
+
+func TestConn_queryComment(t *testing.T) {
+	client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}
+	c := &conn{
+		athena:         client,
+		s3:             &mockS3Client{objects: map[string][]byte{"bucket/query_1.csv": []byte("\"name\"\n\"a\"\n")}},
+		OutputLocation: "s3://bucket",
+		resultMode:     ResultModeDL,
+		timeout:        timeOutLimitDefault,
+	}
+
+	ctx := SetQueryComment(context.Background(), "service=reporting request=abc123")
+	rows, err := c.QueryContext(ctx, "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/* service=reporting request=abc123 */\nSELECT name FROM t", *client.startInputs[0].QueryString)
+
+	// the query is still classified as SELECT and downloaded
+	_, ok := rows.(*rowsDL)
+	assert.True(t, ok)
+
+	ctx = SetQueryComment(context.Background(), "evil */ DROP TABLE t; /*")
+	_, err = c.startQuery(ctx, "SELECT 1")
+	require.NoError(t, err)
+	assert.Equal(t, "/* evil * / DROP TABLE t; /* */\nSELECT 1", *client.startInputs[1].QueryString)
+}
+
+func TestConn_duplicateColumnNames(t *testing.T) {
+	columns := []*athena.ColumnInfo{genTypedColumnInfo("a", "integer"), genTypedColumnInfo("a", "integer")}
+	client := &mockAthenaConnClient{results: genResults(columns, []*string{aws.String("1"), aws.String("2")})}
+
+	for _, mode := range []ResultMode{ResultModeAPI, ResultModeDL} {
+		c := &conn{
+			athena:         client,
+			s3:             &mockS3Client{objects: map[string][]byte{"bucket/query_2.csv": []byte("\"a\",\"a\"\n\"1\",\"2\"\n")}},
+			OutputLocation: "s3://bucket",
+			resultMode:     mode,
+			timeout:        timeOutLimitDefault,
+		}
+
+		rows, err := c.QueryContext(context.Background(), "SELECT a, a FROM t", nil)
+		require.NoError(t, err, mode)
+		assert.Equal(t, []string{"a", "a"}, rows.Columns(), mode)
+
+		dest := make([]driver.Value, 2)
+		require.NoError(t, rows.Next(dest), mode)
+		assert.Equal(t, []driver.Value{int64(1), int64(2)}, dest, mode)
+		assert.Equal(t, io.EOF, rows.Next(dest), mode)
+	}
+}
+
+func TestConn_bytesScannedCutoff(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results:    genResults([]*athena.ColumnInfo{genColumnInfo("name")}),
+		state:      athena.QueryExecutionStateRunning,
+		statistics: &athena.QueryExecutionStatistics{DataScannedInBytes: aws.Int64(2048)},
+	}
+	c := &conn{athena: client, bytesScannedCutoff: 1024, clock: &fakeClock{}}
+
+	_, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+	assert.True(t, errors.Is(err, ErrBytesScannedExceeded))
+	var exceeded *BytesScannedExceededError
+	require.True(t, errors.As(err, &exceeded))
+	assert.Equal(t, &BytesScannedExceededError{QueryID: "query_1", Cutoff: 1024, Scanned: 2048}, exceeded)
+	assert.Equal(t, []string{"query_1"}, client.stopped)
+
+	// the cutoff of the context takes precedence
+	client.states = []string{athena.QueryExecutionStateRunning}
+	client.state = athena.QueryExecutionStateSucceeded
+	_, err = c.QueryContext(SetBytesScannedCutoff(context.Background(), 4096), "SELECT 1", nil)
+	assert.NoError(t, err)
+
+	// the cutoff of the workgroup
+	client.state = athena.QueryExecutionStateCancelled
+	client.reason = "Query cancelled! -- Bytes scanned limit was exceeded"
+	_, err = c.QueryContext(SetBytesScannedCutoff(context.Background(), 0), "SELECT 1", nil)
+	require.True(t, errors.As(err, &exceeded))
+	assert.Equal(t, "query_3", exceeded.QueryID)
+	assert.Equal(t, "query query_3 was cancelled: Query cancelled! -- Bytes scanned limit was exceeded", err.Error())
+
+	// other cancellations
+	client.reason = ""
+	_, err = c.QueryContext(context.Background(), "SELECT 1", nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+	var failed *QueryFailedError
+	require.True(t, errors.As(err, &failed))
+	assert.Equal(t, QueryFailedError{QueryID: "query_4", State: athena.QueryExecutionStateCancelled}, *failed)
+	assert.EqualError(t, err, "query query_4 was cancelled")
+}
+
+func TestConn_fallbackToAPIOnCTASError(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults([]*athena.ColumnInfo{genColumnInfo("name")}, []*string{aws.String("a")}),
+		failQuery: func(query string) string {
+			if strings.HasPrefix(query, "CREATE TABLE") {
+				return "NOT_SUPPORTED: Unsupported Hive type: unknown"
+			}
+			return ""
+		},
+	}
+	var logs strings.Builder
+	c := &conn{
+		athena:                   client,
+		OutputLocation:           "s3://bucket",
+		resultMode:               ResultModeGzipDL,
+		timeout:                  timeOutLimitDefault,
+		logger:                   log.New(&logs, "", 0),
+		fallbackToAPIOnCTASError: true,
+	}
+
+	rows, err := c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	require.Len(t, client.startInputs, 2)
+	assert.True(t, strings.HasPrefix(*client.startInputs[0].QueryString, "CREATE TABLE"))
+	assert.Equal(t, "SELECT name FROM t", *client.startInputs[1].QueryString)
+	assert.Contains(t, logs.String(), "CTAS of query query_1 failed, running the query in API mode")
+
+	_, ok := rows.(*rowsAPI)
+	require.True(t, ok)
+	dest := make([]driver.Value, 1)
+	require.NoError(t, rows.Next(dest))
+	assert.Equal(t, []driver.Value{"a"}, dest)
+	assert.Equal(t, io.EOF, rows.Next(dest))
+
+	// off by default
+	c.fallbackToAPIOnCTASError = false
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	assert.EqualError(t, err, "NOT_SUPPORTED: Unsupported Hive type: unknown")
+	assert.Len(t, client.startInputs, 3)
+}
+
+func TestConn_outputLocation(t *testing.T) {
+	client := mockAthenaHarnessClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(harnessColumns)}}}
+	db := newHarnessDB(t, client, map[string][]byte{
+		"isolated/large/query_1.csv":                 []byte("\"id\",\"name\",\"note\"\n\"1\",\"a\",\n"),
+		"isolated/large/tables/query_2-manifest.csv": []byte("s3://isolated/large/tables/query_2/part-0.gz\n"),
+		"isolated/large/tables/query_2/part-0.gz":    gzipData(t, "2\001b\001\\N\n"),
+		"bucket/results/query_4.csv":                 []byte("\"id\",\"name\",\"note\"\n\"3\",\"c\",\n"),
+	}, ResultModeDL)
+	defer db.Close()
+
+	ctx := SetOutputLocation(context.Background(), "s3://isolated/large/")
+	assert.Equal(t, [][]interface{}{{int64(1), "a", nil}}, scanHarnessRows(t, db, ctx))
+	assert.Equal(t, [][]interface{}{{int64(2), "b", nil}}, scanHarnessRows(t, db, SetGzipDLMode(ctx)))
+	// the CTAS and its DROP are both written to the location
+	for _, input := range client.startInputs {
+		assert.Equal(t, "s3://isolated/large/", *input.ResultConfiguration.OutputLocation)
+	}
+
+	// the location of the connection otherwise
+	assert.Equal(t, [][]interface{}{{int64(3), "c", nil}}, scanHarnessRows(t, db, context.Background()))
+	assert.Equal(t, "s3://bucket/results", *client.startInputs[3].ResultConfiguration.OutputLocation)
+
+	_, err := db.QueryContext(SetOutputLocation(context.Background(), "isolated/large"), "SELECT 1")
+	assert.EqualError(t, err, `invalid output location "isolated/large": it must start with s3://`)
+	assert.Len(t, client.startInputs, 4)
+}
+
+func TestConn_queueTimeout(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := &mockAthenaConnClient{state: athena.QueryExecutionStateQueued}
+	clock := &fakeClock{now: start}
+	c := &conn{athena: client, pollFrequency: 5 * time.Second, timeout: 600, queueTimeout: 30 * time.Second, clock: clock}
+
+	_, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+	assert.True(t, errors.Is(err, ErrQueuedTooLong))
+	assert.False(t, errors.Is(err, ErrQueryTimeout))
+	var queuedErr *QueuedTooLongError
+	require.True(t, errors.As(err, &queuedErr))
+	assert.Equal(t, "query_1", queuedErr.QueryID)
+	assert.Equal(t, "query query_1 was still queued after 30s", err.Error())
+	assert.Equal(t, []string{"query_1"}, client.stopped)
+	assert.Equal(t, 30*time.Second, clock.Since(start))
+
+	// running queries are limited by the timeout only
+	client.state = athena.QueryExecutionStateRunning
+	client.states = []string{athena.QueryExecutionStateQueued, athena.QueryExecutionStateQueued}
+	clock.now = start
+	_, err = c.QueryContext(context.Background(), "SELECT 1", nil)
+	assert.True(t, errors.Is(err, ErrQueryTimeout))
+	assert.Equal(t, 10*time.Minute, clock.Since(start))
+
+	// queued up to the timeout without a queue timeout
+	client.state = athena.QueryExecutionStateQueued
+	clock.now = start
+	c.queueTimeout = 0
+	_, err = c.QueryContext(context.Background(), "SELECT 1", nil)
+	assert.True(t, errors.Is(err, ErrQueryTimeout))
+}
+
+func TestConn_encryption(t *testing.T) {
+	client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}
+	c := &conn{athena: client, OutputLocation: "s3://bucket/results", timeout: timeOutLimitDefault,
+		encryptionType: athena.EncryptionOptionSseKms, kmsKey: "alias/results"}
+
+	_, err := c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, &athena.EncryptionConfiguration{
+		EncryptionOption: aws.String(athena.EncryptionOptionSseKms),
+		KmsKey:           aws.String("alias/results"),
+	}, client.startInputs[0].ResultConfiguration.EncryptionConfiguration)
+
+	c.encryptionType, c.kmsKey = athena.EncryptionOptionSseS3, ""
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, &athena.EncryptionConfiguration{
+		EncryptionOption: aws.String(athena.EncryptionOptionSseS3),
+	}, client.startInputs[1].ResultConfiguration.EncryptionConfiguration)
+
+	// CSE_KMS results can't be downloaded, which fails before running the query
+	c.encryptionType, c.kmsKey = athena.EncryptionOptionCseKms, "alias/results"
+	for _, ctx := range []context.Context{SetDLMode(context.Background()), SetGzipDLMode(context.Background())} {
+		_, err = c.QueryContext(ctx, "SELECT name FROM t", nil)
+		assert.True(t, errors.Is(err, ErrClientSideEncryption))
+	}
+	assert.Len(t, client.startInputs, 2)
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+
+	// the encryption of the workgroup otherwise
+	c.encryptionType, c.kmsKey = "", ""
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Nil(t, client.startInputs[3].ResultConfiguration.EncryptionConfiguration)
+}
+
+func TestConn_outputLocationRequired(t *testing.T) {
+	client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}
+	c := &conn{athena: client, resultMode: ResultModeAPI, timeout: timeOutLimitDefault, logger: log.New(new(strings.Builder), "", 0)}
+
+	_, err := c.QueryContext(SetGzipDLMode(context.Background()), "SELECT name FROM t", nil)
+	assert.Equal(t, ErrOutputLocationRequired, err)
+	_, err = c.QueryContext(SetDLMode(context.Background()), "SELECT name FROM t", nil)
+	assert.Equal(t, ErrOutputLocationRequired, err)
+	assert.Empty(t, client.startInputs)
+
+	// API mode and statements run in API mode don't need it
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	assert.NoError(t, err)
+	_, err = c.QueryContext(SetGzipDLMode(context.Background()), "SHOW TABLES", nil)
+	assert.NoError(t, err)
+}
+
+func TestConn_queryID(t *testing.T) {
+	client := mockAthenaHarnessClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{
+		results: genResults(harnessColumns),
+	}}}
+	objects := map[string][]byte{
+		"bucket/results/query_2.csv":                 []byte("\"id\",\"name\",\"note\"\n"),
+		"bucket/results/tables/query_3-manifest.csv": []byte(""),
+	}
+
+	for i, mode := range []ResultMode{ResultModeAPI, ResultModeDL, ResultModeGzipDL} {
+		db := newHarnessDB(t, client, objects, mode)
+		ctx := SetCaptureQueryID(context.Background())
+		rows, err := db.QueryContext(ctx, "SELECT id, name, note FROM t")
+		require.NoError(t, err, mode)
+		require.NoError(t, DrainRows(rows), mode)
+
+		want := fmt.Sprintf("query_%d", i+1)
+		id, ok := LastQueryID(ctx)
+		assert.True(t, ok, mode)
+		assert.Equal(t, want, id, mode)
+		db.Close()
+	}
+
+	// the rows of the driver provide it as well
+	c := &conn{athena: client, timeout: timeOutLimitDefault}
+	rows, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "query_5", rows.(QueryIDProvider).QueryID())
+
+	c.rowProfiler = func(int, time.Duration) {}
+	rows, err = c.QueryContext(context.Background(), "SELECT 1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "query_6", rows.(QueryIDProvider).QueryID())
+
+	_, ok := LastQueryID(context.Background())
+	assert.False(t, ok)
+}
+
+func TestConn_resultModeNotApplicable(t *testing.T) {
+	var logs strings.Builder
+	client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("tab_name")})}
+	c := &conn{
+		athena:         client,
+		resultMode:     ResultModeGzipDL,
+		OutputLocation: "s3://bucket/results",
+		timeout:        timeOutLimitDefault,
+		logger:         log.New(&logs, "", 0),
+	}
+
+	// the mode of the connection is for SELECT
+	_, err := c.QueryContext(context.Background(), "SHOW TABLES", nil)
+	require.NoError(t, err)
+	assert.Empty(t, logs.String())
+
+	_, err = c.QueryContext(SetGzipDLMode(context.Background()), "SHOW TABLES", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "result mode is not applicable to the statement: running the statement in API mode rather than GZIP DL mode\n", logs.String())
+	require.Len(t, client.startInputs, 2)
+	assert.Equal(t, "SHOW TABLES", *client.startInputs[1].QueryString)
+}
+
+func Test_detachedContext(t *testing.T) {
+	type key struct{}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), key{}, "span"), time.Millisecond)
+	cancel()
+
+	detached := detachedContext{ctx}
+	assert.NoError(t, detached.Err())
+	assert.Nil(t, detached.Done())
+	_, ok := detached.Deadline()
+	assert.False(t, ok)
+	assert.Equal(t, "span", detached.Value(key{}))
+}
+
+func Test_pollBackoff(t *testing.T) {
+	// constant without a ceiling
+	b := pollBackoff{interval: time.Second}
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, time.Second, b.next())
+	}
+
+	b = pollBackoff{interval: time.Second, max: 10 * time.Second}
+	for i, want := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		wait := b.next()
+		want *= time.Second
+		assert.True(t, wait >= want && wait <= want+want/10 && wait <= 10*time.Second, "poll %d waited %s", i, wait)
+	}
+}
+
+func TestConn_pollBackoff(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	running := []string{
+		athena.QueryExecutionStateQueued,
+		athena.QueryExecutionStateRunning,
+		athena.QueryExecutionStateRunning,
+		athena.QueryExecutionStateRunning,
+		athena.QueryExecutionStateRunning,
+	}
+	client := &mockAthenaConnClient{
+		results: genResults([]*athena.ColumnInfo{genColumnInfo("name")}),
+		states:  append([]string(nil), running...),
+	}
+	clock := &fakeClock{now: start}
+	c := &conn{athena: client, pollFrequency: time.Second, pollBackoffMax: 4 * time.Second, timeout: timeOutLimitDefault, clock: clock}
+
+	_, err := c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	// waited about 1+2+4+4+4 seconds over the five polls before it succeeded
+	waited := clock.Since(start)
+	assert.True(t, waited >= 15*time.Second && waited <= 16*time.Second+700*time.Millisecond, "waited %s", waited)
+
+	// 5 seconds without the ceiling
+	client.states = append([]string(nil), running...)
+	clock.now = start
+	c.pollBackoffMax = 0
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, clock.Since(start))
+}
+
+// mockAthenaGatedClient blocks polls until they are released.
+type mockAthenaGatedClient struct {
+	*mockAthenaConnClient
+	polling chan string
+	release chan struct{}
+}
+
+func (m *mockAthenaGatedClient) GetQueryExecutionWithContext(ctx aws.Context, input *athena.GetQueryExecutionInput, opts ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	m.polling <- *input.QueryExecutionId
+	<-m.release
+	return m.mockAthenaConnClient.GetQueryExecutionWithContext(ctx, input, opts...)
+}
+
+func TestConn_maxConcurrentQueries(t *testing.T) {
+	client := &mockAthenaGatedClient{
+		mockAthenaConnClient: &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})},
+		polling:              make(chan string),
+		release:              make(chan struct{}),
+	}
+	db := newMockDB(t, client, Config{MaxConcurrentQueries: 2})
+	defer db.Close()
+
+	errs := make(chan error, 3)
+	query := func() {
+		rows, err := db.QueryContext(context.Background(), "SELECT name FROM t")
+		if err == nil {
+			err = rows.Close()
+		}
+		errs <- err
+	}
+	for i := 0; i < 3; i++ {
+		go query()
+	}
+
+	started := func() int {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.startInputs)
+	}
+
+	// two queries run while the third waits for a slot
+	<-client.polling
+	<-client.polling
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 2, started())
+
+	// waiting for a slot ends with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := db.QueryContext(ctx, "SELECT name FROM t")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 2, started())
+
+	// the third query starts once one finishes
+	client.release <- struct{}{}
+	require.NoError(t, <-errs)
+	<-client.polling
+	assert.Equal(t, 3, started())
+
+	client.release <- struct{}{}
+	client.release <- struct{}{}
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+}
+
+func TestConn_resultReuse(t *testing.T) {
+	client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}
+	c := &conn{athena: client, timeout: timeOutLimitDefault, OutputLocation: "s3://bucket/results"}
+
+	reuse := func(i int) *athena.ResultReuseConfiguration {
+		return client.startInputs[i].ResultReuseConfiguration
+	}
+	byAge := func(minutes int64) *athena.ResultReuseConfiguration {
+		return &athena.ResultReuseConfiguration{
+			ResultReuseByAgeConfiguration: &athena.ResultReuseByAgeConfiguration{
+				Enabled:         aws.Bool(true),
+				MaxAgeInMinutes: aws.Int64(minutes),
+			},
+		}
+	}
+
+	// not set by default
+	_, err := c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Nil(t, reuse(0))
+
+	c.resultReuseMaxAge = time.Hour
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, byAge(60), reuse(1))
+
+	// the context takes precedence, rounded up to minutes
+	_, err = c.QueryContext(SetResultReuse(context.Background(), 90*time.Second), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, byAge(2), reuse(2))
+	_, err = c.QueryContext(SetResultReuse(context.Background(), 0), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Nil(t, reuse(3))
+
+	// statements other than SELECT, including the CTAS of GZIP DL mode, don't reuse results
+	_, err = c.QueryContext(context.Background(), "SHOW TABLES", nil)
+	require.NoError(t, err)
+	assert.Nil(t, reuse(4))
+	ctasQuery, err := buildCTASQuery("tmp_ctas", "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	_, err = c.startQuery(context.Background(), ctasQuery)
+	require.NoError(t, err)
+	assert.Nil(t, reuse(5))
+
+	// engine version 2 can't reuse results, unlike version 3
+	var logs strings.Builder
+	c.logger = log.New(&logs, "", 0)
+	c.engineVersion = 2
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Nil(t, reuse(6))
+	assert.Equal(t, "engine version 2 can't reuse results, running the query without reusing them\n", logs.String())
+	c.engineVersion = 3
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, byAge(60), reuse(7))
+}
+
+// mockAthenaThrottledClient fails the first calls of every Athena API it
+// mocks with err.
+type mockAthenaThrottledClient struct {
+	*mockAthenaConnClient
+	err      error
+	failures int
+	calls    map[string]int
+}
+
+func (m *mockAthenaThrottledClient) fail(api string) error {
+	m.calls[api]++
+	if m.calls[api] <= m.failures {
+		return m.err
+	}
+	return nil
+}
+
+func (m *mockAthenaThrottledClient) StartQueryExecutionWithContext(ctx aws.Context, input *athena.StartQueryExecutionInput, opts ...request.Option) (*athena.StartQueryExecutionOutput, error) {
+	if err := m.fail("StartQueryExecution"); err != nil {
+		return nil, err
+	}
+	return m.mockAthenaConnClient.StartQueryExecutionWithContext(ctx, input, opts...)
+}
+
+func (m *mockAthenaThrottledClient) GetQueryExecutionWithContext(ctx aws.Context, input *athena.GetQueryExecutionInput, opts ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	if err := m.fail("GetQueryExecution"); err != nil {
+		return nil, err
+	}
+	return m.mockAthenaConnClient.GetQueryExecutionWithContext(ctx, input, opts...)
+}
+
+func (m *mockAthenaThrottledClient) GetQueryResultsWithContext(ctx aws.Context, input *athena.GetQueryResultsInput, opts ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	if err := m.fail("GetQueryResults"); err != nil {
+		return nil, err
+	}
+	return m.mockAthenaConnClient.GetQueryResultsWithContext(ctx, input, opts...)
+}
+
+func TestConn_maxRetries(t *testing.T) {
+	newClient := func(err error) *mockAthenaThrottledClient {
+		return &mockAthenaThrottledClient{
+			mockAthenaConnClient: &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")}, []*string{aws.String("a")})},
+			err:                  err,
+			failures:             2,
+			calls:                map[string]int{},
+		}
+	}
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	client := newClient(throttled)
+	c := &conn{athena: client, pollFrequency: time.Second, timeout: timeOutLimitDefault, clock: clock, maxRetries: 2}
+	rows, err := c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+	dest := make([]driver.Value, 1)
+	require.NoError(t, rows.Next(dest))
+	assert.Equal(t, "a", dest[0])
+	assert.Equal(t, map[string]int{"StartQueryExecution": 3, "GetQueryExecution": 3, "GetQueryResults": 3}, client.calls)
+	// the token of the query is kept across retries, so it starts once
+	assert.Len(t, client.startInputs, 1)
+
+	// server errors are retried as well
+	client = newClient(awserr.NewRequestFailure(awserr.New("InternalServerException", "oops", nil), 500, "req"))
+	c.athena = client
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	require.NoError(t, err)
+
+	// not retried without max retries
+	client = newClient(throttled)
+	c.athena = client
+	c.maxRetries = 0
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	assert.True(t, errors.Is(err, ErrThrottled))
+	assert.Equal(t, map[string]int{"StartQueryExecution": 1}, client.calls)
+
+	// other errors pass through unchanged
+	client = newClient(awserr.New(athena.ErrCodeInvalidRequestException, "line 1:8: mismatched input", nil))
+	c.athena = client
+	c.maxRetries = 2
+	_, err = c.QueryContext(context.Background(), "SELECT name FROM t", nil)
+	assert.True(t, errors.Is(err, ErrInvalidSQL))
+	assert.Equal(t, map[string]int{"StartQueryExecution": 1}, client.calls)
+}
@@ -0,0 +1,194 @@
+package athena
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// connector makes connections sharing a single Athena and S3 client, so the
+// connections pooled by sql.DB are cheap to create and to close.
+type connector struct {
+	cfg *Config
+
+	athenaOnce sync.Once
+	athena     athenaiface.AthenaAPI
+
+	s3Once sync.Once
+	s3     s3iface.S3API
+
+	// slots limits the queries running on the connections at once
+	slots querySlots
+}
+
+// NewConnector returns a driver.Connector for cfg, to be used with sql.OpenDB.
+// Unlike registering a driver per Config, connectors can be created as often
+// as needed without leaking anything into database/sql.
+//
+//	db := sql.OpenDB(athena.NewConnector(cfg))
+//
+// An invalid cfg is reported when the first connection is made.
+func NewConnector(cfg Config) driver.Connector {
+	if cfg.WorkGroup == "" {
+		cfg.WorkGroup = "primary"
+	}
+
+	if cfg.PollFrequency == 0 {
+		cfg.PollFrequency = 5 * time.Second
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = timeOutLimitDefault
+	}
+
+	return &connector{cfg: &cfg, slots: newQuerySlots(cfg.MaxConcurrentQueries)}
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cfg := c.cfg
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	sess, athenaClient, s3Client, err := c.clients(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	outputLocation := cfg.OutputLocation
+	if outputLocation == "" {
+		outputLocation, err = getOutputLocation(ctx, athenaClient, aws.StringValue(sess.Config.Region), cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var engineVersion int
+	if cfg.DetectEngineVersion {
+		engineVersion, err = getEngineVersion(ctx, athenaClient, cfg.WorkGroup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &conn{
+		athena:         athenaClient,
+		db:             cfg.Database,
+		OutputLocation: outputLocation,
+		pollFrequency:  cfg.PollFrequency,
+		pollBackoffMax: cfg.PollBackoffMax,
+		workgroup:      cfg.WorkGroup,
+		resultMode:     cfg.ResultMode,
+		s3:             s3Client,
+		timeout:        cfg.Timeout,
+		catalog:        cfg.Catalog,
+		ctasProperties: cfg.CTASProperties,
+
+		idempotencyKeyFunc: cfg.IdempotencyKeyFunc,
+		resultKeyTemplate:  cfg.ResultKeyTemplate,
+		maxQueryLength:     cfg.MaxQueryLength,
+		resultEncoding:     cfg.ResultEncoding,
+		logger:             cfg.Logger,
+		engineVersion:      engineVersion,
+		readOnly:           cfg.ReadOnly,
+
+		retryOnInternalError: cfg.RetryOnInternalError,
+		bytesScannedCutoff:   cfg.BytesScannedCutoff,
+
+		fallbackToAPIOnCTASError: cfg.FallbackToAPIOnCTASError,
+		rowProfiler:              cfg.RowProfiler,
+		csvDelimiter:             csvRune(cfg.CSVDelimiter),
+		csvQuote:                 csvRune(cfg.CSVQuote),
+		resultReuseMaxAge:        cfg.ResultReuseMaxAge,
+		downloadConcurrency:      cfg.DownloadConcurrency,
+		maxRetries:               cfg.MaxRetries,
+		queueTimeout:             cfg.QueueTimeout,
+		encryptionType:           cfg.EncryptionType,
+		kmsKey:                   cfg.KMSKey,
+		autoIdempotency:          cfg.AutoIdempotency,
+		slots:                    c.slots,
+	}, nil
+}
+
+// clients returns the session and the clients of a new connection, which are
+// made from the session of SessionProvider when it's set, so that every
+// connection gets fresh credentials, and are shared otherwise.
+func (c *connector) clients(ctx context.Context) (*session.Session, athenaiface.AthenaAPI, s3iface.S3API, error) {
+	if c.cfg.SessionProvider == nil {
+		return c.cfg.Session, c.client(), c.s3Client(), nil
+	}
+
+	sess, err := c.cfg.SessionProvider(ctx)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if sess == nil {
+		return nil, nil, nil, errors.New("session provider returned no session")
+	}
+	return sess, athena.New(sess), s3.New(sess), nil
+}
+
+func (c *connector) client() athenaiface.AthenaAPI {
+	c.athenaOnce.Do(func() {
+		if c.athena == nil {
+			c.athena = athena.New(c.cfg.Session)
+		}
+	})
+	return c.athena
+}
+
+func (c *connector) s3Client() s3iface.S3API {
+	c.s3Once.Do(func() {
+		if c.s3 == nil {
+			c.s3 = s3.New(c.cfg.Session)
+		}
+	})
+	return c.s3
+}
+
+func (c *connector) Driver() driver.Driver {
+	return &Driver{c.cfg}
+}
+
+var _ driver.Connector = (*connector)(nil)
+
+// querySlots is a semaphore of running queries, which doesn't limit them
+// when it's nil.
+type querySlots chan struct{}
+
+func newQuerySlots(n int) querySlots {
+	if n <= 0 {
+		return nil
+	}
+	return make(querySlots, n)
+}
+
+// acquire blocks until a slot is free or ctx is done.
+func (s querySlots) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s querySlots) release() {
+	if s != nil {
+		<-s
+	}
+}
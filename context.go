@@ -1,6 +1,11 @@
 package athena
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/athena"
+)
 
 const contextPrefix string = "go-athena"
 
@@ -70,3 +75,505 @@ func getCatalog(ctx context.Context) (string, bool) {
 	val, ok := ctx.Value(CatalogContextKey).(string)
 	return val, ok
 }
+
+/*
+ * output location
+ */
+
+const outputLocationContextKey string = "output_location_key"
+
+// OutputLocationContextKey context key of setting output location
+var OutputLocationContextKey string = contextPrefix + outputLocationContextKey
+
+// SetOutputLocation make queries run with the returned context write their
+// results to location, e.g. "s3://bucket/isolated/", instead of the output
+// location of the connection. DL and GZIP DL modes download them from there.
+func SetOutputLocation(ctx context.Context, location string) context.Context {
+	return context.WithValue(ctx, OutputLocationContextKey, location)
+}
+
+func getContextOutputLocation(ctx context.Context) (string, bool) {
+	val, ok := ctx.Value(OutputLocationContextKey).(string)
+	return val, ok
+}
+
+/*
+ * ctas properties
+ */
+
+const ctasPropertiesContextKey string = "ctas_properties_key"
+
+// CTASPropertiesContextKey context key of setting ctas properties
+var CTASPropertiesContextKey string = contextPrefix + ctasPropertiesContextKey
+
+// SetCTASProperties set table properties of the CTAS query in GZIP DL mode from context.
+// They are merged over Config.CTASProperties.
+func SetCTASProperties(ctx context.Context, props map[string]string) context.Context {
+	return context.WithValue(ctx, CTASPropertiesContextKey, props)
+}
+
+func getCTASProperties(ctx context.Context) (map[string]string, bool) {
+	val, ok := ctx.Value(CTASPropertiesContextKey).(map[string]string)
+	return val, ok
+}
+
+/*
+ * raw response
+ */
+
+const rawResponseContextKey string = "raw_response_key"
+
+// RawResponseContextKey context key of capturing raw responses
+var RawResponseContextKey string = contextPrefix + rawResponseContextKey
+
+// SetCaptureRawResponse make the rows of a query run with the returned context
+// retain the last GetQueryResults response in API and DL Mode.
+// It's a debugging aid, read the response with RawResult.
+func SetCaptureRawResponse(ctx context.Context) context.Context {
+	return context.WithValue(ctx, RawResponseContextKey, &rawResponse{})
+}
+
+// RawResult returns the last GetQueryResults response of the query run with ctx,
+// which must be made by SetCaptureRawResponse.
+func RawResult(ctx context.Context) (*athena.GetQueryResultsOutput, bool) {
+	raw, ok := getRawResponse(ctx)
+	if !ok || raw.out == nil {
+		return nil, false
+	}
+	return raw.out, true
+}
+
+func getRawResponse(ctx context.Context) (*rawResponse, bool) {
+	val, ok := ctx.Value(RawResponseContextKey).(*rawResponse)
+	return val, ok
+}
+
+/*
+ * column info
+ */
+
+const columnInfoContextKey string = "column_info_key"
+
+// ColumnInfoContextKey context key of capturing column metadata
+var ColumnInfoContextKey string = contextPrefix + columnInfoContextKey
+
+// SetCaptureColumnInfos make a query run with the returned context keep the
+// metadata of its result columns, which can be read with ColumnInfos.
+func SetCaptureColumnInfos(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ColumnInfoContextKey, &columnInfoCapture{})
+}
+
+// ColumnInfos returns the result column metadata of the query run with ctx,
+// which must be made by SetCaptureColumnInfos.
+func ColumnInfos(ctx context.Context) ([]ColumnInfo, bool) {
+	capture, ok := getColumnInfoCapture(ctx)
+	if !ok || capture.infos == nil {
+		return nil, false
+	}
+	return capture.infos, true
+}
+
+func getColumnInfoCapture(ctx context.Context) (*columnInfoCapture, bool) {
+	val, ok := ctx.Value(ColumnInfoContextKey).(*columnInfoCapture)
+	return val, ok
+}
+
+/*
+ * downloaded bytes
+ */
+
+const downloadedBytesContextKey string = "downloaded_bytes_key"
+
+// DownloadedBytesContextKey context key of capturing downloaded bytes
+var DownloadedBytesContextKey string = contextPrefix + downloadedBytesContextKey
+
+// SetCaptureDownloadedBytes make a query run with the returned context count
+// the bytes of the result objects it downloads in DL and GZIP DL Mode.
+// Read the count with DownloadedBytes.
+func SetCaptureDownloadedBytes(ctx context.Context) context.Context {
+	return context.WithValue(ctx, DownloadedBytesContextKey, &downloadedBytes{})
+}
+
+// DownloadedBytes returns the bytes downloaded from S3 by the query run with ctx,
+// which must be made by SetCaptureDownloadedBytes.
+func DownloadedBytes(ctx context.Context) (int64, bool) {
+	d, ok := getDownloadedBytes(ctx)
+	if !ok {
+		return 0, false
+	}
+	return d.get(), true
+}
+
+func getDownloadedBytes(ctx context.Context) (*downloadedBytes, bool) {
+	val, ok := ctx.Value(DownloadedBytesContextKey).(*downloadedBytes)
+	return val, ok
+}
+
+/*
+ * query id
+ */
+
+const queryIDContextKey string = "query_id_key"
+
+// QueryIDContextKey context key of capturing the query execution ID
+var QueryIDContextKey string = contextPrefix + queryIDContextKey
+
+// SetCaptureQueryID make queries run with the returned context keep their
+// query execution ID, e.g. to correlate logs or find the query in the console.
+// Read it with LastQueryID.
+func SetCaptureQueryID(ctx context.Context) context.Context {
+	return context.WithValue(ctx, QueryIDContextKey, &queryIDCapture{})
+}
+
+// LastQueryID returns the ID of the last query execution started with ctx,
+// which must be made by SetCaptureQueryID. When a query is run again, e.g.
+// by RetryOnInternalError, it's the ID of the last run. In GZIP DL Mode
+// it's the ID of the CTAS statement. The ID is kept when the query fails.
+func LastQueryID(ctx context.Context) (string, bool) {
+	q, ok := getQueryIDCapture(ctx)
+	if !ok {
+		return "", false
+	}
+	return q.get(), true
+}
+
+func getQueryIDCapture(ctx context.Context) (*queryIDCapture, bool) {
+	val, ok := ctx.Value(QueryIDContextKey).(*queryIDCapture)
+	return val, ok
+}
+
+/*
+ * client request token
+ */
+
+const clientRequestTokenContextKey string = "client_request_token_key"
+
+// ClientRequestTokenContextKey context key of setting the client request token
+var ClientRequestTokenContextKey string = contextPrefix + clientRequestTokenContextKey
+
+// SetClientRequestToken make a query run with the returned context submitted
+// with token as its ClientRequestToken, so that submitting it again with the
+// same token, e.g. from a retried job, returns the same execution rather than
+// running the query twice. Athena accepts tokens of 32 to 128 characters,
+// and the query fails before it's submitted otherwise. The statements the
+// driver runs on its own, e.g. dropping the CTAS table, get their own tokens.
+func SetClientRequestToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, ClientRequestTokenContextKey, token)
+}
+
+func getClientRequestToken(ctx context.Context) (string, bool) {
+	val, ok := ctx.Value(ClientRequestTokenContextKey).(string)
+	return val, ok
+}
+
+// withoutClientRequestToken returns ctx without the token set to it.
+func withoutClientRequestToken(ctx context.Context) context.Context {
+	if _, ok := getClientRequestToken(ctx); !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, ClientRequestTokenContextKey, nil)
+}
+
+/*
+ * attached query
+ */
+
+const attachedQueryIDContextKey string = "attached_query_id_key"
+
+// AttachedQueryIDContextKey context key of reading the result of a query started elsewhere
+var AttachedQueryIDContextKey string = contextPrefix + attachedQueryIDContextKey
+
+func getAttachedQueryID(ctx context.Context) (string, bool) {
+	val, ok := ctx.Value(AttachedQueryIDContextKey).(string)
+	return val, ok
+}
+
+/*
+ * statement type
+ */
+
+const statementTypeContextKey string = "statement_type_key"
+
+// StatementTypeContextKey context key of capturing the statement type
+var StatementTypeContextKey string = contextPrefix + statementTypeContextKey
+
+// SetCaptureStatementType make queries run with the returned context keep the
+// StatementType of their statement, e.g. for a tool running any statement to
+// tell whether it returns rows. Read it with LastStatementType.
+func SetCaptureStatementType(ctx context.Context) context.Context {
+	return context.WithValue(ctx, StatementTypeContextKey, &statementTypeCapture{})
+}
+
+// LastStatementType returns the StatementType of the last query that succeeded
+// with ctx, which must be made by SetCaptureStatementType. It's the type
+// Athena reports, or the type the driver tells from the statement otherwise.
+// In GZIP DL Mode it's the type of the SELECT rather than of the CTAS statement.
+func LastStatementType(ctx context.Context) (StatementType, bool) {
+	s, ok := getStatementTypeCapture(ctx)
+	if !ok {
+		return "", false
+	}
+	return s.get(), true
+}
+
+func getStatementTypeCapture(ctx context.Context) (*statementTypeCapture, bool) {
+	val, ok := ctx.Value(StatementTypeContextKey).(*statementTypeCapture)
+	return val, ok
+}
+
+/*
+ * statistics
+ */
+
+const statisticsContextKey string = "statistics_key"
+
+// StatisticsContextKey context key of capturing query statistics
+var StatisticsContextKey string = contextPrefix + statisticsContextKey
+
+// SetStatsCollector sets stats to be filled with the statistics of the query
+// run with the context once it succeeds. Unlike QueryWithStats, it works with
+// ExecContext as well.
+func SetStatsCollector(ctx context.Context, stats *Statistics) context.Context {
+	return context.WithValue(ctx, StatisticsContextKey, stats)
+}
+
+func getStatistics(ctx context.Context) (*Statistics, bool) {
+	val, ok := ctx.Value(StatisticsContextKey).(*Statistics)
+	return val, ok
+}
+
+/*
+ * progress
+ */
+
+const progressContextKey string = "progress_key"
+
+// ProgressContextKey context key of setting a progress callback
+var ProgressContextKey string = contextPrefix + progressContextKey
+
+// SetProgressCallback make a query run with the returned context call fn every
+// time the driver polls the state of the query, including the final state.
+func SetProgressCallback(ctx context.Context, fn func(QueryProgress)) context.Context {
+	return context.WithValue(ctx, ProgressContextKey, fn)
+}
+
+func getProgressCallback(ctx context.Context) (func(QueryProgress), bool) {
+	val, ok := ctx.Value(ProgressContextKey).(func(QueryProgress))
+	return val, ok
+}
+
+/*
+ * query comment
+ */
+
+const queryCommentContextKey string = "query_comment_key"
+
+// QueryCommentContextKey context key of setting a query comment
+var QueryCommentContextKey string = contextPrefix + queryCommentContextKey
+
+// SetQueryComment make queries run with the returned context start with
+// comment as a /* */ block, which shows in the query history of Athena.
+// It's also added to the queries the driver runs for them, e.g. in GZIP DL Mode.
+func SetQueryComment(ctx context.Context, comment string) context.Context {
+	return context.WithValue(ctx, QueryCommentContextKey, comment)
+}
+
+func getQueryComment(ctx context.Context) (string, bool) {
+	val, ok := ctx.Value(QueryCommentContextKey).(string)
+	return val, ok
+}
+
+/*
+ * bytes scanned cutoff
+ */
+
+const bytesScannedCutoffContextKey string = "bytes_scanned_cutoff_key"
+
+// BytesScannedCutoffContextKey context key of setting the bytes scanned cutoff
+var BytesScannedCutoffContextKey string = contextPrefix + bytesScannedCutoffContextKey
+
+// SetBytesScannedCutoff set the bytes a query may scan from context,
+// overriding Config.BytesScannedCutoff. 0 means no limit.
+func SetBytesScannedCutoff(ctx context.Context, cutoff uint64) context.Context {
+	return context.WithValue(ctx, BytesScannedCutoffContextKey, cutoff)
+}
+
+func getBytesScannedCutoff(ctx context.Context) (uint64, bool) {
+	val, ok := ctx.Value(BytesScannedCutoffContextKey).(uint64)
+	return val, ok
+}
+
+/*
+ * result reuse
+ */
+
+const resultReuseContextKey string = "result_reuse_key"
+
+// ResultReuseContextKey context key of setting the max age of reused results
+var ResultReuseContextKey string = contextPrefix + resultReuseContextKey
+
+// SetResultReuse set the max age of a previous result a SELECT may return
+// from context, overriding Config.ResultReuseMaxAge. 0 means not reusing results.
+func SetResultReuse(ctx context.Context, maxAge time.Duration) context.Context {
+	return context.WithValue(ctx, ResultReuseContextKey, maxAge)
+}
+
+func getResultReuse(ctx context.Context) (time.Duration, bool) {
+	val, ok := ctx.Value(ResultReuseContextKey).(time.Duration)
+	return val, ok
+}
+
+/*
+ * trim space as null
+ */
+
+const trimSpaceAsNullContextKey string = "trim_space_as_null_key"
+
+// TrimSpaceAsNullContextKey context key of treating whitespace fields as NULL
+var TrimSpaceAsNullContextKey string = contextPrefix + trimSpaceAsNullContextKey
+
+// SetTrimSpaceAsNull make a query run with the returned context in DL Mode
+// return NULL for unquoted fields of the result CSV with only whitespace.
+// By default only empty unquoted fields are NULL.
+func SetTrimSpaceAsNull(ctx context.Context) context.Context {
+	return context.WithValue(ctx, TrimSpaceAsNullContextKey, true)
+}
+
+func getTrimSpaceAsNull(ctx context.Context) (bool, bool) {
+	val, ok := ctx.Value(TrimSpaceAsNullContextKey).(bool)
+	return val, ok
+}
+
+/*
+ * normalize column names
+ */
+
+const normalizeColumnNamesContextKey string = "normalize_column_names_key"
+
+// NormalizeColumnNamesContextKey context key of lowercasing column names
+var NormalizeColumnNamesContextKey string = contextPrefix + normalizeColumnNamesContextKey
+
+// SetNormalizeColumnNames make the rows of a query run with the returned
+// context return lowercased column names from Columns in every mode, since
+// quoted aliases keep their case, e.g. SELECT 1 AS "UserID".
+func SetNormalizeColumnNames(ctx context.Context) context.Context {
+	return context.WithValue(ctx, NormalizeColumnNamesContextKey, true)
+}
+
+func getNormalizeColumnNames(ctx context.Context) (bool, bool) {
+	val, ok := ctx.Value(NormalizeColumnNamesContextKey).(bool)
+	return val, ok
+}
+
+/*
+ * result compression
+ */
+
+const resultCompressionContextKey string = "result_compression_key"
+
+// ResultCompressionContextKey context key of setting result compression
+var ResultCompressionContextKey string = contextPrefix + resultCompressionContextKey
+
+// SetResultCompression set the compression of the result CSV in DL Mode from
+// context, for results the driver can't detect the compression of.
+// ResultCompressionAuto is used by default.
+func SetResultCompression(ctx context.Context, compression ResultCompression) context.Context {
+	return context.WithValue(ctx, ResultCompressionContextKey, compression)
+}
+
+func getResultCompression(ctx context.Context) (ResultCompression, bool) {
+	val, ok := ctx.Value(ResultCompressionContextKey).(ResultCompression)
+	return val, ok
+}
+
+/*
+ * decimal mode
+ */
+
+const decimalModeContextKey string = "decimal_mode_key"
+
+// DecimalModeContextKey context key of returning decimals as decimal.Decimal
+var DecimalModeContextKey string = contextPrefix + decimalModeContextKey
+
+// SetDecimalMode make a query run with the returned context return decimal
+// columns as decimal.Decimal of github.com/shopspring/decimal, which keeps
+// every digit, rather than float64. database/sql can only scan them into an
+// interface{}, from which the decimal.Decimal can be taken.
+func SetDecimalMode(ctx context.Context) context.Context {
+	return context.WithValue(ctx, DecimalModeContextKey, true)
+}
+
+func getDecimalMode(ctx context.Context) (bool, bool) {
+	val, ok := ctx.Value(DecimalModeContextKey).(bool)
+	return val, ok
+}
+
+/*
+ * geometry mode
+ */
+
+const geometryModeContextKey string = "geometry_mode_key"
+
+// GeometryModeContextKey context key of returning geometries as Geometry
+var GeometryModeContextKey string = contextPrefix + geometryModeContextKey
+
+// SetGeometryMode make a query run with the returned context return geometry
+// columns as Geometry rather than their WKT string. database/sql can scan
+// them into a *Geometry or an interface{}.
+func SetGeometryMode(ctx context.Context) context.Context {
+	return context.WithValue(ctx, GeometryModeContextKey, true)
+}
+
+func getGeometryMode(ctx context.Context) (bool, bool) {
+	val, ok := ctx.Value(GeometryModeContextKey).(bool)
+	return val, ok
+}
+
+/*
+ * raw bytes mode
+ */
+
+const rawBytesModeContextKey string = "raw_bytes_mode_key"
+
+// RawBytesModeContextKey context key of returning values as their raw bytes
+var RawBytesModeContextKey string = contextPrefix + rawBytesModeContextKey
+
+// SetRawBytesMode make a query run with the returned context return every
+// value as the []byte of its text in the result, or nil for NULL, without
+// parsing it, for readers parsing values themselves. They can be scanned
+// into a sql.RawBytes, which is only valid until the next call of Next.
+// Values are as Athena writes them, e.g. "2006-01-02 15:04:05.000" for a
+// timestamp, and arrays and maps are in the format of the result mode.
+func SetRawBytesMode(ctx context.Context) context.Context {
+	return context.WithValue(ctx, RawBytesModeContextKey, true)
+}
+
+func getRawBytesMode(ctx context.Context) (bool, bool) {
+	val, ok := ctx.Value(RawBytesModeContextKey).(bool)
+	return val, ok
+}
+
+/*
+ * multi statement
+ */
+
+const multiStatementContextKey string = "multi_statement_key"
+
+// MultiStatementContextKey context key of running multiple statements
+var MultiStatementContextKey string = contextPrefix + multiStatementContextKey
+
+// SetMultiStatement make ExecContext with the returned context split the
+// query on semicolons outside of quotes and comments, and run the
+// statements one by one, e.g. those of a migration file. It stops at the
+// first failing statement with a MultiStatementError.
+// A client request token set to ctx is suffixed with the index of each
+// statement, e.g. "-0", since Athena runs a token only once. A token too
+// long for the suffix is cut to keep the 128 characters Athena accepts.
+func SetMultiStatement(ctx context.Context, multi bool) context.Context {
+	return context.WithValue(ctx, MultiStatementContextKey, multi)
+}
+
+func getMultiStatement(ctx context.Context) (bool, bool) {
+	val, ok := ctx.Value(MultiStatementContextKey).(bool)
+	return val, ok
+}
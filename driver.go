@@ -1,24 +1,22 @@
 package athena
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"log"
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/athena"
-)
-
-var (
-	openFromSessionMutex sync.Mutex
-	openFromSessionCount int
+	"golang.org/x/text/encoding"
 )
 
 const (
@@ -52,26 +50,53 @@ func init() {
 // This is the Athena database name. In the UI, this defaults to "default",
 // but the driver requires it regardless.
 //
-// - `output_location` (required)
+// - `output_location` (optional)
 // This is the S3 location Athena will dump query results in the format
 // "s3://bucket/and/so/forth". In the AWS UI, this defaults to
-// "s3://aws-athena-query-results-<ACCOUNTID>-<REGION>", but the driver requires it.
+// "s3://aws-athena-query-results-<ACCOUNTID>-<REGION>". When it's omitted, the
+// output location configured in the workgroup is used.
 //
 // - `poll_frequency` (optional)
 // Athena's API requires polling to retrieve query results. This is the frequency at
 // which the driver will poll for results. It should be a time/Duration.String().
 // A completely arbitrary default of "5s" was chosen.
 //
+// - `poll_backoff_max` (optional)
+// When set, the interval of polling starts at poll_frequency and doubles up to
+// this time/Duration.String(), e.g. "10s". See Config.PollBackoffMax.
+//
 // - `region` (optional)
 // Override AWS region. Useful if it is not set with environment variable.
 //
 // - `workgroup` (optional)
 // Athena's workgroup. This defaults to "primary".
 //
+// - `encryption_type` and `kms_key` (optional)
+// How query results are encrypted, one of "SSE_S3", "SSE_KMS" and "CSE_KMS",
+// and the KMS key of the KMS ones. See Config.EncryptionType.
+//
+// - `read_only` (optional)
+// When "true", statements other than SELECT, SHOW, DESCRIBE and EXPLAIN are
+// rejected with ErrReadOnly.
+//
+// - `max_retries` (optional)
+// How many times an Athena API call is retried when it's throttled or fails
+// with a server error. See Config.MaxRetries.
+//
 // Credentials must be accessible via the SDK's Default Credential Provider Chain.
 // For more advanced AWS credentials/session/config management, please supply
 // a custom AWS session directly via `athena.Open()`.
 func (d *Driver) Open(connStr string) (driver.Conn, error) {
+	c, err := d.OpenConnector(connStr)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector implements driver.DriverContext. connStr is parsed once and
+// shared by every connection made by the returned connector.
+func (d *Driver) OpenConnector(connStr string) (driver.Connector, error) {
 	cfg := d.cfg
 	if cfg == nil {
 		var err error
@@ -81,52 +106,26 @@ func (d *Driver) Open(connStr string) (driver.Conn, error) {
 		}
 	}
 
-	if cfg.PollFrequency == 0 {
-		cfg.PollFrequency = 5 * time.Second
-	}
-
-	return &conn{
-		athena:         athena.New(cfg.Session),
-		db:             cfg.Database,
-		OutputLocation: cfg.OutputLocation,
-		pollFrequency:  cfg.PollFrequency,
-		workgroup:      cfg.WorkGroup,
-		resultMode:     cfg.ResultMode,
-		session:        cfg.Session,
-		timeout:        cfg.Timeout,
-		catalog:        cfg.Catalog,
-	}, nil
+	return NewConnector(*cfg), nil
 }
 
+var _ driver.DriverContext = (*Driver)(nil)
+
 // Open is a more robust version of `db.Open`, as it accepts a raw aws.Session.
 // This is useful if you have a complex AWS session since the driver doesn't
 // currently attempt to serialize all options into a string.
 func Open(cfg Config) (*sql.DB, error) {
-	if cfg.Database == "" {
-		return nil, errors.New("db is required")
-	}
-
-	if cfg.OutputLocation == "" {
-		return nil, errors.New("s3_staging_url is required")
-	}
-
-	if cfg.Session == nil {
-		return nil, errors.New("session is required")
+	if err := cfg.validate(); err != nil {
+		return nil, err
 	}
 
-	if cfg.WorkGroup == "" {
-		cfg.WorkGroup = "primary"
+	if cfg.VerifyCredentials && cfg.Session != nil {
+		if err := cfg.verifyCredentials(); err != nil {
+			return nil, err
+		}
 	}
 
-	// This hack was copied from jackc/pgx. Sorry :(
-	// https://github.com/jackc/pgx/blob/70a284f4f33a9cc28fd1223f6b83fb00deecfe33/stdlib/sql.go#L130-L136
-	openFromSessionMutex.Lock()
-	openFromSessionCount++
-	name := fmt.Sprintf("athena-%d", openFromSessionCount)
-	openFromSessionMutex.Unlock()
-
-	sql.Register(name, &Driver{&cfg})
-	return sql.Open(name, "")
+	return sql.OpenDB(NewConnector(cfg)), nil
 }
 
 // Config is the input to Open().
@@ -138,9 +137,173 @@ type Config struct {
 
 	PollFrequency time.Duration
 
+	// PollBackoffMax makes the interval of polling start at PollFrequency and
+	// double up to PollBackoffMax, with a small random jitter, which saves
+	// polls of long queries. The interval stays PollFrequency when it's zero.
+	// It can't be less than PollFrequency.
+	PollBackoffMax time.Duration
+
 	ResultMode ResultMode
-	Timeout    uint
-	Catalog    string
+
+	// Timeout is how many seconds a query may run, and separately how long
+	// the download of its result may take. A query running longer is stopped
+	// with a QueryTimeoutError. In DL mode the result is downloaded as the
+	// rows are read, so it only limits opening the result, and the rows may
+	// be read for longer. This defaults to 1800.
+	Timeout uint
+
+	// QueueTimeout is how long a query may stay queued, e.g. while the
+	// workgroup runs as many queries as it may, before it's stopped with a
+	// QueuedTooLongError, which fails fast rather than waiting out Timeout.
+	// Queries may stay queued up to Timeout when it's zero.
+	QueueTimeout time.Duration
+
+	Catalog string
+
+	// CTASProperties are additional table properties for the CTAS query
+	// issued in GZIP DL mode, e.g. {"bucket_count": "10"}. Only bucketed_by,
+	// bucket_count and write_compression of GZIP can be set, since the
+	// others change the objects GZIP DL mode reads.
+	CTASProperties map[string]string
+
+	// SessionProvider returns the session of every new connection, e.g. with
+	// credentials that have been rotated since Open, instead of Session.
+	// Each connection then has its own clients rather than sharing them.
+	// Session is used when it's nil.
+	SessionProvider func(ctx context.Context) (*session.Session, error)
+
+	// VerifyCredentials makes Open fail when no credentials can be retrieved
+	// from Session. It's off by default since retrieving credentials may call
+	// out to a credential provider. The sessions of SessionProvider aren't
+	// verified.
+	VerifyCredentials bool
+
+	// IdempotencyKeyFunc derives the ClientRequestToken of a query, so that
+	// retried submissions of the same query aren't executed twice.
+	// The key must be at least 32 characters and is truncated to 128.
+	// A random token is used per query when it's nil.
+	IdempotencyKeyFunc func(query string) string
+
+	// AutoIdempotency makes the ClientRequestToken of a query the SHA-256 of
+	// its statement, so that a process retrying a query after a failure to
+	// submit it, e.g. after restarting, doesn't run it twice. Athena returns
+	// the earlier execution for the same token for a while, so the same
+	// statement run again soon after isn't run again either. It can't be
+	// used with IdempotencyKeyFunc, and SetClientRequestToken overrides it.
+	AutoIdempotency bool
+
+	// ResultKeyTemplate is the key of the result CSV of a query in DL mode,
+	// relative to OutputLocation. It must contain the `{queryID}` placeholder.
+	// It's only used when Athena doesn't report where the result was written.
+	// This defaults to "{queryID}.csv".
+	ResultKeyTemplate string
+
+	// OutputLocationCacheTTL is how long the output location of the workgroup
+	// is cached when OutputLocation is empty. This defaults to 5 minutes.
+	// The cache is shared by all connections with the same region and workgroup.
+	OutputLocationCacheTTL time.Duration
+
+	// DisableOutputLocationCache makes every new connection look up the output
+	// location of the workgroup when OutputLocation is empty.
+	DisableOutputLocationCache bool
+
+	// MaxQueryLength is the maximum size of a query in bytes. Longer queries
+	// fail with a QueryTooLongError without being submitted.
+	// This defaults to the limit of Athena, 262144 bytes.
+	MaxQueryLength int
+
+	// DetectEngineVersion makes new connections look up the Athena engine
+	// version of the workgroup, which features only available in newer
+	// engines depend on, e.g. ResultReuseMaxAge. The version can be read
+	// with EngineVersion. Such features are used when it's unknown.
+	DetectEngineVersion bool
+
+	// ResultEncoding is the character encoding of the result CSV in DL mode,
+	// e.g. charmap.ISO8859_1 of golang.org/x/text/encoding/charmap.
+	// This defaults to UTF-8.
+	ResultEncoding encoding.Encoding
+
+	// ReadOnly makes connections reject statements other than SELECT, SHOW,
+	// DESCRIBE and EXPLAIN with ErrReadOnly before they are submitted.
+	// EXPLAIN ANALYZE runs the statement, so it's only allowed of those.
+	ReadOnly bool
+
+	// RetryOnInternalError makes a query failing with an internal error of
+	// Athena be submitted once more as a new execution, since these errors
+	// are usually transient. The retry is billed like any other query.
+	RetryOnInternalError bool
+
+	// BytesScannedCutoff is the bytes a query may scan. Athena can only limit
+	// the bytes scanned per workgroup, so the driver stops a query once the
+	// statistics it polls exceed the cutoff, which may be after the query has
+	// scanned somewhat more. The query fails with a BytesScannedExceededError,
+	// as do queries cancelled by the cutoff of the workgroup.
+	// It can be overridden per query with SetBytesScannedCutoff.
+	BytesScannedCutoff uint64
+
+	// FallbackToAPIOnCTASError makes a SELECT in GZIP DL mode be run once more
+	// in API mode when Athena fails its CTAS statement, since some
+	// SELECTs can't be wrapped in CTAS. The downgrade is logged.
+	FallbackToAPIOnCTASError bool
+
+	// RowProfiler is called after each row is read with its index and the
+	// time Next took, which includes converting the values of the row and,
+	// in API mode, fetching the page the row is in. Rows aren't timed when
+	// it's nil.
+	RowProfiler func(rowIndex int, dur time.Duration)
+
+	// CSVDelimiter and CSVQuote are the field delimiter and quote character
+	// of the result CSV in DL mode, for results not written by Athena's
+	// standard CSV, e.g. "\t" and "'". Each must be a single character.
+	// These default to "," and `"`.
+	CSVDelimiter string
+	CSVQuote     string
+
+	// MaxConcurrentQueries is how many queries the connections of a connector
+	// may run at once, to stay within the active query quota of Athena.
+	// A query waits for a running one to finish, or for its context to be
+	// done. Queries aren't limited when it's zero.
+	MaxConcurrentQueries int
+
+	// ResultReuseMaxAge makes a SELECT return the result of a previous
+	// execution of the same query at most this old instead of scanning the
+	// data again, which isn't billed. It's counted in whole minutes, up to 7
+	// days. Results aren't reused when it's zero, and it can be overridden
+	// per query with SetResultReuse. The CTAS of GZIP DL mode can't reuse results.
+	// Neither can engine version 2, so it's ignored when DetectEngineVersion
+	// finds an older engine.
+	ResultReuseMaxAge time.Duration
+
+	// DownloadConcurrency is how many objects of the CTAS table of a query
+	// GZIP DL mode downloads at once, since a table is often written in many
+	// objects. This defaults to 4.
+	DownloadConcurrency int
+
+	// MaxRetries is how many times an Athena API call is retried when it's
+	// throttled, e.g. with ThrottlingException, or fails with a 5xx error.
+	// The wait before a retry doubles from 100ms up to 5s. Other errors are
+	// returned without retrying, as are all errors when it's zero.
+	MaxRetries int
+
+	// EncryptionType makes query results encrypted in S3, one of
+	// athena.EncryptionOptionSseS3, athena.EncryptionOptionSseKms and
+	// athena.EncryptionOptionCseKms, with KMSKey for the KMS ones, which is
+	// its ARN or ID. Results are encrypted as the workgroup has them when
+	// it's empty. S3 decrypts SSE results as DL and GZIP DL modes download
+	// them, but CSE_KMS results can only be read in API mode.
+	EncryptionType string
+	KMSKey         string
+
+	// Logger receives notices of the driver, e.g. when it falls back to another
+	// way of getting results. This defaults to the standard logger.
+	Logger *log.Logger
+}
+
+// ParseDSN returns the Config a connection string of Driver.Open is parsed
+// into, which is useful to check what the driver understood from it.
+// The region is that of Session.Config.Region.
+func ParseDSN(connStr string) (*Config, error) {
+	return configFromConnectionString(connStr)
 }
 
 func configFromConnectionString(connStr string) (*Config, error) {
@@ -175,6 +338,13 @@ func configFromConnectionString(connStr string) (*Config, error) {
 		}
 	}
 
+	if backoffStr := args.Get("poll_backoff_max"); backoffStr != "" {
+		cfg.PollBackoffMax, err = time.ParseDuration(backoffStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid poll_backoff_max parameter: %s", backoffStr)
+		}
+	}
+
 	cfg.ResultMode = ResultModeAPI
 	modeValue := strings.ToLower(args.Get("result_mode"))
 	switch {
@@ -186,9 +356,11 @@ func configFromConnectionString(connStr string) (*Config, error) {
 
 	cfg.Timeout = timeOutLimitDefault
 	if tm := args.Get("timeout"); tm != "" {
-		if timeout, err := strconv.ParseUint(tm, 10, 32); err != nil {
-			cfg.Timeout = uint(timeout)
+		timeout, err := strconv.ParseUint(tm, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout parameter: %s", tm)
 		}
+		cfg.Timeout = uint(timeout)
 	}
 
 	cfg.Catalog = CATALOG_AWS_DATA_CATALOG
@@ -196,5 +368,128 @@ func configFromConnectionString(connStr string) (*Config, error) {
 		cfg.Catalog = ct
 	}
 
+	if ro := args.Get("read_only"); ro != "" {
+		cfg.ReadOnly, err = strconv.ParseBool(ro)
+		if err != nil {
+			return nil, fmt.Errorf("invalid read_only parameter: %s", ro)
+		}
+	}
+
+	cfg.EncryptionType = args.Get("encryption_type")
+	cfg.KMSKey = args.Get("kms_key")
+
+	if mr := args.Get("max_retries"); mr != "" {
+		maxRetries, err := strconv.ParseUint(mr, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_retries parameter: %s", mr)
+		}
+		cfg.MaxRetries = int(maxRetries)
+	}
+
 	return &cfg, nil
 }
+
+func (cfg *Config) validate() error {
+	if cfg.Database == "" {
+		return errors.New("db is required")
+	}
+
+	if cfg.Session == nil && cfg.SessionProvider == nil {
+		return errors.New("session is required")
+	}
+
+	if cfg.PollBackoffMax != 0 && cfg.PollBackoffMax < cfg.PollFrequency {
+		return fmt.Errorf("poll backoff max %s is less than poll frequency %s", cfg.PollBackoffMax, cfg.PollFrequency)
+	}
+
+	if cfg.DownloadConcurrency < 0 {
+		return fmt.Errorf("invalid download concurrency: %d", cfg.DownloadConcurrency)
+	}
+
+	switch cfg.EncryptionType {
+	case "":
+		if cfg.KMSKey != "" {
+			return errors.New("kms key requires an encryption type")
+		}
+	case athena.EncryptionOptionSseS3:
+		if cfg.KMSKey != "" {
+			return fmt.Errorf("kms key can't be used with %s", cfg.EncryptionType)
+		}
+	case athena.EncryptionOptionSseKms, athena.EncryptionOptionCseKms:
+		if cfg.KMSKey == "" {
+			return fmt.Errorf("kms key is required for %s", cfg.EncryptionType)
+		}
+		if cfg.EncryptionType == athena.EncryptionOptionCseKms && cfg.ResultMode != ResultModeAPI {
+			return fmt.Errorf("%w in %s mode", ErrClientSideEncryption, cfg.ResultMode)
+		}
+	default:
+		return fmt.Errorf("invalid encryption type: %s", cfg.EncryptionType)
+	}
+
+	if cfg.AutoIdempotency && cfg.IdempotencyKeyFunc != nil {
+		return errors.New("auto idempotency can't be used with an idempotency key func")
+	}
+
+	if cfg.QueueTimeout < 0 {
+		return fmt.Errorf("invalid queue timeout: %s", cfg.QueueTimeout)
+	}
+
+	if cfg.MaxRetries < 0 {
+		return fmt.Errorf("invalid max retries: %d", cfg.MaxRetries)
+	}
+
+	if cfg.MaxConcurrentQueries < 0 {
+		return fmt.Errorf("invalid max concurrent queries: %d", cfg.MaxConcurrentQueries)
+	}
+
+	if cfg.ResultReuseMaxAge < 0 || cfg.ResultReuseMaxAge > resultReuseMaxAgeLimit {
+		return fmt.Errorf("result reuse max age must be between 0 and %s: %s", resultReuseMaxAgeLimit, cfg.ResultReuseMaxAge)
+	}
+
+	if cfg.ResultKeyTemplate != "" && !strings.Contains(cfg.ResultKeyTemplate, resultKeyQueryIDPlaceholder) {
+		return fmt.Errorf("result key template must contain %s", resultKeyQueryIDPlaceholder)
+	}
+
+	if err := validateCSVRune("csv delimiter", cfg.CSVDelimiter); err != nil {
+		return err
+	}
+	if err := validateCSVRune("csv quote", cfg.CSVQuote); err != nil {
+		return err
+	}
+	csv := csvOptions{delimiter: csvRune(cfg.CSVDelimiter), quote: csvRune(cfg.CSVQuote)}
+	if csv.delimiterRune() == csv.quoteRune() {
+		return errors.New("csv delimiter and quote must differ")
+	}
+
+	return nil
+}
+
+func validateCSVRune(name, s string) error {
+	if s != "" && (utf8.RuneCountInString(s) != 1 || s == "\n" || s == "\r") {
+		return fmt.Errorf("%s must be a single character other than a newline: %q", name, s)
+	}
+	return nil
+}
+
+// csvRune returns the character of a validated CSVDelimiter or CSVQuote,
+// or 0 when it's empty.
+func csvRune(s string) rune {
+	if s == "" {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+func (cfg *Config) verifyCredentials() error {
+	creds := cfg.Session.Config.Credentials
+	if creds == nil {
+		return errors.New("aws credentials are not configured")
+	}
+
+	if _, err := creds.Get(); err != nil {
+		return fmt.Errorf("failed to retrieve aws credentials: %w", err)
+	}
+
+	return nil
+}
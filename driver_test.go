@@ -0,0 +1,333 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen_doesNotRegisterDrivers(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	registered := len(sql.Drivers())
+	for i := 0; i < 100; i++ {
+		db, err := Open(Config{
+			Session:        sess,
+			Database:       AthenaDatabase,
+			OutputLocation: fmt.Sprintf("s3://%s", S3Bucket),
+		})
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+
+		db = sql.OpenDB(NewConnector(Config{
+			Session:        sess,
+			Database:       AthenaDatabase,
+			OutputLocation: fmt.Sprintf("s3://%s", S3Bucket),
+		}))
+		require.NoError(t, db.Close())
+	}
+	assert.Equal(t, registered, len(sql.Drivers()))
+}
+
+func TestConnector_Connect(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	c, err := NewConnector(Config{
+		Session:        sess,
+		Database:       AthenaDatabase,
+		OutputLocation: fmt.Sprintf("s3://%s", S3Bucket),
+	}).Connect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "primary", c.(*conn).workgroup)
+
+	_, err = NewConnector(Config{Session: sess}).Connect(context.Background())
+	assert.Error(t, err)
+}
+
+func TestConnector_sharesAthenaClient(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	db := sql.OpenDB(NewConnector(Config{
+		Session:        sess,
+		Database:       AthenaDatabase,
+		OutputLocation: fmt.Sprintf("s3://%s", S3Bucket),
+	}))
+	defer db.Close()
+
+	ctx := context.Background()
+	var clients []athenaiface.AthenaAPI
+	for i := 0; i < 3; i++ {
+		sc, err := db.Conn(ctx)
+		require.NoError(t, err)
+		defer sc.Close()
+
+		require.NoError(t, sc.Raw(func(driverConn interface{}) error {
+			clients = append(clients, driverConn.(*conn).athena)
+			return nil
+		}))
+	}
+
+	assert.Equal(t, 3, db.Stats().OpenConnections)
+	assert.NotNil(t, clients[0])
+	assert.True(t, clients[0] == clients[1] && clients[1] == clients[2])
+}
+
+func TestConnector_sessionProvider(t *testing.T) {
+	var regions = []string{"us-east-1", "ap-northeast-1"}
+	var calls int
+	db := sql.OpenDB(NewConnector(Config{
+		SessionProvider: func(ctx context.Context) (*session.Session, error) {
+			calls++
+			if calls > len(regions) {
+				return nil, errors.New("no more sessions")
+			}
+			return session.NewSession(&aws.Config{Region: aws.String(regions[calls-1])})
+		},
+		Database:       AthenaDatabase,
+		OutputLocation: fmt.Sprintf("s3://%s", S3Bucket),
+	}))
+	defer db.Close()
+
+	ctx := context.Background()
+	for _, region := range regions {
+		sc, err := db.Conn(ctx)
+		require.NoError(t, err)
+		defer sc.Close()
+
+		require.NoError(t, sc.Raw(func(driverConn interface{}) error {
+			assert.Equal(t, region, *driverConn.(*conn).athena.(*athena.Athena).Config.Region)
+			return nil
+		}))
+	}
+	assert.Equal(t, 2, calls)
+
+	_, err := db.Conn(ctx)
+	assert.EqualError(t, err, "failed to get session: no more sessions")
+}
+
+func TestOpen_verifyCredentials(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(AwsRegion),
+		Credentials: credentials.NewStaticCredentials("", "", ""),
+	})
+	require.NoError(t, err)
+
+	cfg := Config{
+		Session:        sess,
+		Database:       AthenaDatabase,
+		OutputLocation: fmt.Sprintf("s3://%s", S3Bucket),
+	}
+
+	_, err = Open(cfg)
+	assert.NoError(t, err, "credentials aren't verified by default")
+
+	cfg.VerifyCredentials = true
+	_, err = Open(cfg)
+	assert.True(t, errors.Is(err, credentials.ErrStaticCredentialsEmpty), err)
+
+	cfg.Session = sess.Copy(&aws.Config{Credentials: credentials.NewStaticCredentials("id", "secret", "")})
+	_, err = Open(cfg)
+	assert.NoError(t, err)
+}
+
+func TestConfig_validateResultKeyTemplate(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	cfg := Config{
+		Session:           sess,
+		Database:          AthenaDatabase,
+		OutputLocation:    fmt.Sprintf("s3://%s", S3Bucket),
+		ResultKeyTemplate: "results/{queryID}.csv",
+	}
+	assert.NoError(t, cfg.validate())
+
+	cfg.ResultKeyTemplate = "results/result.csv"
+	assert.Error(t, cfg.validate())
+}
+
+func TestConfig_validatePollBackoffMax(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	cfg := Config{
+		Session:        sess,
+		Database:       AthenaDatabase,
+		PollFrequency:  time.Second,
+		PollBackoffMax: 10 * time.Second,
+	}
+	assert.NoError(t, cfg.validate())
+
+	cfg.PollBackoffMax = 500 * time.Millisecond
+	assert.EqualError(t, cfg.validate(), "poll backoff max 500ms is less than poll frequency 1s")
+}
+
+func TestConfig_validateCSV(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	tests := []struct {
+		delimiter string
+		quote     string
+		wantErr   bool
+	}{
+		{delimiter: "", quote: ""},
+		{delimiter: "\t", quote: "'"},
+		{delimiter: "｜", quote: ""},
+		{delimiter: "ab", quote: "", wantErr: true},
+		{delimiter: "", quote: "\n", wantErr: true},
+		{delimiter: "'", quote: "'", wantErr: true},
+		{delimiter: "", quote: ",", wantErr: true},
+	}
+	for _, tt := range tests {
+		cfg := Config{
+			Session:      sess,
+			Database:     AthenaDatabase,
+			CSVDelimiter: tt.delimiter,
+			CSVQuote:     tt.quote,
+		}
+		if tt.wantErr {
+			assert.Error(t, cfg.validate(), "%q %q", tt.delimiter, tt.quote)
+		} else {
+			assert.NoError(t, cfg.validate(), "%q %q", tt.delimiter, tt.quote)
+		}
+	}
+}
+
+func Test_configFromConnectionString_readOnly(t *testing.T) {
+	cfg, err := configFromConnectionString("db=sampledb&region=us-east-1&read_only=true")
+	require.NoError(t, err)
+	assert.True(t, cfg.ReadOnly)
+
+	cfg, err = configFromConnectionString("db=sampledb&region=us-east-1")
+	require.NoError(t, err)
+	assert.False(t, cfg.ReadOnly)
+
+	_, err = configFromConnectionString("db=sampledb&region=us-east-1&read_only=yes")
+	assert.Error(t, err)
+}
+
+func TestParseDSN(t *testing.T) {
+	cfg, err := ParseDSN("db=sampledb&output_location=s3://bucket/results&poll_frequency=2s&region=ap-northeast-1" +
+		"&workgroup=analytics&result_mode=gzip&timeout=600&catalog=hive&read_only=true&poll_backoff_max=10s&max_retries=3" +
+		"&encryption_type=SSE_KMS&kms_key=alias/results")
+	require.NoError(t, err)
+	assert.Equal(t, "sampledb", cfg.Database)
+	assert.Equal(t, "s3://bucket/results", cfg.OutputLocation)
+	assert.Equal(t, 2*time.Second, cfg.PollFrequency)
+	assert.Equal(t, 10*time.Second, cfg.PollBackoffMax)
+	assert.Equal(t, "ap-northeast-1", aws.StringValue(cfg.Session.Config.Region))
+	assert.Equal(t, "analytics", cfg.WorkGroup)
+	assert.Equal(t, ResultModeGzipDL, cfg.ResultMode)
+	assert.Equal(t, uint(600), cfg.Timeout)
+	assert.Equal(t, "hive", cfg.Catalog)
+	assert.True(t, cfg.ReadOnly)
+	assert.Equal(t, 3, cfg.MaxRetries)
+	assert.Equal(t, athena.EncryptionOptionSseKms, cfg.EncryptionType)
+	assert.Equal(t, "alias/results", cfg.KMSKey)
+
+	// defaults
+	cfg, err = ParseDSN("db=sampledb&region=us-east-1")
+	require.NoError(t, err)
+	assert.Equal(t, "", cfg.OutputLocation)
+	assert.Equal(t, time.Duration(0), cfg.PollFrequency)
+	assert.Equal(t, time.Duration(0), cfg.PollBackoffMax)
+	assert.Equal(t, "primary", cfg.WorkGroup)
+	assert.Equal(t, ResultModeAPI, cfg.ResultMode)
+	assert.Equal(t, timeOutLimitDefault, cfg.Timeout)
+	assert.Equal(t, CATALOG_AWS_DATA_CATALOG, cfg.Catalog)
+	assert.False(t, cfg.ReadOnly)
+	assert.Equal(t, 0, cfg.MaxRetries)
+
+	for _, mode := range []string{"dl", "download", "DL"} {
+		cfg, err = ParseDSN("db=sampledb&region=us-east-1&result_mode=" + mode)
+		require.NoError(t, err)
+		assert.Equal(t, ResultModeDL, cfg.ResultMode, mode)
+	}
+
+	invalid := []string{
+		"db=sampledb&region=us-east-1&poll_frequency=2",
+		"db=sampledb&region=us-east-1&poll_backoff_max=10",
+		"db=sampledb&region=us-east-1&timeout=-1",
+		"db=sampledb&region=us-east-1&timeout=10m",
+		"db=sampledb&region=us-east-1&read_only=yes",
+		"db=sampledb&region=us-east-1&max_retries=-1",
+		"db=%zz",
+	}
+	for _, dsn := range invalid {
+		_, err = ParseDSN(dsn)
+		assert.Error(t, err, dsn)
+	}
+}
+
+func TestConfig_validateResultReuseMaxAge(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	cfg := Config{Session: sess, Database: AthenaDatabase}
+	for _, maxAge := range []time.Duration{0, time.Minute, 7 * 24 * time.Hour} {
+		cfg.ResultReuseMaxAge = maxAge
+		assert.NoError(t, cfg.validate(), maxAge)
+	}
+	for _, maxAge := range []time.Duration{-time.Minute, 7*24*time.Hour + time.Minute} {
+		cfg.ResultReuseMaxAge = maxAge
+		assert.Error(t, cfg.validate(), maxAge)
+	}
+}
+
+func TestConfig_validateEncryption(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	valid := []Config{
+		{},
+		{EncryptionType: athena.EncryptionOptionSseS3},
+		{EncryptionType: athena.EncryptionOptionSseKms, KMSKey: "alias/results"},
+		{EncryptionType: athena.EncryptionOptionCseKms, KMSKey: "alias/results"},
+	}
+	for _, cfg := range valid {
+		cfg.Session, cfg.Database = sess, AthenaDatabase
+		assert.NoError(t, cfg.validate(), cfg.EncryptionType)
+	}
+
+	invalid := []Config{
+		{KMSKey: "alias/results"},
+		{EncryptionType: "AES256"},
+		{EncryptionType: athena.EncryptionOptionSseS3, KMSKey: "alias/results"},
+		{EncryptionType: athena.EncryptionOptionSseKms},
+		{EncryptionType: athena.EncryptionOptionCseKms},
+	}
+	for _, cfg := range invalid {
+		cfg.Session, cfg.Database = sess, AthenaDatabase
+		assert.Error(t, cfg.validate(), cfg.EncryptionType)
+	}
+
+	// S3 can't decrypt client-side encrypted results as they are downloaded
+	cfg := Config{Session: sess, Database: AthenaDatabase, ResultMode: ResultModeDL,
+		EncryptionType: athena.EncryptionOptionCseKms, KMSKey: "alias/results"}
+	assert.True(t, errors.Is(cfg.validate(), ErrClientSideEncryption))
+}
+
+func TestConfig_validateAutoIdempotency(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	cfg := Config{Session: sess, Database: AthenaDatabase, AutoIdempotency: true}
+	assert.NoError(t, cfg.validate())
+	cfg.IdempotencyKeyFunc = func(query string) string { return query }
+	assert.Error(t, cfg.validate())
+}
@@ -0,0 +1,56 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+)
+
+// engineVersionRegex extracts the major version from e.g. "Athena engine version 3".
+var engineVersionRegex = regexp.MustCompile(`(\d+)$`)
+
+// EngineVersion returns the major Athena engine version of the workgroup of
+// db, e.g. 3. It's 0 unless Config.DetectEngineVersion is set.
+func EngineVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := withConn(ctx, db, func(c *conn) error {
+		version = c.engineVersion
+		return nil
+	})
+	return version, err
+}
+
+// getEngineVersion returns the major effective engine version of workgroup,
+// or 0 if it's unknown.
+func getEngineVersion(ctx context.Context, client athenaiface.AthenaAPI, workgroup string) (int, error) {
+	resp, err := client.GetWorkGroupWithContext(ctx, &athena.GetWorkGroupInput{
+		WorkGroup: aws.String(workgroup),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	wg := resp.WorkGroup
+	if wg == nil || wg.Configuration == nil || wg.Configuration.EngineVersion == nil {
+		return 0, nil
+	}
+	return parseEngineVersion(aws.StringValue(wg.Configuration.EngineVersion.EffectiveEngineVersion)), nil
+}
+
+func parseEngineVersion(version string) int {
+	m := engineVersionRegex.FindStringSubmatch(version)
+	if m == nil {
+		return 0
+	}
+
+	major, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+	return major
+}
@@ -0,0 +1,36 @@
+package athena
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineVersion(t *testing.T) {
+	for _, tt := range []struct {
+		workgroup string
+		detect    bool
+		want      int
+	}{
+		{workgroup: "v2", detect: true, want: 2},
+		{workgroup: "v3", detect: true, want: 3},
+		{workgroup: "v3", detect: false, want: 0},
+	} {
+		client := new(mockAthenaWorkGroupClient)
+		db := newMockDB(t, client, Config{WorkGroup: tt.workgroup, DetectEngineVersion: tt.detect})
+
+		version, err := EngineVersion(context.Background(), db)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, version, tt.workgroup)
+		require.NoError(t, db.Close())
+	}
+}
+
+func Test_parseEngineVersion(t *testing.T) {
+	assert.Equal(t, 2, parseEngineVersion("Athena engine version 2"))
+	assert.Equal(t, 3, parseEngineVersion("Athena engine version 3"))
+	assert.Equal(t, 0, parseEngineVersion("AUTO"))
+	assert.Equal(t, 0, parseEngineVersion(""))
+}
@@ -0,0 +1,250 @@
+package athena
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ErrQueryTooLong is matched by errors.Is for a QueryTooLongError.
+var ErrQueryTooLong = errors.New("query is too long")
+
+// QueryTooLongError is returned before submitting a query longer than Athena
+// accepts. Length includes what the driver adds, e.g. the CTAS statement of
+// GZIP DL Mode.
+type QueryTooLongError struct {
+	Length int
+	Limit  int
+}
+
+func (e *QueryTooLongError) Error() string {
+	return fmt.Sprintf("query is too long: %d bytes exceeds the limit of %d bytes", e.Length, e.Limit)
+}
+
+// Is reports whether target is ErrQueryTooLong.
+func (e *QueryTooLongError) Is(target error) bool {
+	return target == ErrQueryTooLong
+}
+
+// ErrReadOnly is returned for a statement that may modify data or tables
+// on a read-only connection. The statement isn't submitted.
+var ErrReadOnly = errors.New("statement is not allowed on a read-only connection")
+
+// ErrOutputLocationRequired is returned when a connection has no output
+// location, which DL and GZIP DL modes download results from.
+var ErrOutputLocationRequired = errors.New("output location is required")
+
+// ErrResultModeNotApplicable is logged when DL or GZIP DL mode is set to the
+// context of a statement other than SELECT, which runs in API mode instead.
+var ErrResultModeNotApplicable = errors.New("result mode is not applicable to the statement")
+
+// ErrClientSideEncryption is returned when results encrypted with CSE_KMS
+// would be read in DL or GZIP DL mode, which can't decrypt them.
+var ErrClientSideEncryption = errors.New("client-side encrypted results can't be downloaded")
+
+// ErrQueryTimeout is matched by errors.Is for a QueryTimeoutError.
+var ErrQueryTimeout = errors.New("query timed out")
+
+// QueryTimeoutError is returned when a query doesn't finish within the
+// timeout. The query is stopped before it's returned.
+type QueryTimeoutError struct {
+	QueryID string
+	Timeout time.Duration
+}
+
+func (e *QueryTimeoutError) Error() string {
+	return fmt.Sprintf("query %s timed out after %s", e.QueryID, e.Timeout)
+}
+
+// Is reports whether target is ErrQueryTimeout.
+func (e *QueryTimeoutError) Is(target error) bool {
+	return target == ErrQueryTimeout
+}
+
+// ErrQueuedTooLong is matched by errors.Is for a QueuedTooLongError.
+var ErrQueuedTooLong = errors.New("query queued too long")
+
+// QueuedTooLongError is returned when a query stays queued longer than
+// QueueTimeout. The query is stopped before it's returned.
+type QueuedTooLongError struct {
+	QueryID      string
+	QueueTimeout time.Duration
+}
+
+func (e *QueuedTooLongError) Error() string {
+	return fmt.Sprintf("query %s was still queued after %s", e.QueryID, e.QueueTimeout)
+}
+
+// Is reports whether target is ErrQueuedTooLong.
+func (e *QueuedTooLongError) Is(target error) bool {
+	return target == ErrQueuedTooLong
+}
+
+// ErrBytesScannedExceeded is matched by errors.Is for a BytesScannedExceededError.
+var ErrBytesScannedExceeded = errors.New("bytes scanned limit exceeded")
+
+// BytesScannedExceededError is returned for a query stopped for scanning
+// more than BytesScannedCutoff, or cancelled by Athena for exceeding the
+// cutoff of the workgroup, in which case Reason is set instead of the sizes.
+type BytesScannedExceededError struct {
+	QueryID string
+	Cutoff  uint64
+	Scanned int64
+	Reason  string
+}
+
+func (e *BytesScannedExceededError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("query %s was cancelled: %s", e.QueryID, e.Reason)
+	}
+	return fmt.Sprintf("query %s was stopped: %d bytes scanned exceeds the cutoff of %d bytes", e.QueryID, e.Scanned, e.Cutoff)
+}
+
+// Is reports whether target is ErrBytesScannedExceeded.
+func (e *BytesScannedExceededError) Is(target error) bool {
+	return target == ErrBytesScannedExceeded
+}
+
+// bytesScannedLimitRegex matches the reason of queries cancelled by the
+// bytes scanned cutoff of the workgroup.
+var bytesScannedLimitRegex = regexp.MustCompile(`(?i)bytes scanned limit`)
+
+// QueryFailedError is returned when Athena fails a query, or when a query
+// is cancelled by someone else, e.g. from the console. The cancelled ones are
+// also matched by errors.Is for context.Canceled.
+type QueryFailedError struct {
+	QueryID string
+
+	// State is athena.QueryExecutionStateFailed or athena.QueryExecutionStateCancelled.
+	State string
+
+	// Reason is the StateChangeReason of the query, e.g. "SYNTAX_ERROR: ...".
+	Reason string
+}
+
+func (e *QueryFailedError) Error() string {
+	if e.Reason == "" && e.State == athena.QueryExecutionStateCancelled {
+		return fmt.Sprintf("query %s was cancelled", e.QueryID)
+	}
+	return e.Reason
+}
+
+// Is reports whether target is context.Canceled for a cancelled query.
+func (e *QueryFailedError) Is(target error) bool {
+	return target == context.Canceled && e.State == athena.QueryExecutionStateCancelled
+}
+
+// MultiStatementError is returned when a statement of a query run with
+// SetMultiStatement fails. The statements before Index have been run.
+type MultiStatementError struct {
+	// Index is that of the failed statement, starting from 0.
+	Index     int
+	Statement string
+	Err       error
+}
+
+func (e *MultiStatementError) Error() string {
+	return fmt.Sprintf("statement %d failed: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the error of the failed statement.
+func (e *MultiStatementError) Unwrap() error {
+	return e.Err
+}
+
+// isQueryFailed reports whether err is a failure of a query, not a cancellation.
+func isQueryFailed(err error) bool {
+	var failed *QueryFailedError
+	return errors.As(err, &failed) && failed.State == athena.QueryExecutionStateFailed
+}
+
+// internalErrorRegex matches the reasons of failures due to Athena itself,
+// e.g. "INTERNAL_ERROR_QUERY_ENGINE".
+var internalErrorRegex = regexp.MustCompile(`(?i)INTERNAL_ERROR|internal error`)
+
+// isInternalError reports whether err is a failure of a query due to Athena itself.
+func isInternalError(err error) bool {
+	var failed *QueryFailedError
+	return errors.As(err, &failed) && internalErrorRegex.MatchString(failed.Reason)
+}
+
+// Categories of the errors returned by AWS APIs, matched by errors.Is for an APIError.
+// ErrInvalidSQL is the category of InvalidRequestException, which Athena
+// returns for queries it can't parse as well as for other invalid input.
+var (
+	ErrAccessDenied   = errors.New("access denied")
+	ErrInvalidSQL     = errors.New("invalid query")
+	ErrThrottled      = errors.New("request throttled")
+	ErrNotFound       = errors.New("resource not found")
+	ErrInternalServer = errors.New("internal server error")
+)
+
+// The categories named after the Athena exceptions they're matched for.
+var (
+	ErrInvalidRequest   = ErrInvalidSQL
+	ErrTooManyRequests  = ErrThrottled
+	ErrResourceNotFound = ErrNotFound
+)
+
+// APIError is an error of an Athena or S3 API call with the category of the
+// error, e.g. ErrAccessDenied. The SDK error is available with errors.As.
+type APIError struct {
+	Category error
+	Err      error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Category, e.Err)
+}
+
+// Unwrap returns the SDK error.
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the category of the error.
+func (e *APIError) Is(target error) bool {
+	return target == e.Category
+}
+
+// wrapAPIError wraps an SDK error of a known category in an APIError.
+// Other errors are returned as is.
+func wrapAPIError(err error) error {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return err
+	}
+
+	var category error
+	switch {
+	case request.IsErrorThrottle(err), aerr.Code() == "SlowDown":
+		category = ErrThrottled
+	case aerr.Code() == "AccessDeniedException", aerr.Code() == "AccessDenied":
+		category = ErrAccessDenied
+	case aerr.Code() == athena.ErrCodeInvalidRequestException:
+		category = ErrInvalidSQL
+	case aerr.Code() == athena.ErrCodeResourceNotFoundException,
+		aerr.Code() == s3.ErrCodeNoSuchKey,
+		aerr.Code() == s3.ErrCodeNoSuchBucket:
+		category = ErrNotFound
+	case aerr.Code() == athena.ErrCodeInternalServerException, aerr.Code() == "InternalError":
+		category = ErrInternalServer
+	default:
+		return err
+	}
+
+	return &APIError{Category: category, Err: err}
+}
+
+// isAccessDenied reports whether err is an AWS API error due to missing permissions.
+func isAccessDenied(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == "AccessDeniedException"
+}
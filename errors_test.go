@@ -0,0 +1,142 @@
+package athena
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_wrapAPIError(t *testing.T) {
+	tests := []struct {
+		code string
+		want error
+	}{
+		{code: "AccessDeniedException", want: ErrAccessDenied},
+		{code: "AccessDenied", want: ErrAccessDenied},
+		{code: athena.ErrCodeInvalidRequestException, want: ErrInvalidSQL},
+		{code: athena.ErrCodeTooManyRequestsException, want: ErrThrottled},
+		{code: "ThrottlingException", want: ErrThrottled},
+		{code: "SlowDown", want: ErrThrottled},
+		{code: athena.ErrCodeResourceNotFoundException, want: ErrNotFound},
+		{code: s3.ErrCodeNoSuchKey, want: ErrNotFound},
+		{code: athena.ErrCodeInternalServerException, want: ErrInternalServer},
+		{code: "InternalError", want: ErrInternalServer},
+	}
+	for _, tt := range tests {
+		sdkErr := awserr.New(tt.code, "message", nil)
+		err := wrapAPIError(sdkErr)
+		assert.True(t, errors.Is(err, tt.want), tt.code)
+
+		var aerr awserr.Error
+		require.True(t, errors.As(err, &aerr), tt.code)
+		assert.Equal(t, tt.code, aerr.Code())
+	}
+
+	// unknown codes and other errors aren't wrapped
+	sdkErr := awserr.New(athena.ErrCodeMetadataException, "message", nil)
+	assert.Equal(t, sdkErr, wrapAPIError(sdkErr))
+	assert.Equal(t, dummyError, wrapAPIError(dummyError))
+}
+
+type mockAthenaErrorClient struct {
+	*mockAthenaConnClient
+
+	startErr     error
+	executionErr error
+	resultsErr   error
+}
+
+func (m *mockAthenaErrorClient) StartQueryExecutionWithContext(ctx aws.Context, input *athena.StartQueryExecutionInput, opts ...request.Option) (*athena.StartQueryExecutionOutput, error) {
+	if m.startErr != nil {
+		return nil, m.startErr
+	}
+	return m.mockAthenaConnClient.StartQueryExecutionWithContext(ctx, input, opts...)
+}
+
+func (m *mockAthenaErrorClient) GetQueryExecutionWithContext(ctx aws.Context, input *athena.GetQueryExecutionInput, opts ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	if m.executionErr != nil {
+		return nil, m.executionErr
+	}
+	return m.mockAthenaConnClient.GetQueryExecutionWithContext(ctx, input, opts...)
+}
+
+func (m *mockAthenaErrorClient) GetQueryResultsWithContext(ctx aws.Context, input *athena.GetQueryResultsInput, opts ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	if m.resultsErr != nil {
+		return nil, m.resultsErr
+	}
+	return m.mockAthenaConnClient.GetQueryResultsWithContext(ctx, input, opts...)
+}
+
+func TestConn_apiErrors(t *testing.T) {
+	results := genResults([]*athena.ColumnInfo{genColumnInfo("name")})
+
+	t.Run("start", func(t *testing.T) {
+		client := &mockAthenaErrorClient{
+			mockAthenaConnClient: &mockAthenaConnClient{results: results},
+			startErr:             awserr.New("AccessDeniedException", "not authorized to perform athena:StartQueryExecution", nil),
+		}
+		c := &conn{athena: client}
+
+		_, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+		assert.True(t, errors.Is(err, ErrAccessDenied))
+		assert.False(t, errors.Is(err, ErrInvalidSQL))
+	})
+
+	t.Run("results", func(t *testing.T) {
+		client := &mockAthenaErrorClient{
+			mockAthenaConnClient: &mockAthenaConnClient{results: results},
+			resultsErr:           awserr.New(athena.ErrCodeTooManyRequestsException, "Rate exceeded", nil),
+		}
+		c := &conn{athena: client}
+
+		_, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+		assert.True(t, errors.Is(err, ErrThrottled))
+	})
+
+	t.Run("athena exceptions", func(t *testing.T) {
+		tests := []struct {
+			code string
+			want error
+		}{
+			{code: athena.ErrCodeInvalidRequestException, want: ErrInvalidRequest},
+			{code: athena.ErrCodeTooManyRequestsException, want: ErrTooManyRequests},
+			{code: athena.ErrCodeInternalServerException, want: ErrInternalServer},
+			{code: athena.ErrCodeResourceNotFoundException, want: ErrResourceNotFound},
+		}
+		for _, tt := range tests {
+			sdkErr := awserr.New(tt.code, "message", nil)
+			clients := map[string]*mockAthenaErrorClient{
+				"start":     {mockAthenaConnClient: &mockAthenaConnClient{results: results}, startErr: sdkErr},
+				"execution": {mockAthenaConnClient: &mockAthenaConnClient{results: results}, executionErr: sdkErr},
+				"results":   {mockAthenaConnClient: &mockAthenaConnClient{results: results}, resultsErr: sdkErr},
+			}
+			for call, client := range clients {
+				c := &conn{athena: client}
+
+				_, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+				assert.True(t, errors.Is(err, tt.want), "%s: %s", call, tt.code)
+			}
+		}
+	})
+
+	t.Run("download", func(t *testing.T) {
+		c := &conn{
+			athena:         &mockAthenaConnClient{results: results},
+			s3:             &mockS3Client{},
+			OutputLocation: "s3://bucket",
+			resultMode:     ResultModeDL,
+			timeout:        timeOutLimitDefault,
+		}
+
+		_, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+}
@@ -0,0 +1,151 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Plan is the distributed plan of a query as EXPLAIN returns it.
+type Plan struct {
+	// Stages are the fragments of the plan ordered by their ID.
+	// They are empty when the plan could only be read as text.
+	Stages []PlanStage
+
+	// Text is the plan in the text format. It's only set when the engine
+	// doesn't support EXPLAIN (FORMAT JSON).
+	Text string
+}
+
+// PlanStage is a fragment of a plan, which Athena runs as a stage.
+type PlanStage struct {
+	ID   string
+	Root *PlanNode
+}
+
+// PlanNode is an operator of a plan.
+type PlanNode struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Descriptor map[string]string `json:"descriptor"`
+	Outputs    []PlanOutput      `json:"outputs"`
+	Details    []string          `json:"details"`
+	Estimates  []PlanEstimate    `json:"estimates"`
+	Children   []*PlanNode       `json:"children"`
+}
+
+// EstimatedRows returns the estimated output row count of the operator.
+// false is returned when the engine has no estimate.
+func (n *PlanNode) EstimatedRows() (float64, bool) {
+	for _, e := range n.Estimates {
+		if rows := float64(e.OutputRowCount); !math.IsNaN(rows) {
+			return rows, true
+		}
+	}
+	return 0, false
+}
+
+// PlanOutput is a column an operator outputs.
+type PlanOutput struct {
+	Symbol string `json:"symbol"`
+	Type   string `json:"type"`
+}
+
+// PlanEstimate is the cost estimated for an operator. Unknown values are NaN.
+type PlanEstimate struct {
+	OutputRowCount    PlanNumber `json:"outputRowCount"`
+	OutputSizeInBytes PlanNumber `json:"outputSizeInBytes"`
+	CPUCost           PlanNumber `json:"cpuCost"`
+	MemoryCost        PlanNumber `json:"memoryCost"`
+	NetworkCost       PlanNumber `json:"networkCost"`
+}
+
+// PlanNumber is a number of a plan, which is written as the string "NaN"
+// or "Infinity" when it isn't finite.
+type PlanNumber float64
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (n *PlanNumber) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*n = PlanNumber(f)
+	return nil
+}
+
+// ExplainQuery returns the plan of query without running it.
+// The plan is read with EXPLAIN (FORMAT JSON), falling back to the text
+// format for engines that don't support JSON.
+func ExplainQuery(ctx context.Context, db *sql.DB, query string) (Plan, error) {
+	out, err := queryPlan(ctx, db, "EXPLAIN (FORMAT JSON) "+query)
+	if err != nil {
+		text, textErr := queryPlan(ctx, db, "EXPLAIN "+query)
+		if textErr != nil {
+			return Plan{}, err
+		}
+		return Plan{Text: text}, nil
+	}
+
+	plan, err := parsePlan(out)
+	if err != nil {
+		return Plan{Text: out}, nil
+	}
+	return plan, nil
+}
+
+// queryPlan runs an EXPLAIN statement and joins the lines of the plan.
+func queryPlan(ctx context.Context, db *sql.DB, query string) (string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line sql.NullString
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line.String)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// parsePlan parses the output of EXPLAIN (FORMAT JSON), an object of the
+// root operators keyed by fragment ID.
+func parsePlan(out string) (Plan, error) {
+	var fragments map[string]*PlanNode
+	if err := json.Unmarshal([]byte(out), &fragments); err != nil {
+		return Plan{}, err
+	}
+
+	plan := Plan{Stages: make([]PlanStage, 0, len(fragments))}
+	for id, root := range fragments {
+		plan.Stages = append(plan.Stages, PlanStage{ID: id, Root: root})
+	}
+	sort.Slice(plan.Stages, func(i, j int) bool {
+		a, errA := strconv.Atoi(plan.Stages[i].ID)
+		b, errB := strconv.Atoi(plan.Stages[j].ID)
+		if errA != nil || errB != nil {
+			return plan.Stages[i].ID < plan.Stages[j].ID
+		}
+		return a < b
+	})
+
+	return plan, nil
+}
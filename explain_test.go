@@ -0,0 +1,123 @@
+package athena
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testJSONPlan = `{
+  "0" : {
+    "id" : "9",
+    "name" : "Output",
+    "descriptor" : {
+      "columnNames" : "[name]"
+    },
+    "outputs" : [ {
+      "symbol" : "name",
+      "type" : "varchar"
+    } ],
+    "details" : [ ],
+    "estimates" : [ {
+      "outputRowCount" : 10.0,
+      "outputSizeInBytes" : "NaN",
+      "cpuCost" : "NaN",
+      "memoryCost" : 0.0,
+      "networkCost" : "NaN"
+    } ],
+    "children" : [ {
+      "id" : "170",
+      "name" : "RemoteSource",
+      "descriptor" : {
+        "sourceFragmentIds" : "[1]"
+      },
+      "outputs" : [ ],
+      "details" : [ ],
+      "estimates" : [ ],
+      "children" : [ ]
+    } ]
+  },
+  "1" : {
+    "id" : "0",
+    "name" : "TableScan",
+    "descriptor" : {
+      "table" : "awsdatacatalog:sampledb:elb_logs"
+    },
+    "outputs" : [ ],
+    "details" : [ ],
+    "estimates" : [ {
+      "outputRowCount" : "NaN",
+      "outputSizeInBytes" : "NaN",
+      "cpuCost" : "NaN",
+      "memoryCost" : "NaN",
+      "networkCost" : "NaN"
+    } ],
+    "children" : [ ]
+  }
+}`
+
+func TestExplainQuery(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults([]*athena.ColumnInfo{genTypedColumnInfo("Query Plan", "varchar")}, []*string{aws.String(testJSONPlan)}),
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	plan, err := ExplainQuery(context.Background(), db, "SELECT name FROM elb_logs")
+	require.NoError(t, err)
+	assert.Equal(t, "EXPLAIN (FORMAT JSON) SELECT name FROM elb_logs", *client.startInputs[0].QueryString)
+	assert.Empty(t, plan.Text)
+	require.Len(t, plan.Stages, 2)
+
+	output := plan.Stages[0]
+	assert.Equal(t, "0", output.ID)
+	assert.Equal(t, "Output", output.Root.Name)
+	assert.Equal(t, []PlanOutput{{Symbol: "name", Type: "varchar"}}, output.Root.Outputs)
+	assert.True(t, math.IsNaN(float64(output.Root.Estimates[0].CPUCost)))
+	rows, ok := output.Root.EstimatedRows()
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, rows)
+	require.Len(t, output.Root.Children, 1)
+	assert.Equal(t, "RemoteSource", output.Root.Children[0].Name)
+
+	scan := plan.Stages[1]
+	assert.Equal(t, "1", scan.ID)
+	assert.Equal(t, "awsdatacatalog:sampledb:elb_logs", scan.Root.Descriptor["table"])
+	_, ok = scan.Root.EstimatedRows()
+	assert.False(t, ok)
+}
+
+func TestExplainQuery_textFallback(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{genTypedColumnInfo("Query Plan", "varchar")},
+			[]*string{aws.String("Fragment 0 [SINGLE]")},
+			[]*string{aws.String("    Output[name]")},
+		),
+		failQuery: func(query string) string {
+			if strings.HasPrefix(query, "EXPLAIN (FORMAT JSON)") {
+				return "line 1:10: mismatched input 'FORMAT'"
+			}
+			return ""
+		},
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	plan, err := ExplainQuery(context.Background(), db, "SELECT name FROM elb_logs")
+	require.NoError(t, err)
+	assert.Empty(t, plan.Stages)
+	assert.Equal(t, "Fragment 0 [SINGLE]\n    Output[name]", plan.Text)
+	assert.Equal(t, "EXPLAIN SELECT name FROM elb_logs", *client.startInputs[1].QueryString)
+
+	// the error of the JSON plan is returned when neither format works
+	client.failQuery = func(string) string { return "line 1:1: Table awsdatacatalog.sampledb.elb_logs does not exist" }
+	_, err = ExplainQuery(context.Background(), db, "SELECT name FROM elb_logs")
+	assert.EqualError(t, err, "line 1:1: Table awsdatacatalog.sampledb.elb_logs does not exist")
+}
@@ -0,0 +1,60 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// ExportJSONLines runs query on db and writes every row to w as a JSON object
+// keyed by column name, one object per line. Rows are written as they are
+// read, so the result is never held in memory as a whole.
+// Values keep the types the driver converts them to and NULL is written as null.
+// json columns are written as the JSON they hold rather than as a string.
+func ExportJSONLines(ctx context.Context, db *sql.DB, query string, w io.Writer) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	enc := json.NewEncoder(w)
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			// json values are scanned as []byte, which would be written in base64
+			if b, ok := values[i].([]byte); ok && strings.EqualFold(columnTypes[i].DatabaseTypeName(), "json") {
+				row[column] = json.RawMessage(b)
+				continue
+			}
+			row[column] = values[i]
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
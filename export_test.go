@@ -0,0 +1,62 @@
+package athena
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportJSONLines(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{
+				genTypedColumnInfo("id", "bigint"),
+				genTypedColumnInfo("name", "varchar"),
+				genTypedColumnInfo("active", "boolean"),
+				genTypedColumnInfo("score", "double"),
+				genTypedColumnInfo("created_at", "timestamp"),
+			},
+			[]*string{aws.String("1"), aws.String("foo"), aws.String("true"), aws.String("1.5"), aws.String("2006-01-02 03:04:05.123")},
+			[]*string{aws.String("2"), nil, aws.String("false"), nil, nil},
+		),
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	var buf bytes.Buffer
+	require.NoError(t, ExportJSONLines(context.Background(), db, "SELECT * FROM users", &buf))
+
+	expected := `{"active":true,"created_at":"2006-01-02T03:04:05.123Z","id":1,"name":"foo","score":1.5}
+{"active":false,"created_at":null,"id":2,"name":null,"score":null}
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestExportJSONLines_json(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{
+				genTypedColumnInfo("id", "bigint"),
+				genTypedColumnInfo("attrs", "json"),
+				genTypedColumnInfo("raw", "varbinary"),
+			},
+			[]*string{aws.String("1"), aws.String(`{"a":[1,2]}`), aws.String("61 62")},
+			[]*string{aws.String("2"), nil, nil},
+		),
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	var buf bytes.Buffer
+	require.NoError(t, ExportJSONLines(context.Background(), db, "SELECT * FROM users", &buf))
+
+	expected := `{"attrs":{"a":[1,2]},"id":1,"raw":"YWI="}
+{"attrs":null,"id":2,"raw":null}
+`
+	assert.Equal(t, expected, buf.String())
+}
@@ -0,0 +1,105 @@
+package athena
+
+import (
+	"fmt"
+	"strings"
+)
+
+// geometryTypes are the geometry types of WKT.
+var geometryTypes = map[string]bool{
+	"POINT":              true,
+	"LINESTRING":         true,
+	"POLYGON":            true,
+	"MULTIPOINT":         true,
+	"MULTILINESTRING":    true,
+	"MULTIPOLYGON":       true,
+	"GEOMETRYCOLLECTION": true,
+}
+
+// Geometry is a geometry value, which Athena returns in WKT, e.g.
+// `POLYGON ((0 0, 1 0, 1 1, 0 0))`. geometry columns are returned as
+// Geometry with SetGeometryMode, and it's a sql.Scanner of their WKT string
+// otherwise.
+//
+//	var g athena.Geometry
+//	err := rows.Scan(&g)
+type Geometry struct {
+	// Type is the type of the geometry in upper case, e.g. "POINT".
+	Type string
+
+	// Empty is whether the geometry has no points, e.g. `POINT EMPTY`.
+	Empty bool
+
+	// WKT is the value as returned by Athena.
+	WKT string
+}
+
+// Scan implements sql.Scanner.
+func (g *Geometry) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case Geometry:
+		*g = v
+		return nil
+	case string:
+		return g.scanWKT(v)
+	case []byte:
+		return g.scanWKT(string(v))
+	}
+	return fmt.Errorf("cannot scan %T into Geometry", src)
+}
+
+func (g *Geometry) scanWKT(wkt string) error {
+	geometry, err := parseGeometry(wkt)
+	if err != nil {
+		return err
+	}
+	*g = geometry
+	return nil
+}
+
+// parseGeometry reads the type of a geometry written in WKT.
+func parseGeometry(wkt string) (Geometry, error) {
+	s := strings.TrimSpace(wkt)
+	i := strings.IndexAny(s, " (")
+	if i < 0 {
+		i = len(s)
+	}
+	typ := strings.ToUpper(s[:i])
+	if !geometryTypes[typ] {
+		return Geometry{}, fmt.Errorf("cannot parse '%s' as geometry", wkt)
+	}
+
+	// dimensions such as Z and M don't change the type
+	body := strings.TrimSpace(s[i:])
+	for _, dim := range []string{"ZM", "Z", "M"} {
+		if strings.HasPrefix(strings.ToUpper(body), dim+" ") || strings.HasPrefix(strings.ToUpper(body), dim+"(") {
+			body = strings.TrimSpace(body[len(dim):])
+			break
+		}
+	}
+
+	switch {
+	case strings.EqualFold(body, "EMPTY"):
+		return Geometry{Type: typ, Empty: true, WKT: wkt}, nil
+	case strings.HasPrefix(body, "(") && strings.HasSuffix(body, ")") && balancedParens(body):
+		return Geometry{Type: typ, WKT: wkt}, nil
+	}
+	return Geometry{}, fmt.Errorf("cannot parse '%s' as geometry", wkt)
+}
+
+// balancedParens reports whether every parenthesis of s is closed.
+func balancedParens(s string) bool {
+	depth := 0
+	for _, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
@@ -0,0 +1,78 @@
+package athena
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseGeometry(t *testing.T) {
+	tests := []struct {
+		wkt  string
+		want Geometry
+	}{
+		{"POINT (1 2)", Geometry{Type: "POINT", WKT: "POINT (1 2)"}},
+		{"POLYGON ((0 0, 1 0, 1 1, 0 0))", Geometry{Type: "POLYGON", WKT: "POLYGON ((0 0, 1 0, 1 1, 0 0))"}},
+		{"MULTIPOLYGON (((0 0, 1 0, 1 1, 0 0)), ((2 2, 3 2, 3 3, 2 2)))", Geometry{Type: "MULTIPOLYGON", WKT: "MULTIPOLYGON (((0 0, 1 0, 1 1, 0 0)), ((2 2, 3 2, 3 3, 2 2)))"}},
+		{"point z (1 2 3)", Geometry{Type: "POINT", WKT: "point z (1 2 3)"}},
+		{"POINT EMPTY", Geometry{Type: "POINT", Empty: true, WKT: "POINT EMPTY"}},
+		{"GEOMETRYCOLLECTION EMPTY", Geometry{Type: "GEOMETRYCOLLECTION", Empty: true, WKT: "GEOMETRYCOLLECTION EMPTY"}},
+	}
+	for _, test := range tests {
+		got, err := parseGeometry(test.wkt)
+		require.NoError(t, err, test.wkt)
+		assert.Equal(t, test.want, got, test.wkt)
+	}
+
+	for _, wkt := range []string{"", "CIRCLE (1 2)", "POINT", "POLYGON ((0 0, 1 0)", "POINT 1 2"} {
+		_, err := parseGeometry(wkt)
+		assert.EqualError(t, err, "cannot parse '"+wkt+"' as geometry")
+	}
+}
+
+func TestRows_geometry(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{genTypedColumnInfo("shape", "geometry")},
+			[]*string{aws.String("POINT (1 2)")},
+			[]*string{aws.String("POLYGON ((0 0, 1 0, 1 1, 0 0))")},
+			[]*string{nil},
+		),
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	scan := func(ctx context.Context) []interface{} {
+		rows, err := db.QueryContext(ctx, "SELECT shape FROM t")
+		require.NoError(t, err)
+		defer rows.Close()
+
+		var got []interface{}
+		for rows.Next() {
+			var shape interface{}
+			require.NoError(t, rows.Scan(&shape))
+			got = append(got, shape)
+		}
+		require.NoError(t, rows.Err())
+		return got
+	}
+
+	// WKT by default
+	assert.Equal(t, []interface{}{"POINT (1 2)", "POLYGON ((0 0, 1 0, 1 1, 0 0))", nil}, scan(context.Background()))
+	assert.Equal(t, []interface{}{
+		Geometry{Type: "POINT", WKT: "POINT (1 2)"},
+		Geometry{Type: "POLYGON", WKT: "POLYGON ((0 0, 1 0, 1 1, 0 0))"},
+		nil,
+	}, scan(SetGeometryMode(context.Background())))
+
+	// a Geometry can be scanned in either mode
+	for _, ctx := range []context.Context{context.Background(), SetGeometryMode(context.Background())} {
+		var g Geometry
+		require.NoError(t, db.QueryRowContext(ctx, "SELECT shape FROM t").Scan(&g))
+		assert.Equal(t, Geometry{Type: "POINT", WKT: "POINT (1 2)"}, g)
+	}
+}
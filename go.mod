@@ -3,7 +3,9 @@ module github.com/speee/go-athena
 go 1.14
 
 require (
-	github.com/aws/aws-sdk-go v1.35.20
+	github.com/aws/aws-sdk-go v1.46.7
 	github.com/satori/go.uuid v1.2.0
+	github.com/shopspring/decimal v1.3.1
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/text v0.4.0
 )
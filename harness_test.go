@@ -0,0 +1,326 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// harnessColumns are the columns of the fixtures in testdata.
+var harnessColumns = []*athena.ColumnInfo{
+	genTypedColumnInfo("id", "integer"),
+	genTypedColumnInfo("name", "varchar"),
+	genTypedColumnInfo("note", "varchar"),
+}
+
+// mockAthenaHarnessClient reports harnessColumns as the columns of CTAS tables.
+type mockAthenaHarnessClient struct {
+	*mockAthenaPagingClient
+}
+
+func (m mockAthenaHarnessClient) GetTableMetadataWithContext(_ aws.Context, input *athena.GetTableMetadataInput, _ ...request.Option) (*athena.GetTableMetadataOutput, error) {
+	var columns []*athena.Column
+	for _, col := range harnessColumns {
+		columns = append(columns, &athena.Column{Name: col.Name, Type: col.Type})
+	}
+	return &athena.GetTableMetadataOutput{
+		TableMetadata: &athena.TableMetadata{Name: input.TableName, Columns: columns},
+	}, nil
+}
+
+// newHarnessDB opens a DB whose connections use client and an in-memory S3
+// holding objects, so that every result mode runs without AWS.
+func newHarnessDB(t *testing.T, client athenaiface.AthenaAPI, objects map[string][]byte, mode ResultMode) *sql.DB {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	c := NewConnector(Config{
+		Session:        sess,
+		Database:       AthenaDatabase,
+		OutputLocation: "s3://bucket/results",
+		ResultMode:     mode,
+	}).(*connector)
+	c.athena = client
+	c.s3 = &mockS3Client{objects: objects}
+	return sql.OpenDB(c)
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return data
+}
+
+func scanHarnessRows(t *testing.T, db *sql.DB, ctx context.Context) [][]interface{} {
+	rows, err := db.QueryContext(ctx, "SELECT id, name, note FROM t")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var got [][]interface{}
+	for rows.Next() {
+		var id, name, note interface{}
+		require.NoError(t, rows.Scan(&id, &name, &note))
+		got = append(got, []interface{}{id, name, note})
+	}
+	require.NoError(t, rows.Err())
+	return got
+}
+
+func TestHarness_resultModes(t *testing.T) {
+	t.Run("api", func(t *testing.T) {
+		client := &mockAthenaPagingClient{
+			mockAthenaConnClient: &mockAthenaConnClient{
+				results: genResults(harnessColumns,
+					[]*string{aws.String("1"), aws.String("alice"), aws.String(`said "hi", then left`)},
+					[]*string{aws.String("2"), nil, aws.String("a, b")},
+					[]*string{aws.String("3"), aws.String(""), nil},
+				),
+			},
+			pageSize: 2,
+		}
+		db := newHarnessDB(t, client, nil, ResultModeAPI)
+		defer db.Close()
+
+		assert.Equal(t, [][]interface{}{
+			{int64(1), "alice", `said "hi", then left`},
+			{int64(2), nil, "a, b"},
+			{int64(3), "", nil},
+		}, scanHarnessRows(t, db, context.Background()))
+		// the header and three rows over two pages
+		assert.Len(t, client.resultInputs, 2)
+	})
+
+	t.Run("dl", func(t *testing.T) {
+		client := &mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(harnessColumns)}}
+		db := newHarnessDB(t, client, map[string][]byte{
+			"bucket/results/query_1.csv": readFixture(t, "dl/nulls_and_quotes.csv"),
+		}, ResultModeDL)
+		defer db.Close()
+
+		assert.Equal(t, [][]interface{}{
+			{int64(1), "alice", `said "hi", then left`},
+			{int64(2), nil, "a, b"},
+			{int64(3), "", nil},
+		}, scanHarnessRows(t, db, context.Background()))
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		client := mockAthenaHarnessClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(harnessColumns)}}}
+		objects := make(map[string][]byte)
+		var manifest []string
+		for i := 0; i < 3; i++ {
+			key := fmt.Sprintf("results/tables/query_1/part-%d.gz", i)
+			objects["bucket/"+key] = gzipData(t, string(readFixture(t, fmt.Sprintf("gzip/part-%d.txt", i))))
+			manifest = append(manifest, "s3://bucket/"+key)
+		}
+		objects["bucket/results/tables/query_1-manifest.csv"] = []byte(strings.Join(manifest, "\n") + "\n")
+		db := newHarnessDB(t, client, objects, ResultModeGzipDL)
+		defer db.Close()
+
+		assert.Equal(t, [][]interface{}{
+			{int64(1), "alice", nil},
+			{int64(2), nil, "x"},
+			{int64(3), "carol", "y, z"},
+		}, scanHarnessRows(t, db, context.Background()))
+		// the CTAS table is dropped after the download
+		require.Len(t, client.startInputs, 2)
+		assert.True(t, strings.HasPrefix(*client.startInputs[1].QueryString, "DROP TABLE tmp_ctas_"))
+	})
+}
+
+func TestHarness_rawBytesMode(t *testing.T) {
+	gzipObjects := make(map[string][]byte)
+	var manifest []string
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("results/tables/query_1/part-%d.gz", i)
+		gzipObjects["bucket/"+key] = gzipData(t, string(readFixture(t, fmt.Sprintf("gzip/part-%d.txt", i))))
+		manifest = append(manifest, "s3://bucket/"+key)
+	}
+	gzipObjects["bucket/results/tables/query_1-manifest.csv"] = []byte(strings.Join(manifest, "\n") + "\n")
+
+	tests := []struct {
+		mode    ResultMode
+		client  athenaiface.AthenaAPI
+		objects map[string][]byte
+		want    [][]interface{}
+	}{
+		{
+			mode: ResultModeAPI,
+			client: &mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{
+				results: genResults(harnessColumns,
+					[]*string{aws.String("1"), aws.String("alice"), nil},
+					[]*string{aws.String("2"), aws.String(""), aws.String("a, b")},
+				),
+			}},
+			want: [][]interface{}{
+				{[]byte("1"), []byte("alice"), nil},
+				{[]byte("2"), []byte(""), []byte("a, b")},
+			},
+		},
+		{
+			mode:    ResultModeDL,
+			client:  &mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(harnessColumns)}},
+			objects: map[string][]byte{"bucket/results/query_1.csv": readFixture(t, "dl/nulls_and_quotes.csv")},
+			want: [][]interface{}{
+				{[]byte("1"), []byte("alice"), []byte(`said "hi", then left`)},
+				{[]byte("2"), nil, []byte("a, b")},
+				{[]byte("3"), []byte(""), nil},
+			},
+		},
+		{
+			mode:    ResultModeGzipDL,
+			client:  mockAthenaHarnessClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(harnessColumns)}}},
+			objects: gzipObjects,
+			want: [][]interface{}{
+				{[]byte("1"), []byte("alice"), nil},
+				{[]byte("2"), nil, []byte("x")},
+				{[]byte("3"), []byte("carol"), []byte("y, z")},
+			},
+		},
+	}
+	for _, test := range tests {
+		db := newHarnessDB(t, test.client, test.objects, test.mode)
+		assert.Equal(t, test.want, scanHarnessRows(t, db, SetRawBytesMode(context.Background())), test.mode)
+		db.Close()
+	}
+}
+
+func TestHarness_rawBytesModeScan(t *testing.T) {
+	client := &mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{
+		results: genResults(harnessColumns, []*string{aws.String("1"), aws.String("alice"), nil}),
+	}}
+	db := newHarnessDB(t, client, nil, ResultModeAPI)
+	defer db.Close()
+
+	// sql.RawBytes refers to the value until the next row
+	rows, err := db.QueryContext(SetRawBytesMode(context.Background()), "SELECT id, name, note FROM t")
+	require.NoError(t, err)
+	defer rows.Close()
+	require.True(t, rows.Next())
+	var id, name, note sql.RawBytes
+	require.NoError(t, rows.Scan(&id, &name, &note))
+	assert.Equal(t, "1", string(id))
+	assert.Equal(t, "alice", string(name))
+	assert.Nil(t, note)
+}
+
+func TestHarness_columnTypeScanType(t *testing.T) {
+	client := mockAthenaHarnessClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(harnessColumns)}}}
+	db := newHarnessDB(t, client, map[string][]byte{
+		"bucket/results/query_2.csv":                 readFixture(t, "dl/nulls_and_quotes.csv"),
+		"bucket/results/tables/query_3-manifest.csv": []byte(""),
+	}, ResultModeAPI)
+	defer db.Close()
+
+	for _, ctx := range []context.Context{
+		context.Background(),
+		SetDLMode(context.Background()),
+		SetGzipDLMode(context.Background()),
+	} {
+		rows, err := db.QueryContext(ctx, "SELECT id, name, note FROM t")
+		require.NoError(t, err)
+		types, err := rows.ColumnTypes()
+		require.NoError(t, err)
+		require.Len(t, types, 3)
+		assert.Equal(t, reflect.TypeOf(int64(0)), types[0].ScanType())
+		assert.Equal(t, reflect.TypeOf(""), types[1].ScanType())
+		rows.Close()
+	}
+}
+
+func TestHarness_columnTypeNullable(t *testing.T) {
+	columns := []*athena.ColumnInfo{
+		genTypedColumnInfo("id", "integer"),
+		genTypedColumnInfo("name", "varchar"),
+		genTypedColumnInfo("note", "varchar"),
+	}
+	columns[0].Nullable = aws.String(athena.ColumnNullableNotNull)
+	columns[1].Nullable = aws.String(athena.ColumnNullableNullable)
+	columns[2].Nullable = aws.String(athena.ColumnNullableUnknown)
+	client := mockAthenaHarnessClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(columns)}}}
+	db := newHarnessDB(t, client, map[string][]byte{
+		"bucket/results/query_2.csv":                 readFixture(t, "dl/nulls_and_quotes.csv"),
+		"bucket/results/tables/query_3-manifest.csv": []byte(""),
+	}, ResultModeAPI)
+	defer db.Close()
+
+	type nullability struct{ nullable, ok bool }
+	tests := []struct {
+		ctx  context.Context
+		want []nullability
+	}{
+		{context.Background(), []nullability{{false, true}, {true, true}, {false, false}}},
+		{SetDLMode(context.Background()), []nullability{{false, true}, {true, true}, {false, false}}},
+		// the metadata of the CTAS table has no nullability
+		{SetGzipDLMode(context.Background()), []nullability{{false, false}, {false, false}, {false, false}}},
+	}
+	for _, test := range tests {
+		rows, err := db.QueryContext(test.ctx, "SELECT id, name, note FROM t")
+		require.NoError(t, err)
+		types, err := rows.ColumnTypes()
+		require.NoError(t, err)
+
+		var got []nullability
+		for _, ct := range types {
+			nullable, ok := ct.Nullable()
+			got = append(got, nullability{nullable, ok})
+		}
+		assert.Equal(t, test.want, got)
+		rows.Close()
+	}
+}
+
+func TestHarness_headerRow(t *testing.T) {
+	// a first row holding the column names must be read as a row, not as a header
+	want := [][]interface{}{
+		{int64(1), "name", "note"},
+		{int64(2), "bob", nil},
+	}
+
+	t.Run("api", func(t *testing.T) {
+		client := &mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{
+			results: genResults(harnessColumns,
+				[]*string{aws.String("1"), aws.String("name"), aws.String("note")},
+				[]*string{aws.String("2"), aws.String("bob"), nil},
+			),
+		}}
+		db := newHarnessDB(t, client, nil, ResultModeAPI)
+		defer db.Close()
+
+		assert.Equal(t, want, scanHarnessRows(t, db, context.Background()))
+	})
+
+	t.Run("dl", func(t *testing.T) {
+		client := &mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(harnessColumns)}}
+		db := newHarnessDB(t, client, map[string][]byte{
+			"bucket/results/query_1.csv": []byte("\"id\",\"name\",\"note\"\n\"1\",\"name\",\"note\"\n\"2\",\"bob\",\n"),
+		}, ResultModeDL)
+		defer db.Close()
+
+		assert.Equal(t, want, scanHarnessRows(t, db, context.Background()))
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		client := mockAthenaHarnessClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(harnessColumns)}}}
+		db := newHarnessDB(t, client, map[string][]byte{
+			"bucket/results/tables/query_1-manifest.csv": []byte("s3://bucket/results/tables/query_1/part-0.gz\n"),
+			"bucket/results/tables/query_1/part-0.gz":    gzipData(t, "1\001name\001note\n2\001bob\001\\N\n"),
+		}, ResultModeGzipDL)
+		defer db.Close()
+
+		assert.Equal(t, want, scanHarnessRows(t, db, context.Background()))
+	})
+}
@@ -0,0 +1,81 @@
+package athena
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// execMultiStatement runs the statements of query one by one, since Athena
+// runs a single statement per query, and stops at the first one failing.
+func (c *conn) execMultiStatement(ctx context.Context, query string) error {
+	token, hasToken := getClientRequestToken(ctx)
+	for i, statement := range splitStatements(query) {
+		stmtCtx := ctx
+		if hasToken {
+			// a token is used once, so each statement gets its own, cut to
+			// leave room for the suffix as that of a retry
+			suffix := fmt.Sprintf("-%d", i)
+			base := token
+			if len(base)+len(suffix) > clientRequestTokenMaxLength {
+				base = base[:clientRequestTokenMaxLength-len(suffix)]
+			}
+			stmtCtx = SetClientRequestToken(ctx, base+suffix)
+		}
+
+		if _, err := c.runQuery(stmtCtx, statement); err != nil {
+			return &MultiStatementError{Index: i, Statement: statement, Err: err}
+		}
+	}
+	return nil
+}
+
+// splitStatements splits query on the semicolons outside of quotes and
+// comments. The statements are trimmed, and empty ones are dropped.
+func splitStatements(query string) []string {
+	var statements []string
+	add := func(statement string) {
+		statement = strings.TrimSpace(statement)
+		if trimLeadingComments(statement) != "" {
+			statements = append(statements, statement)
+		}
+	}
+
+	start := 0
+	for i := 0; i < len(query); i++ {
+		switch query[i] {
+		case '\'', '"', '`':
+			// a quote in a string is escaped by doubling it, which reads
+			// as two strings next to each other
+			if end := strings.IndexByte(query[i+1:], query[i]); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(query)
+			}
+		case '-':
+			if strings.HasPrefix(query[i:], "--") {
+				if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+					i += end
+				} else {
+					i = len(query)
+				}
+			}
+		case '/':
+			if strings.HasPrefix(query[i:], "/*") {
+				if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+					i += end + 3
+				} else {
+					i = len(query)
+				}
+			}
+		case ';':
+			add(query[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(query) {
+		add(query[start:])
+	}
+
+	return statements
+}
@@ -0,0 +1,87 @@
+package athena
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_splitStatements(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"CREATE TABLE t (a int)", []string{"CREATE TABLE t (a int)"}},
+		{"CREATE TABLE t (a int);\nMSCK REPAIR TABLE t;\n", []string{"CREATE TABLE t (a int)", "MSCK REPAIR TABLE t"}},
+		{"SELECT 'a;b', \"c;d\", `e;f`; SELECT 'it''s;'", []string{"SELECT 'a;b', \"c;d\", `e;f`", "SELECT 'it''s;'"}},
+		{"-- drop it; then\nDROP TABLE t; /* done; */", []string{"-- drop it; then\nDROP TABLE t"}},
+		{"SELECT 1 /* a; b */ ; ; -- nothing\n", []string{"SELECT 1 /* a; b */"}},
+		{"SELECT 'unterminated;", []string{"SELECT 'unterminated;"}},
+		{" ; \n", nil},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, splitStatements(test.query), test.query)
+	}
+}
+
+func TestConn_multiStatement(t *testing.T) {
+	client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}
+	c := &conn{athena: client}
+
+	script := "CREATE TABLE t (a int);\nMSCK REPAIR TABLE t;\nDROP TABLE s;"
+
+	// a single statement unless it's asked for
+	_, err := c.ExecContext(context.Background(), script, nil)
+	require.NoError(t, err)
+	require.Len(t, client.startInputs, 1)
+
+	ctx := SetMultiStatement(context.Background(), true)
+	_, err = c.ExecContext(ctx, script, nil)
+	require.NoError(t, err)
+	require.Len(t, client.startInputs, 4)
+	assert.Equal(t, "CREATE TABLE t (a int)", *client.startInputs[1].QueryString)
+	assert.Equal(t, "MSCK REPAIR TABLE t", *client.startInputs[2].QueryString)
+	assert.Equal(t, "DROP TABLE s", *client.startInputs[3].QueryString)
+
+	// stops at the failing statement
+	client.failQuery = func(query string) string {
+		if strings.HasPrefix(query, "MSCK") {
+			return "FAILED: table t doesn't exist"
+		}
+		return ""
+	}
+	_, err = c.ExecContext(ctx, script, nil)
+	var multiErr *MultiStatementError
+	require.True(t, errors.As(err, &multiErr), err)
+	assert.Equal(t, 1, multiErr.Index)
+	assert.Equal(t, "MSCK REPAIR TABLE t", multiErr.Statement)
+	assert.EqualError(t, err, "statement 1 failed: FAILED: table t doesn't exist")
+	assert.True(t, isQueryFailed(err))
+	assert.Len(t, client.startInputs, 6)
+	client.failQuery = nil
+
+	// each statement gets its own client request token
+	token := strings.Repeat("a", 32)
+	_, err = c.ExecContext(SetClientRequestToken(ctx, token), script, nil)
+	require.NoError(t, err)
+	require.Len(t, client.startInputs, 9)
+	for i, input := range client.startInputs[6:] {
+		assert.Equal(t, fmt.Sprintf("%s-%d", token, i), aws.StringValue(input.ClientRequestToken))
+	}
+
+	// a token of the longest length is cut for the suffixes
+	token = strings.Repeat("b", clientRequestTokenMaxLength)
+	_, err = c.ExecContext(SetClientRequestToken(ctx, token), script, nil)
+	require.NoError(t, err)
+	require.Len(t, client.startInputs, 12)
+	for i, input := range client.startInputs[9:] {
+		assert.Equal(t, fmt.Sprintf("%s-%d", token[:clientRequestTokenMaxLength-2], i), aws.StringValue(input.ClientRequestToken))
+	}
+}
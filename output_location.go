@@ -0,0 +1,100 @@
+package athena
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+)
+
+const (
+	// outputLocationCacheTTLDefault is how long a workgroup's output location is cached
+	outputLocationCacheTTLDefault = 5 * time.Minute
+)
+
+type outputLocationCacheKey struct {
+	region    string
+	workgroup string
+}
+
+type outputLocationCacheEntry struct {
+	location  string
+	expiresAt time.Time
+}
+
+// outputLocationCache caches the output locations of workgroups, so that
+// connections don't call GetWorkGroup every time they are made.
+type outputLocationCache struct {
+	mu      sync.Mutex
+	entries map[outputLocationCacheKey]outputLocationCacheEntry
+	now     func() time.Time
+}
+
+var workgroupOutputLocations = &outputLocationCache{
+	entries: make(map[outputLocationCacheKey]outputLocationCacheEntry),
+	now:     time.Now,
+}
+
+func (c *outputLocationCache) get(key outputLocationCacheKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expiresAt) {
+		return "", false
+	}
+	return entry.location, true
+}
+
+func (c *outputLocationCache) set(key outputLocationCacheKey, location string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = outputLocationCacheEntry{
+		location:  location,
+		expiresAt: c.now().Add(ttl),
+	}
+}
+
+// getOutputLocation returns the output location configured in the workgroup
+// of cfg in region, using the cache unless it's disabled.
+func getOutputLocation(ctx context.Context, client athenaiface.AthenaAPI, region string, cfg *Config) (string, error) {
+	key := outputLocationCacheKey{
+		region:    region,
+		workgroup: cfg.WorkGroup,
+	}
+	if !cfg.DisableOutputLocationCache {
+		if location, ok := workgroupOutputLocations.get(key); ok {
+			return location, nil
+		}
+	}
+
+	resp, err := client.GetWorkGroupWithContext(ctx, &athena.GetWorkGroupInput{
+		WorkGroup: aws.String(cfg.WorkGroup),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var location string
+	if wg := resp.WorkGroup; wg != nil && wg.Configuration != nil && wg.Configuration.ResultConfiguration != nil {
+		location = aws.StringValue(wg.Configuration.ResultConfiguration.OutputLocation)
+	}
+	if location == "" {
+		return "", fmt.Errorf("%w: output_location isn't set and the workgroup has no output location", ErrOutputLocationRequired)
+	}
+
+	if !cfg.DisableOutputLocationCache {
+		ttl := cfg.OutputLocationCacheTTL
+		if ttl == 0 {
+			ttl = outputLocationCacheTTLDefault
+		}
+		workgroupOutputLocations.set(key, location, ttl)
+	}
+
+	return location, nil
+}
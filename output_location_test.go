@@ -0,0 +1,85 @@
+package athena
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockAthenaWorkGroupClient struct {
+	athenaiface.AthenaAPI
+	calls int
+}
+
+func (m *mockAthenaWorkGroupClient) GetWorkGroupWithContext(_ aws.Context, input *athena.GetWorkGroupInput, _ ...request.Option) (*athena.GetWorkGroupOutput, error) {
+	m.calls++
+	return &athena.GetWorkGroupOutput{
+		WorkGroup: &athena.WorkGroup{
+			Name: input.WorkGroup,
+			Configuration: &athena.WorkGroupConfiguration{
+				EngineVersion: &athena.EngineVersion{
+					SelectedEngineVersion:  aws.String("AUTO"),
+					EffectiveEngineVersion: aws.String("Athena engine version " + strings.TrimPrefix(*input.WorkGroup, "v")),
+				},
+				ResultConfiguration: &athena.ResultConfiguration{
+					OutputLocation: aws.String("s3://workgroup-results/" + *input.WorkGroup),
+				},
+			},
+		},
+	}, nil
+}
+
+func TestConnector_workgroupOutputLocation(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+	require.NoError(t, err)
+
+	now := time.Now()
+	workgroupOutputLocations.now = func() time.Time { return now }
+	defer func() { workgroupOutputLocations.now = time.Now }()
+
+	client := new(mockAthenaWorkGroupClient)
+	connect := func(cfg Config) *conn {
+		cfg.Session = sess
+		cfg.Database = AthenaDatabase
+		c := NewConnector(cfg).(*connector)
+		c.athena = client
+
+		dc, err := c.Connect(context.Background())
+		require.NoError(t, err)
+		return dc.(*conn)
+	}
+
+	c := connect(Config{WorkGroup: "cached"})
+	assert.Equal(t, "s3://workgroup-results/cached", c.OutputLocation)
+	assert.Equal(t, 1, client.calls)
+
+	// another Open of the same workgroup within the TTL
+	c = connect(Config{WorkGroup: "cached"})
+	assert.Equal(t, "s3://workgroup-results/cached", c.OutputLocation)
+	assert.Equal(t, 1, client.calls)
+
+	now = now.Add(outputLocationCacheTTLDefault)
+	connect(Config{WorkGroup: "cached"})
+	assert.Equal(t, 2, client.calls)
+
+	connect(Config{WorkGroup: "cached", OutputLocationCacheTTL: time.Hour})
+	now = now.Add(outputLocationCacheTTLDefault)
+	connect(Config{WorkGroup: "cached"})
+	assert.Equal(t, 3, client.calls)
+
+	connect(Config{WorkGroup: "cached", DisableOutputLocationCache: true})
+	assert.Equal(t, 4, client.calls)
+
+	c = connect(Config{WorkGroup: "cached", OutputLocation: "s3://configured"})
+	assert.Equal(t, "s3://configured", c.OutputLocation)
+	assert.Equal(t, 4, client.calls)
+}
@@ -0,0 +1,144 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// maxPageSize is the most rows GetQueryResults returns per page.
+const maxPageSize = 1000
+
+// QueryPage reads a page of the result of query with at most pageSize rows,
+// converted as the driver converts them, and returns the token of the next
+// page, which is empty after the last page.
+//
+// Without a token, query is run and the first page is returned. With the
+// token of a previous page, the next page of the same execution is read
+// without running query again, so the token can be handed to a client and
+// the next page read by another request:
+//
+//	rows, token, err := athena.QueryPage(ctx, db, query, "", 100)
+//	...
+//	rows, token, err = athena.QueryPage(ctx, db, query, token, 100)
+//
+// Pages are read in API mode. pageSize is 1000 at most, as Athena limits it.
+func QueryPage(ctx context.Context, db *sql.DB, query, token string, pageSize int32) ([][]interface{}, string, error) {
+	var rows [][]interface{}
+	var nextToken string
+	err := withConn(ctx, db, func(c *conn) error {
+		var err error
+		rows, nextToken, err = c.queryPage(ctx, query, token, pageSize)
+		return err
+	})
+	return rows, nextToken, err
+}
+
+func (c *conn) queryPage(ctx context.Context, query, token string, pageSize int32) ([][]interface{}, string, error) {
+	if pageSize <= 0 || pageSize > maxPageSize {
+		return nil, "", fmt.Errorf("invalid page size: %d", pageSize)
+	}
+
+	var queryID string
+	var athenaToken *string
+	if token == "" {
+		if c.readOnly && !isReadOnlyQuery(query) {
+			return nil, "", ErrReadOnly
+		}
+
+		if err := c.slots.acquire(ctx); err != nil {
+			return nil, "", err
+		}
+		defer c.slots.release()
+
+		var err error
+		queryID, _, _, err = c.execute(ctx, query, c.waitOptions(ctx))
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		var err error
+		queryID, athenaToken, err = decodePageToken(token)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// the first page starts with the header, which doesn't count as a row
+	skipHeader := athenaToken == nil && !isDDLQuery(query)
+	maxResults := int64(pageSize)
+	if skipHeader && maxResults < maxPageSize {
+		maxResults++
+	}
+
+	var out *athena.GetQueryResultsOutput
+	err := c.retrier().do(ctx, func() (err error) {
+		out, err = c.athena.GetQueryResultsWithContext(ctx, &athena.GetQueryResultsInput{
+			QueryExecutionId: aws.String(queryID),
+			NextToken:        athenaToken,
+			MaxResults:       aws.Int64(maxResults),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, "", wrapAPIError(err)
+	}
+
+	var results []*athena.Row
+	var columns []*athena.ColumnInfo
+	if out.ResultSet != nil {
+		results = out.ResultSet.Rows
+		if out.ResultSet.ResultSetMetadata != nil {
+			columns = out.ResultSet.ResultSetMetadata.ColumnInfo
+		}
+	}
+	if skipHeader && len(results) > 0 {
+		results = results[1:]
+	}
+
+	opts := getConvertOptions(ctx)
+	rows := make([][]interface{}, 0, len(results))
+	for _, result := range results {
+		dest := make([]driver.Value, len(columns))
+		if err := opts.convertRow(columns, result.Data, dest); err != nil {
+			return nil, "", err
+		}
+		row := make([]interface{}, len(dest))
+		for i, v := range dest {
+			row[i] = v
+		}
+		rows = append(rows, row)
+	}
+
+	var nextToken string
+	if next := aws.StringValue(out.NextToken); next != "" {
+		nextToken = encodePageToken(queryID, next)
+	}
+	return rows, nextToken, nil
+}
+
+// encodePageToken encodes the ID of a query and the token of the next page of
+// its result into a token of QueryPage.
+func encodePageToken(queryID, nextToken string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(queryID + ":" + nextToken))
+}
+
+func decodePageToken(token string) (string, *string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", nil, errors.New("invalid page token")
+	}
+
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", nil, errors.New("invalid page token")
+	}
+	return parts[0], aws.String(parts[1]), nil
+}
@@ -0,0 +1,116 @@
+package athena
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockAthenaPagingClient pages the rows of results, including the header,
+// by MaxResults or pageSize with the offset of the next page as the token.
+type mockAthenaPagingClient struct {
+	*mockAthenaConnClient
+	resultInputs []*athena.GetQueryResultsInput
+
+	// pageSize is used without MaxResults, which defaults to 1000
+	pageSize int
+}
+
+func (m *mockAthenaPagingClient) GetQueryResultsWithContext(_ aws.Context, input *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	m.resultInputs = append(m.resultInputs, input)
+
+	rows := m.results.ResultSet.Rows
+	start := 0
+	if input.NextToken != nil {
+		start, _ = strconv.Atoi(*input.NextToken)
+	}
+	pageSize := m.pageSize
+	if input.MaxResults != nil {
+		pageSize = int(*input.MaxResults)
+	} else if pageSize == 0 {
+		pageSize = maxPageSize
+	}
+	end := start + pageSize
+	out := &athena.GetQueryResultsOutput{}
+	if end < len(rows) {
+		out.NextToken = aws.String(strconv.Itoa(end))
+	} else {
+		end = len(rows)
+	}
+	out.ResultSet = &athena.ResultSet{
+		ResultSetMetadata: m.results.ResultSet.ResultSetMetadata,
+		Rows:              rows[start:end],
+	}
+	return out, nil
+}
+
+func TestQueryPage(t *testing.T) {
+	client := &mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{genTypedColumnInfo("id", "integer")},
+			[]*string{aws.String("1")}, []*string{aws.String("2")}, []*string{aws.String("3")},
+		),
+	}}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	rows, token, err := QueryPage(context.Background(), db, "SELECT id FROM t", "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{int64(1)}, {int64(2)}}, rows)
+	require.NotEmpty(t, token)
+	assert.Equal(t, int64(3), *client.resultInputs[0].MaxResults)
+
+	// the next page is read from the same execution
+	rows, token, err = QueryPage(context.Background(), db, "SELECT id FROM t", token, 2)
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{int64(3)}}, rows)
+	assert.Empty(t, token)
+	assert.Len(t, client.startInputs, 1)
+	assert.Equal(t, "query_1", *client.resultInputs[1].QueryExecutionId)
+	assert.Equal(t, int64(2), *client.resultInputs[1].MaxResults)
+
+	_, _, err = QueryPage(context.Background(), db, "SELECT id FROM t", "invalid", 2)
+	assert.EqualError(t, err, "invalid page token")
+	_, _, err = QueryPage(context.Background(), db, "SELECT id FROM t", "", 1001)
+	assert.Error(t, err)
+}
+
+func TestQueryPage_readOnly(t *testing.T) {
+	client := &mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}}
+	c := &conn{athena: client, readOnly: true}
+
+	_, _, err := c.queryPage(context.Background(), "DROP TABLE t", "", 10)
+	assert.True(t, errors.Is(err, ErrReadOnly))
+	assert.Empty(t, client.startInputs)
+
+	_, _, err = c.queryPage(context.Background(), "SELECT name FROM t", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, client.startInputs, 1)
+}
+
+func TestQueryPage_bytesScannedCutoff(t *testing.T) {
+	client := &mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{
+		results:    genResults([]*athena.ColumnInfo{genColumnInfo("name")}),
+		state:      athena.QueryExecutionStateRunning,
+		statistics: &athena.QueryExecutionStatistics{DataScannedInBytes: aws.Int64(2048)},
+	}}
+	c := &conn{athena: client, bytesScannedCutoff: 1024, clock: &fakeClock{}}
+
+	_, _, err := c.queryPage(context.Background(), "SELECT name FROM t", "", 10)
+	assert.True(t, errors.Is(err, ErrBytesScannedExceeded))
+	assert.Equal(t, []string{"query_1"}, client.stopped)
+	assert.Empty(t, client.resultInputs)
+
+	// the cutoff of the context takes precedence
+	client.states = []string{athena.QueryExecutionStateRunning}
+	client.state = athena.QueryExecutionStateSucceeded
+	_, _, err = c.queryPage(SetBytesScannedCutoff(context.Background(), 4096), "SELECT name FROM t", "", 10)
+	assert.NoError(t, err)
+}
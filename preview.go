@@ -0,0 +1,34 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Preview runs a SELECT query limited to its first n rows in API mode, which
+// returns the first rows without waiting for the whole result to be downloaded.
+// The query is wrapped in a SELECT with a LIMIT of n, which works whatever
+// the query ends with, e.g. a LIMIT, FETCH FIRST or a comment. Athena may
+// ignore the ORDER BY of the wrapped query, so the order of the rows isn't
+// guaranteed.
+func Preview(ctx context.Context, db *sql.DB, query string, n int) (*sql.Rows, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid number of preview rows: %d", n)
+	}
+	if !isSelectQuery(query) {
+		return nil, errors.New("only SELECT queries can be previewed")
+	}
+
+	return db.QueryContext(SetAPIMode(ctx), limitQuery(query, n))
+}
+
+// limitQuery limits query to n rows.
+func limitQuery(query string, n int) string {
+	query = strings.TrimRight(query, " \t\r\n;")
+
+	// the newline ends a trailing line comment
+	return fmt.Sprintf("SELECT * FROM (\n%s\n) LIMIT %d", query, n)
+}
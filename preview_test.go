@@ -0,0 +1,59 @@
+package athena
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreview(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{genColumnInfo("name")},
+			[]*string{aws.String("a")},
+			[]*string{aws.String("b")},
+		),
+	}
+	db := newMockDB(t, client, Config{ResultMode: ResultModeDL})
+	defer db.Close()
+
+	rows, err := Preview(context.Background(), db, "SELECT name FROM t ORDER BY name;", 2)
+	require.NoError(t, err)
+	var names []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	assert.LessOrEqual(t, len(names), 2)
+	assert.Equal(t, "SELECT * FROM (\nSELECT name FROM t ORDER BY name\n) LIMIT 2", *client.startInputs[0].QueryString)
+
+	_, err = Preview(context.Background(), db, "DROP TABLE t", 2)
+	assert.Error(t, err)
+	_, err = Preview(context.Background(), db, "SELECT name FROM t", 0)
+	assert.Error(t, err)
+	assert.Len(t, client.startInputs, 1)
+}
+
+func Test_limitQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "SELECT 1", want: "SELECT * FROM (\nSELECT 1\n) LIMIT 100"},
+		{query: "SELECT 1 -- comment", want: "SELECT * FROM (\nSELECT 1 -- comment\n) LIMIT 100"},
+		{query: "SELECT * FROM t LIMIT 5 -- note", want: "SELECT * FROM (\nSELECT * FROM t LIMIT 5 -- note\n) LIMIT 100"},
+		{query: "SELECT * FROM t /* note */", want: "SELECT * FROM (\nSELECT * FROM t /* note */\n) LIMIT 100"},
+		{query: "SELECT * FROM t FETCH FIRST 5 ROWS ONLY", want: "SELECT * FROM (\nSELECT * FROM t FETCH FIRST 5 ROWS ONLY\n) LIMIT 100"},
+		{query: "WITH x AS (SELECT 1 AS a) SELECT a FROM x", want: "SELECT * FROM (\nWITH x AS (SELECT 1 AS a) SELECT a FROM x\n) LIMIT 100"},
+		{query: "SELECT * FROM t limit 1000;\n", want: "SELECT * FROM (\nSELECT * FROM t limit 1000\n) LIMIT 100"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, limitQuery(tt.query, 100), tt.query)
+	}
+}
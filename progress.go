@@ -0,0 +1,63 @@
+package athena
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// QueryProgress is the progress of a query reported while waiting for it.
+// Athena doesn't report how much of a query is done, so the progress is
+// the phase of the query, i.e. its state, and how long it has been in it.
+type QueryProgress struct {
+	QueryID string
+
+	// Phase is the state of the query, e.g. QUEUED, RUNNING or SUCCEEDED.
+	Phase string
+
+	// Elapsed is the time since the driver started waiting for the query.
+	Elapsed time.Duration
+
+	// PhaseElapsed is the time since the driver saw the query enter Phase.
+	PhaseElapsed time.Duration
+
+	// DataScannedInBytes is the data scanned so far, if Athena reports it.
+	DataScannedInBytes int64
+}
+
+// progressTracker turns the polled executions of a query into QueryProgress.
+type progressTracker struct {
+	queryID    string
+	start      time.Time
+	phase      string
+	phaseStart time.Time
+	callback   func(QueryProgress)
+}
+
+func newProgressTracker(queryID string, start time.Time, callback func(QueryProgress)) *progressTracker {
+	return &progressTracker{queryID: queryID, start: start, callback: callback}
+}
+
+func (t *progressTracker) report(execution *athena.QueryExecution, now time.Time) {
+	if t.callback == nil || execution == nil || execution.Status == nil {
+		return
+	}
+
+	phase := aws.StringValue(execution.Status.State)
+	if phase != t.phase {
+		t.phase = phase
+		t.phaseStart = now
+	}
+
+	p := QueryProgress{
+		QueryID:      t.queryID,
+		Phase:        phase,
+		Elapsed:      now.Sub(t.start),
+		PhaseElapsed: now.Sub(t.phaseStart),
+	}
+	if execution.Statistics != nil {
+		p.DataScannedInBytes = aws.Int64Value(execution.Statistics.DataScannedInBytes)
+	}
+	t.callback(p)
+}
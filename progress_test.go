@@ -0,0 +1,43 @@
+package athena
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn_progressCallback(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults([]*athena.ColumnInfo{genColumnInfo("name")}),
+		states: []string{
+			athena.QueryExecutionStateQueued,
+			athena.QueryExecutionStateQueued,
+			athena.QueryExecutionStateRunning,
+			athena.QueryExecutionStateRunning,
+			athena.QueryExecutionStateRunning,
+		},
+	}
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := &conn{athena: client, pollFrequency: time.Second, clock: clock}
+
+	var got []QueryProgress
+	ctx := SetProgressCallback(context.Background(), func(p QueryProgress) {
+		got = append(got, p)
+	})
+	_, err := c.QueryContext(ctx, "SELECT 1", nil)
+	require.NoError(t, err)
+
+	want := []QueryProgress{
+		{QueryID: "query_1", Phase: athena.QueryExecutionStateQueued, Elapsed: 0, PhaseElapsed: 0},
+		{QueryID: "query_1", Phase: athena.QueryExecutionStateQueued, Elapsed: time.Second, PhaseElapsed: time.Second},
+		{QueryID: "query_1", Phase: athena.QueryExecutionStateRunning, Elapsed: 2 * time.Second, PhaseElapsed: 0},
+		{QueryID: "query_1", Phase: athena.QueryExecutionStateRunning, Elapsed: 3 * time.Second, PhaseElapsed: time.Second},
+		{QueryID: "query_1", Phase: athena.QueryExecutionStateRunning, Elapsed: 4 * time.Second, PhaseElapsed: 2 * time.Second},
+		{QueryID: "query_1", Phase: athena.QueryExecutionStateSucceeded, Elapsed: 5 * time.Second, PhaseElapsed: 0},
+	}
+	assert.Equal(t, want, got)
+}
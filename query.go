@@ -0,0 +1,88 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DrainRows reads rows to the end and closes them, releasing the connection
+// and running any cleanup of the result. It's useful for fire-and-forget
+// queries whose rows aren't needed, e.g. a CTAS run via QueryContext:
+//
+//	rows, err := db.QueryContext(ctx, "CREATE TABLE t AS SELECT ...")
+//	if err != nil {
+//		return err
+//	}
+//	return athena.DrainRows(rows)
+func DrainRows(rows *sql.Rows) error {
+	defer rows.Close()
+
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return rows.Close()
+}
+
+// QueryChan runs query on db and sends every row on the first channel, with
+// the values the driver converts them to. The row channel is closed after
+// the last row or on an error, which is sent on the error channel before it's
+// closed as well. Once ctx is done no more rows are sent and ctx.Err() is
+// sent as the error.
+//
+//	rowCh, errCh := athena.QueryChan(ctx, db, query)
+//	for row := range rowCh {
+//		...
+//	}
+//	if err := <-errCh; err != nil {
+//		return err
+//	}
+func QueryChan(ctx context.Context, db *sql.DB, query string) (<-chan []interface{}, <-chan error) {
+	rowCh := make(chan []interface{})
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(errCh)
+		defer close(rowCh)
+
+		if err := sendRows(ctx, db, query, rowCh); err != nil {
+			errCh <- err
+		}
+	}()
+
+	return rowCh, errCh
+}
+
+func sendRows(ctx context.Context, db *sql.DB, query string, rowCh chan<- []interface{}) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		row := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		select {
+		case rowCh <- row:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return rows.Err()
+}
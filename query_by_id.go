@@ -0,0 +1,23 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// QueryByID reads the result of a query execution started elsewhere, e.g. by
+// another process, rather than running a query. A query still running is
+// waited on within the timeout of the connection or SetTimeout, but it's
+// left running when the wait ends early.
+//
+// The result is read in the result mode of the connection or of ctx as for a
+// query run by the driver, except for GZIP DL mode, which reads the result
+// CSV as DL mode does since the query wasn't wrapped in a CTAS statement.
+func QueryByID(ctx context.Context, db *sql.DB, queryID string) (*sql.Rows, error) {
+	if queryID == "" {
+		return nil, errors.New("query execution ID is required")
+	}
+
+	return db.QueryContext(context.WithValue(ctx, AttachedQueryIDContextKey, queryID), "")
+}
@@ -0,0 +1,75 @@
+package athena
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryByID(t *testing.T) {
+	newClient := func() *mockAthenaPagingClient {
+		return &mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{
+			results: genResults([]*athena.ColumnInfo{genColumnInfo("name")}, []*string{aws.String("a")}),
+			query:   "SELECT name FROM t",
+		}}
+	}
+	for _, mode := range []ResultMode{ResultModeAPI, ResultModeDL, ResultModeGzipDL} {
+		client := newClient()
+		db := newHarnessDB(t, client, map[string][]byte{
+			"bucket/results/started-elsewhere.csv": []byte("\"name\"\n\"a\"\n"),
+		}, mode)
+
+		ctx := SetCaptureQueryID(context.Background())
+		rows, err := QueryByID(ctx, db, "started-elsewhere")
+		require.NoError(t, err, mode)
+		var names []string
+		for rows.Next() {
+			var name string
+			require.NoError(t, rows.Scan(&name), mode)
+			names = append(names, name)
+		}
+		require.NoError(t, rows.Err(), mode)
+		rows.Close()
+		db.Close()
+
+		assert.Equal(t, []string{"a"}, names, mode)
+		assert.Empty(t, client.startInputs, mode)
+		queryID, _ := LastQueryID(ctx)
+		assert.Equal(t, "started-elsewhere", queryID, mode)
+	}
+
+	db := newHarnessDB(t, newClient(), nil, ResultModeAPI)
+	defer db.Close()
+	_, err := QueryByID(context.Background(), db, "")
+	assert.Error(t, err)
+}
+
+func TestConn_attachedQueryKeepsRunning(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults([]*athena.ColumnInfo{genColumnInfo("name")}),
+		states:  []string{athena.QueryExecutionStateRunning, athena.QueryExecutionStateRunning},
+		query:   "SELECT 1",
+	}
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := &conn{athena: client, pollFrequency: 5 * time.Second, timeout: 60, clock: clock}
+
+	// waits for the query to finish
+	ctx := context.WithValue(context.Background(), AttachedQueryIDContextKey, "started-elsewhere")
+	_, err := c.QueryContext(ctx, "", nil)
+	require.NoError(t, err)
+	// the first RUNNING is reported when the statement of the query is read
+	assert.Equal(t, 5*time.Second, clock.Since(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	client.state = athena.QueryExecutionStateRunning
+	_, err = c.QueryContext(ctx, "", nil)
+	assert.True(t, errors.Is(err, ErrQueryTimeout))
+	// a query started elsewhere isn't stopped
+	assert.Empty(t, client.stopped)
+	assert.Empty(t, client.startInputs)
+}
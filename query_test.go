@@ -0,0 +1,132 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainRows(t *testing.T) {
+	t.Run("api", func(t *testing.T) {
+		client := &mockAthenaPagingClient{
+			mockAthenaConnClient: &mockAthenaConnClient{
+				results: genResults(
+					[]*athena.ColumnInfo{genColumnInfo("name")},
+					[]*string{aws.String("a")},
+					[]*string{aws.String("b")},
+					[]*string{aws.String("c")},
+				),
+			},
+			pageSize: 2,
+		}
+		db := newMockDB(t, client, Config{})
+		defer db.Close()
+
+		rows, err := db.QueryContext(context.Background(), "SELECT name FROM t")
+		require.NoError(t, err)
+		assert.Equal(t, 1, db.Stats().InUse)
+		assert.Len(t, client.resultInputs, 1)
+
+		require.NoError(t, DrainRows(rows))
+		assert.Equal(t, 0, db.Stats().InUse)
+		assert.False(t, rows.Next())
+		// every page of the header and three rows was read
+		assert.Len(t, client.resultInputs, 2)
+	})
+
+	t.Run("dl", func(t *testing.T) {
+		client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}
+		s3Client := &mockStreamingS3Client{body: &closeRecorder{Reader: strings.NewReader("\"name\"\n\"a\"\n\"b\"\n")}}
+		sess, err := session.NewSession(&aws.Config{Region: aws.String(AwsRegion)})
+		require.NoError(t, err)
+		c := NewConnector(Config{
+			Session:        sess,
+			Database:       AthenaDatabase,
+			OutputLocation: "s3://bucket/results",
+			ResultMode:     ResultModeDL,
+		}).(*connector)
+		c.athena = client
+		c.s3 = s3Client
+		db := sql.OpenDB(c)
+		defer db.Close()
+
+		ctx := SetCaptureDownloadedBytes(context.Background())
+		rows, err := db.QueryContext(ctx, "SELECT name FROM t")
+		require.NoError(t, err)
+
+		require.NoError(t, DrainRows(rows))
+		assert.Equal(t, 0, db.Stats().InUse)
+		// the whole result was downloaded, and the download was closed
+		downloaded, _ := DownloadedBytes(ctx)
+		assert.Equal(t, int64(len("\"name\"\n\"a\"\n\"b\"\n")), downloaded)
+		assert.True(t, s3Client.body.closed)
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		client := mockAthenaHarnessClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{results: genResults(harnessColumns)}}}
+		db := newHarnessDB(t, client, map[string][]byte{
+			"bucket/results/tables/query_1-manifest.csv": []byte("s3://bucket/results/tables/query_1/part-0.gz\n"),
+			"bucket/results/tables/query_1/part-0.gz":    gzipData(t, "1\001alice\001\\N\n"),
+		}, ResultModeGzipDL)
+		defer db.Close()
+
+		rows, err := db.QueryContext(context.Background(), "SELECT id, name, note FROM t")
+		require.NoError(t, err)
+
+		require.NoError(t, DrainRows(rows))
+		assert.Equal(t, 0, db.Stats().InUse)
+		// the CTAS table was dropped
+		require.Len(t, client.startInputs, 2)
+		ctasTable := strings.Fields(*client.startInputs[0].QueryString)[2]
+		assert.Equal(t, "DROP TABLE "+ctasTable, *client.startInputs[1].QueryString)
+	})
+}
+
+func TestQueryChan(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{genColumnInfo("name"), genTypedColumnInfo("n", "integer")},
+			[]*string{aws.String("a"), aws.String("1")},
+			[]*string{aws.String("b"), nil},
+			[]*string{aws.String("c"), aws.String("3")},
+		),
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	rowCh, errCh := QueryChan(context.Background(), db, "SELECT name, n FROM t")
+	var got [][]interface{}
+	for row := range rowCh {
+		got = append(got, row)
+	}
+	require.NoError(t, <-errCh)
+	assert.Equal(t, [][]interface{}{{"a", int64(1)}, {"b", nil}, {"c", int64(3)}}, got)
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		rowCh, errCh := QueryChan(ctx, db, "SELECT name, n FROM t")
+		assert.Equal(t, []interface{}{"a", int64(1)}, <-rowCh)
+
+		cancel()
+		assert.Equal(t, context.Canceled, <-errCh)
+		_, ok := <-rowCh
+		assert.False(t, ok)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		client.failQuery = func(string) string { return "SYNTAX_ERROR: line 1:1: Table t does not exist" }
+		defer func() { client.failQuery = nil }()
+
+		rowCh, errCh := QueryChan(context.Background(), db, "SELECT name, n FROM t")
+		_, ok := <-rowCh
+		assert.False(t, ok)
+		assert.EqualError(t, <-errCh, "SYNTAX_ERROR: line 1:1: Table t does not exist")
+	})
+}
@@ -0,0 +1,44 @@
+package athena
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+)
+
+// ResultCompression is the compression of the result CSV in DL Mode.
+type ResultCompression int
+
+const (
+	// ResultCompressionAuto detects gzip by the `.gz` key or the content of the result
+	ResultCompressionAuto ResultCompression = 0
+
+	// ResultCompressionNone reads the result as is
+	ResultCompressionNone ResultCompression = 1
+
+	// ResultCompressionGzip decompresses the result with gzip
+	ResultCompressionGzip ResultCompression = 2
+)
+
+// gzipMagic are the first bytes of gzip data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompressResult returns a reader of the decompressed content of the
+// result object key, which is read from body.
+func decompressResult(body io.Reader, key string, compression ResultCompression) (io.Reader, error) {
+	reader := bufio.NewReader(body)
+	switch compression {
+	case ResultCompressionNone:
+		return reader, nil
+	case ResultCompressionAuto:
+		// fewer bytes are returned with an error for shorter results
+		head, _ := reader.Peek(len(gzipMagic))
+		if !strings.HasSuffix(key, ".gz") && !bytes.Equal(head, gzipMagic) {
+			return reader, nil
+		}
+	}
+
+	return gzip.NewReader(reader)
+}
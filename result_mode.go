@@ -1,5 +1,7 @@
 package athena
 
+import "fmt"
+
 // ResultMode Results mode
 type ResultMode int
 
@@ -13,3 +15,16 @@ const (
 	// ResultModeGzipDL ctas query and download gzip file Mode
 	ResultModeGzipDL ResultMode = 2
 )
+
+// String returns the name of the mode, e.g. "GZIP DL".
+func (m ResultMode) String() string {
+	switch m {
+	case ResultModeAPI:
+		return "API"
+	case ResultModeDL:
+		return "DL"
+	case ResultModeGzipDL:
+		return "GZIP DL"
+	}
+	return fmt.Sprintf("ResultMode(%d)", int(m))
+}
@@ -0,0 +1,68 @@
+package athena
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	// retryBackoffBase and retryBackoffMax bound the wait before a retry,
+	// which doubles with every attempt
+	retryBackoffBase = 100 * time.Millisecond
+	retryBackoffMax  = 5 * time.Second
+)
+
+// retrier retries AWS API calls failing with throttling or transient errors
+// up to maxRetries times. clock is only used when maxRetries isn't zero.
+type retrier struct {
+	maxRetries int
+	clock      clock
+}
+
+// do calls call until it succeeds, fails with an error that isn't retried,
+// or has been retried maxRetries times. The last error is returned as is.
+func (r retrier) do(ctx context.Context, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= r.maxRetries || !isRetryableAPIError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-r.clock.After(retryBackoff(attempt)):
+		}
+	}
+}
+
+// retryBackoff returns how long to wait before retrying after attempt.
+func retryBackoff(attempt int) time.Duration {
+	if attempt >= 6 {
+		return retryBackoffMax
+	}
+	if d := retryBackoffBase << uint(attempt); d < retryBackoffMax {
+		return d
+	}
+	return retryBackoffMax
+}
+
+// isRetryableAPIError reports whether an AWS API call failed with throttling,
+// e.g. ThrottlingException, or a transient error such as a 5xx status.
+func isRetryableAPIError(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	if request.IsErrorThrottle(aerr) || request.IsErrorRetryable(aerr) {
+		return true
+	}
+
+	var failure awserr.RequestFailure
+	return errors.As(err, &failure) && failure.StatusCode() >= http.StatusInternalServerError
+}
@@ -0,0 +1,88 @@
+package athena
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_isRetryableAPIError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.New("ThrottlingException", "Rate exceeded", nil), true},
+		{awserr.New("TooManyRequestsException", "slow down", nil), true},
+		{awserr.NewRequestFailure(awserr.New("InternalServerException", "oops", nil), 500, "req"), true},
+		{awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "unavailable", nil), 503, "req"), true},
+		{fmt.Errorf("polling: %w", awserr.New("ThrottlingException", "Rate exceeded", nil)), true},
+		{awserr.New(athena.ErrCodeInvalidRequestException, "line 1:8: mismatched input", nil), false},
+		{awserr.NewRequestFailure(awserr.New("AccessDeniedException", "denied", nil), 403, "req"), false},
+		{errors.New("boom"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, isRetryableAPIError(test.err), "%v", test.err)
+	}
+}
+
+func Test_retryBackoff(t *testing.T) {
+	assert.Equal(t, 100*time.Millisecond, retryBackoff(0))
+	assert.Equal(t, 200*time.Millisecond, retryBackoff(1))
+	assert.Equal(t, 3200*time.Millisecond, retryBackoff(5))
+	assert.Equal(t, 5*time.Second, retryBackoff(6))
+	assert.Equal(t, 5*time.Second, retryBackoff(100))
+}
+
+func Test_retrier(t *testing.T) {
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	clock := &fakeClock{now: start}
+	calls := 0
+	err := retrier{maxRetries: 3, clock: clock}.do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return throttled
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 300*time.Millisecond, clock.Since(start))
+
+	// gives up after maxRetries
+	calls = 0
+	err = retrier{maxRetries: 2, clock: clock}.do(context.Background(), func() error {
+		calls++
+		return throttled
+	})
+	assert.Equal(t, throttled, err)
+	assert.Equal(t, 3, calls)
+
+	// other errors pass through unchanged
+	invalid := awserr.New(athena.ErrCodeInvalidRequestException, "invalid", nil)
+	calls = 0
+	err = retrier{maxRetries: 3, clock: clock}.do(context.Background(), func() error {
+		calls++
+		return invalid
+	})
+	assert.Equal(t, invalid, err)
+	assert.Equal(t, 1, calls)
+
+	// no retries by default
+	calls = 0
+	err = retrier{}.do(context.Background(), func() error {
+		calls++
+		return throttled
+	})
+	assert.Equal(t, throttled, err)
+	assert.Equal(t, 1, calls)
+
+}
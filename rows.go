@@ -1,29 +1,258 @@
 package athena
 
 import (
+	"context"
 	"database/sql/driver"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/text/encoding"
 )
 
 type rowsConfig struct {
-	Athena         athenaiface.AthenaAPI
-	QueryID        string
-	SkipHeader     bool
-	ResultMode     ResultMode
-	Session        *session.Session
-	OutputLocation string
-	Timeout        uint
-	AfterDownload  func() error
-	CTASTable      string
-	DB             string
-	Catalog        string
+	Athena            athenaiface.AthenaAPI
+	QueryID           string
+	ResultMode        ResultMode
+	S3                s3iface.S3API
+	OutputLocation    string
+	ResultLocation    string
+	ResultKeyTemplate string
+	Timeout           uint
+	AfterDownload     func() error
+	CTASTable         string
+	DB                string
+	Catalog           string
+	RawResponse       *rawResponse
+	ColumnInfos       *columnInfoCapture
+	DownloadedBytes   *downloadedBytes
+	ResultEncoding    encoding.Encoding
+	TrimSpaceAsNull   bool
+	ResultCompression ResultCompression
+	DescribeCTASTable func() ([]*athena.Column, error)
+	Logf              func(format string, v ...interface{})
+	RowProfiler       func(rowIndex int, dur time.Duration)
+	CSVDelimiter      rune
+	CSVQuote          rune
+	ConvertOptions    convertOptions
+
+	// DownloadConcurrency is how many objects GZIP DL mode downloads at once
+	DownloadConcurrency int
+
+	// NormalizeColumnNames lowercases the names returned by Columns
+	NormalizeColumnNames bool
+
+	// SkipHeader drops the header starting the first GetQueryResults page
+	// in API mode. DL mode always drops the header line of the CSV, while
+	// the TEXTFILE objects of GZIP DL mode come without one.
+	SkipHeader bool
+
+	// Retrier retries the Athena API calls throttled while reading the result
+	Retrier retrier
+}
+
+// rawResponse holds the last GetQueryResults response of a query.
+type rawResponse struct {
+	out *athena.GetQueryResultsOutput
+}
+
+// capture keeps a copy of out, whose rows are consumed while iterating.
+func (r *rawResponse) capture(out *athena.GetQueryResultsOutput) {
+	if r == nil || out == nil {
+		return
+	}
+
+	captured := *out
+	if out.ResultSet != nil {
+		rs := *out.ResultSet
+		captured.ResultSet = &rs
+	}
+	r.out = &captured
+}
+
+// downloadedBytes is the total size of the result objects downloaded for a query.
+type downloadedBytes struct {
+	mu    sync.Mutex
+	total int64
+}
+
+func (d *downloadedBytes) add(n int) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	d.total += int64(n)
+	d.mu.Unlock()
+}
+
+func (d *downloadedBytes) get() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.total
+}
+
+// downloadObject reads a whole S3 object.
+func downloadObject(ctx context.Context, client s3iface.S3API, bucket, key string) ([]byte, error) {
+	resp, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapAPIError(err)
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// maxResumes is how many times the download of an object is resumed after
+// errors reading it.
+const maxResumes = 3
+
+// resumableBody is the body of an S3 object, which resumes from the bytes
+// read so far with a Range request when reading it fails, e.g. because the
+// connection dropped.
+type resumableBody struct {
+	ctx    context.Context
+	client s3iface.S3API
+	bucket string
+	key    string
+
+	// etag makes a resumed download fail if the object was replaced
+	etag    *string
+	body    io.ReadCloser
+	offset  int64
+	resumes int
+
+	// err is returned by every read once the download is given up
+	err error
+}
+
+// openResumableObject returns the body of an S3 object, which is read as it's
+// downloaded.
+func openResumableObject(ctx context.Context, client s3iface.S3API, bucket, key string) (*resumableBody, error) {
+	resp, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapAPIError(err)
+	}
+
+	return &resumableBody{
+		ctx:    ctx,
+		client: client,
+		bucket: bucket,
+		key:    key,
+		etag:   resp.ETag,
+		body:   resp.Body,
+	}, nil
+}
+
+func (b *resumableBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	for {
+		n, err := b.body.Read(p)
+		b.offset += int64(n)
+		switch {
+		case err == nil, err == io.EOF:
+			return n, err
+		case n > 0:
+			// the error is met again by the next read
+			return n, nil
+		case b.ctx.Err() != nil, b.resumes >= maxResumes:
+			b.err = err
+			return 0, err
+		}
+
+		if resumeErr := b.resume(); resumeErr != nil {
+			b.err = fmt.Errorf("%v, and resuming the download failed: %w", err, resumeErr)
+			return 0, b.err
+		}
+	}
+}
+
+func (b *resumableBody) resume() error {
+	b.resumes++
+	b.body.Close()
+
+	resp, err := b.client.GetObjectWithContext(b.ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(b.bucket),
+		Key:     aws.String(b.key),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-", b.offset)),
+		IfMatch: b.etag,
+	})
+	if err != nil {
+		return wrapAPIError(err)
+	}
+	b.body = resp.Body
+	return nil
+}
+
+func (b *resumableBody) Close() error {
+	return b.body.Close()
+}
+
+// countingReader adds the bytes read from reader to downloaded.
+type countingReader struct {
+	reader     io.Reader
+	downloaded *downloadedBytes
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.downloaded.add(n)
+	return n, err
+}
+
+// queryIDCapture holds the ID of the last query run with a context.
+type queryIDCapture struct {
+	mu sync.Mutex
+	id string
+}
+
+func (q *queryIDCapture) set(id string) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	q.id = id
+	q.mu.Unlock()
+}
+
+func (q *queryIDCapture) get() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.id
+}
+
+// QueryIDProvider is implemented by the driver.Rows of every result mode,
+// e.g. for sql.Conn.Raw users. Use SetCaptureQueryID with database/sql.
+type QueryIDProvider interface {
+	// QueryID returns the ID of the query execution the rows are read from.
+	// In GZIP DL Mode it's the execution of the CTAS statement.
+	QueryID() string
 }
 
 type downloadedRows struct {
 	cursor int
-	data   [][]string        // for gzip dl
-	field  [][]downloadField // for csv dl
+	data   [][]string // for gzip dl
 }
 
 type downloadField struct {
@@ -31,17 +260,132 @@ type downloadField struct {
 	isNil bool
 }
 
-func newRows(cfg rowsConfig) (driver.Rows, error) {
+// newRows reads the result of a finished query. ctx bounds the download of
+// the result, along with the timeout of cfg.
+func newRows(ctx context.Context, cfg rowsConfig) (driver.Rows, error) {
 	var r driver.Rows
 	var err error
 	switch cfg.ResultMode {
 	case ResultModeDL:
-		r, err = newRowsDL(cfg)
+		r, err = newRowsDL(ctx, cfg)
 	case ResultModeGzipDL:
-		r, err = newRowsGzipDL(cfg)
+		r, err = newRowsGzipDL(ctx, cfg)
 	default:
-		r, err = newRowsAPI(cfg)
+		r, err = newRowsAPI(ctx, cfg)
+	}
+	if err == nil && cfg.NormalizeColumnNames {
+		r = &normalizedRows{Rows: r}
+	}
+	if err == nil && cfg.RowProfiler != nil {
+		r = &profiledRows{Rows: r, profiler: cfg.RowProfiler}
 	}
 
 	return r, err
 }
+
+// profiledRows reports the time each row takes to be read to a profiler.
+type profiledRows struct {
+	driver.Rows
+	profiler func(rowIndex int, dur time.Duration)
+	index    int
+}
+
+func (r *profiledRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.Rows.(driver.RowsColumnTypeDatabaseTypeName).ColumnTypeDatabaseTypeName(index)
+}
+
+func (r *profiledRows) ColumnTypeScanType(index int) reflect.Type {
+	return r.Rows.(driver.RowsColumnTypeScanType).ColumnTypeScanType(index)
+}
+
+func (r *profiledRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return r.Rows.(driver.RowsColumnTypeNullable).ColumnTypeNullable(index)
+}
+
+func (r *profiledRows) QueryID() string {
+	return r.Rows.(QueryIDProvider).QueryID()
+}
+
+func (r *profiledRows) Next(dest []driver.Value) error {
+	start := time.Now()
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	r.profiler(r.index, time.Since(start))
+	r.index++
+	return nil
+}
+
+// normalizedRows returns the column names of Rows lowercased.
+type normalizedRows struct {
+	driver.Rows
+}
+
+func (r *normalizedRows) Columns() []string {
+	columns := r.Rows.Columns()
+	for i, column := range columns {
+		columns[i] = strings.ToLower(column)
+	}
+	return columns
+}
+
+func (r *normalizedRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.Rows.(driver.RowsColumnTypeDatabaseTypeName).ColumnTypeDatabaseTypeName(index)
+}
+
+func (r *normalizedRows) ColumnTypeScanType(index int) reflect.Type {
+	return r.Rows.(driver.RowsColumnTypeScanType).ColumnTypeScanType(index)
+}
+
+func (r *normalizedRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return r.Rows.(driver.RowsColumnTypeNullable).ColumnTypeNullable(index)
+}
+
+func (r *normalizedRows) QueryID() string {
+	return r.Rows.(QueryIDProvider).QueryID()
+}
+
+// columnInfoNullable reports whether a result column is nullable, as
+// RowsColumnTypeNullable does. ok is false when Athena doesn't know it.
+func columnInfoNullable(colInfo *athena.ColumnInfo) (nullable, ok bool) {
+	switch aws.StringValue(colInfo.Nullable) {
+	case athena.ColumnNullableNullable:
+		return true, true
+	case athena.ColumnNullableNotNull:
+		return false, true
+	}
+	return false, false
+}
+
+// columnName returns the name of the i-th column. Metadata may lack the name
+// of computed columns, for which the label or `_col<i>` is used as Athena does.
+func columnName(i int, name, label *string) string {
+	if name != nil && *name != "" {
+		return *name
+	}
+	if label != nil && *label != "" {
+		return *label
+	}
+	return fmt.Sprintf("_col%d", i)
+}
+
+// columnInfoTypeName returns the type of a result column. The length of
+// char and bounded varchar columns is kept, e.g. varchar(255), as table
+// metadata reports it.
+func columnInfoTypeName(colInfo *athena.ColumnInfo) string {
+	if colInfo.Type == nil {
+		return ""
+	}
+
+	columnType := *colInfo.Type
+	if colInfo.Precision == nil {
+		return columnType
+	}
+
+	switch precision := *colInfo.Precision; {
+	case columnType == "char",
+		columnType == "varchar" && precision > 0 && precision < math.MaxInt32:
+		return fmt.Sprintf("%s(%d)", columnType, precision)
+	}
+	return columnType
+}
@@ -1,8 +1,10 @@
 package athena
 
 import (
+	"context"
 	"database/sql/driver"
 	"io"
+	"reflect"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/athena"
@@ -14,25 +16,39 @@ type rowsAPI struct {
 	queryID    string
 	resultMode ResultMode
 
+	// ctx is that of the query, which the later pages are fetched with
+	ctx context.Context
+
 	// use only api mode
 	done          bool
 	skipHeaderRow bool
 	out           *athena.GetQueryResultsOutput
+	rawResponse   *rawResponse
+	columnInfos   *columnInfoCapture
+	retrier       retrier
+
+	convertOptions convertOptions
 }
 
-func newRowsAPI(cfg rowsConfig) (*rowsAPI, error) {
+func newRowsAPI(ctx context.Context, cfg rowsConfig) (*rowsAPI, error) {
 	r := &rowsAPI{
+		ctx:           ctx,
 		athena:        cfg.Athena,
 		queryID:       cfg.QueryID,
 		skipHeaderRow: cfg.SkipHeader,
 		resultMode:    cfg.ResultMode,
+		rawResponse:   cfg.RawResponse,
+		columnInfos:   cfg.ColumnInfos,
+		retrier:       cfg.Retrier,
+
+		convertOptions: cfg.ConvertOptions,
 	}
-	err := r.init(cfg)
+	err := r.init(ctx, cfg)
 	return r, err
 }
 
-func (r *rowsAPI) init(cfg rowsConfig) error {
-	shouldContinue, err := r.fetchNextPage(nil)
+func (r *rowsAPI) init(ctx context.Context, cfg rowsConfig) error {
+	shouldContinue, err := r.fetchNextPage(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -41,30 +57,38 @@ func (r *rowsAPI) init(cfg rowsConfig) error {
 	return nil
 }
 
-func (r *rowsAPI) fetchNextPage(token *string) (bool, error) {
-	var err error
-	r.out, err = r.athena.GetQueryResults(&athena.GetQueryResultsInput{
-		QueryExecutionId: aws.String(r.queryID),
-		NextToken:        token,
+func (r *rowsAPI) fetchNextPage(ctx context.Context, token *string) (bool, error) {
+	err := r.retrier.do(ctx, func() (err error) {
+		r.out, err = r.athena.GetQueryResultsWithContext(ctx, &athena.GetQueryResultsInput{
+			QueryExecutionId: aws.String(r.queryID),
+			NextToken:        token,
+		})
+		return err
 	})
 	if err != nil {
-		return false, err
+		return false, wrapAPIError(err)
+	}
+	// queries without a result, e.g. INSERT INTO, may come without rows or metadata
+	if r.out.ResultSet == nil {
+		r.out.ResultSet = &athena.ResultSet{}
 	}
+	if r.out.ResultSet.ResultSetMetadata == nil {
+		r.out.ResultSet.ResultSetMetadata = &athena.ResultSetMetadata{}
+	}
+	r.rawResponse.capture(r.out)
+	r.columnInfos.captureResultSet(r.out.ResultSet.ResultSetMetadata.ColumnInfo)
 
 	var rowOffset = 0
 	// First row of the first page contains header if the query is not DDL.
 	// These are also available in *athena.Row.ResultSetMetadata.
-	if r.skipHeaderRow {
+	if r.skipHeaderRow && len(r.out.ResultSet.Rows) > 0 {
 		rowOffset = 1
 		r.skipHeaderRow = false
 	}
-
-	if len(r.out.ResultSet.Rows) < rowOffset+1 {
-		return false, nil
-	}
-
 	r.out.ResultSet.Rows = r.out.ResultSet.Rows[rowOffset:]
-	return true, nil
+
+	// a page may be empty while the next one isn't
+	return len(r.out.ResultSet.Rows) > 0 || aws.StringValue(r.out.NextToken) != "", nil
 }
 
 func (r *rowsAPI) nextAPI(dest []driver.Value) error {
@@ -73,13 +97,13 @@ func (r *rowsAPI) nextAPI(dest []driver.Value) error {
 	}
 
 	// If nothing left to iterate...
-	if len(r.out.ResultSet.Rows) == 0 {
+	for len(r.out.ResultSet.Rows) == 0 {
 		// And if nothing more to paginate...
 		if r.out.NextToken == nil || *r.out.NextToken == "" {
 			return io.EOF
 		}
 
-		cont, err := r.fetchNextPage(r.out.NextToken)
+		cont, err := r.fetchNextPage(r.ctx, r.out.NextToken)
 		if err != nil {
 			return err
 		}
@@ -92,7 +116,7 @@ func (r *rowsAPI) nextAPI(dest []driver.Value) error {
 	// Shift to next row
 	cur := r.out.ResultSet.Rows[0]
 	columns := r.out.ResultSet.ResultSetMetadata.ColumnInfo
-	if err := convertRow(columns, cur.Data, dest); err != nil {
+	if err := r.convertOptions.convertRow(columns, cur.Data, dest); err != nil {
 		return err
 	}
 
@@ -102,25 +126,34 @@ func (r *rowsAPI) nextAPI(dest []driver.Value) error {
 
 func (r *rowsAPI) Columns() []string {
 	var columns []string
-	for _, colInfo := range r.out.ResultSet.ResultSetMetadata.ColumnInfo {
-		columns = append(columns, *colInfo.Name)
+	for i, colInfo := range r.out.ResultSet.ResultSetMetadata.ColumnInfo {
+		columns = append(columns, columnName(i, colInfo.Name, colInfo.Label))
 	}
 
 	return columns
 }
 
 func (r *rowsAPI) ColumnTypeDatabaseTypeName(index int) string {
-	colInfo := r.out.ResultSet.ResultSetMetadata.ColumnInfo[index]
-	if colInfo.Type != nil {
-		return *colInfo.Type
-	}
-	return ""
+	return columnInfoTypeName(r.out.ResultSet.ResultSetMetadata.ColumnInfo[index])
+}
+
+func (r *rowsAPI) ColumnTypeScanType(index int) reflect.Type {
+	return r.convertOptions.scanType(aws.StringValue(r.out.ResultSet.ResultSetMetadata.ColumnInfo[index].Type))
+}
+
+func (r *rowsAPI) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return columnInfoNullable(r.out.ResultSet.ResultSetMetadata.ColumnInfo[index])
 }
 
 func (r *rowsAPI) Next(dest []driver.Value) error {
 	return r.nextAPI(dest)
 }
 
+// QueryID implements QueryIDProvider.
+func (r *rowsAPI) QueryID() string {
+	return r.queryID
+}
+
 func (r *rowsAPI) Close() error {
 	r.done = true
 	return nil
@@ -6,15 +6,15 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"io"
+	"reflect"
 	"strings"
 	"time"
 	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
 )
 
 type rowsDL struct {
@@ -22,41 +22,71 @@ type rowsDL struct {
 	queryID        string
 	resultMode     ResultMode
 	out            *athena.GetQueryResultsOutput
-	downloadedRows *downloadedRows
+	rawResponse    *rawResponse
+	columnInfos    *columnInfoCapture
+	convertOptions convertOptions
+	retrier        retrier
+
+	// records are read from the body of the result CSV as rows are read,
+	// and cancel ends the download
+	records *csvRecordReader
+	body    io.Closer
+	cancel  context.CancelFunc
 }
 
-func newRowsDL(cfg rowsConfig) (*rowsDL, error) {
+func newRowsDL(ctx context.Context, cfg rowsConfig) (*rowsDL, error) {
 	r := &rowsDL{
-		athena:     cfg.Athena,
-		queryID:    cfg.QueryID,
-		resultMode: cfg.ResultMode,
+		athena:      cfg.Athena,
+		queryID:     cfg.QueryID,
+		resultMode:  cfg.ResultMode,
+		rawResponse: cfg.RawResponse,
+		columnInfos: cfg.ColumnInfos,
+
+		convertOptions: cfg.ConvertOptions,
+		retrier:        cfg.Retrier,
 	}
-	err := r.init(cfg)
+	err := r.init(ctx, cfg)
 	return r, err
 }
 
-func (r *rowsDL) init(cfg rowsConfig) error {
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
-	defer cancel()
+func (r *rowsDL) init(ctx context.Context, cfg rowsConfig) error {
+	// the download lasts until the rows are closed, since the result is
+	// streamed while they are read, so Timeout only limits opening it
+	ctx, r.cancel = context.WithCancel(ctx)
+	var timer *time.Timer
+	if cfg.Timeout > 0 {
+		timer = time.AfterFunc(time.Duration(cfg.Timeout)*time.Second, r.cancel)
+	}
 
-	err := make(chan error, 2)
+	errCh := make(chan error, 2)
 
-	// download and set in memory
-	go r.downloadCsvAsync(ctx, err, cfg.Session, cfg.OutputLocation)
+	// open the result
+	go r.downloadCsvAsync(ctx, errCh, cfg)
 
 	// get table metadata
-	go r.getQueryResultsAsyncForCsv(ctx, err)
+	go r.getQueryResultsAsyncForCsv(ctx, errCh)
 
+	// both requests are waited for, so that they are done with r on errors
+	var err error
 	for i := 0; i < 2; i++ {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case e := <-err:
-			if e != nil {
-				return e
-			}
+		e := <-errCh
+		if e == nil || err != nil {
+			continue
+		}
+		// report the end of ctx rather than how it interrupted a request
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			e = ctxErr
 		}
+		err = e
+		r.cancel()
+	}
+	if timer != nil && !timer.Stop() {
+		// the result wasn't opened within Timeout, and the download is cancelled
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		r.Close()
+		return err
 	}
 	return nil
 }
@@ -64,151 +94,359 @@ func (r *rowsDL) init(cfg rowsConfig) error {
 func (r *rowsDL) downloadCsvAsync(
 	ctx context.Context,
 	errCh chan error,
-	sess *session.Session,
-	location string,
+	cfg rowsConfig,
 ) {
-	errCh <- r.downloadCsv(sess, location)
+	errCh <- r.downloadCsv(ctx, cfg)
 }
 
-func (r *rowsDL) downloadCsv(sess *session.Session, location string) error {
-	// remove the first 5 characters "s3://" from location
-	bucketName := location[5:]
-	objectKey := fmt.Sprintf("%s.csv", r.queryID)
-
-	buff := &aws.WriteAtBuffer{}
-	downloader := s3manager.NewDownloader(sess)
-	_, err := downloader.Download(buff, &s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(objectKey),
-	})
+func (r *rowsDL) downloadCsv(ctx context.Context, cfg rowsConfig) error {
+	bucketName, objectKey, err := csvResultObject(cfg)
 	if err != nil {
 		return err
 	}
 
-	bfData := buff.Bytes()
-
-	fields, err := getRecordsForDL(strings.NewReader(string(bfData)))
+	body, err := openResumableObject(ctx, cfg.S3, bucketName, objectKey)
 	if err != nil {
 		return err
 	}
-	r.downloadedRows = &downloadedRows{
-		field: fields[1:],
+	r.body = body
+
+	result, err := decompressResult(&countingReader{reader: body, downloaded: cfg.DownloadedBytes}, objectKey, cfg.ResultCompression)
+	if err != nil {
+		return err
 	}
 
+	r.records = newCSVRecordReader(decodeResult(result, cfg.ResultEncoding), csvOptions{
+		trimSpaceAsNull: cfg.TrimSpaceAsNull,
+		delimiter:       cfg.CSVDelimiter,
+		quote:           cfg.CSVQuote,
+	})
+
+	// the header is missing from the empty result of a query without columns
+	if _, err := r.records.next(); err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
 
+const resultKeyQueryIDPlaceholder = "{queryID}"
+
+// csvResultObject returns the bucket and key of the result CSV of a query.
+// The result location reported by Athena is preferred over building the key
+// from the output location.
+func csvResultObject(cfg rowsConfig) (string, string, error) {
+	if cfg.ResultLocation != "" {
+		return parseS3URL(cfg.ResultLocation)
+	}
+
+	bucket, prefix, err := parseS3URL(cfg.OutputLocation)
+	if err != nil {
+		return "", "", err
+	}
+
+	keyTemplate := cfg.ResultKeyTemplate
+	if keyTemplate == "" {
+		keyTemplate = resultKeyQueryIDPlaceholder + ".csv"
+	}
+	key := strings.Replace(keyTemplate, resultKeyQueryIDPlaceholder, cfg.QueryID, -1)
+	if prefix != "" {
+		key = strings.TrimSuffix(prefix, "/") + "/" + key
+	}
+
+	return bucket, key, nil
+}
+
+// parseS3URL splits "s3://bucket/key" into its bucket and key.
+func parseS3URL(location string) (string, string, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		return "", "", fmt.Errorf("invalid s3 location: %s", location)
+	}
+
+	path := location[len("s3://"):]
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		return path[:i], path[i+1:], nil
+	}
+	return path, "", nil
+}
+
 func (r *rowsDL) getQueryResultsAsyncForCsv(ctx context.Context, errCh chan error) {
-	var err error
-	r.out, err = r.athena.GetQueryResults(&athena.GetQueryResultsInput{
-		QueryExecutionId: aws.String(r.queryID),
-		MaxResults:       aws.Int64(1),
+	err := r.retrier.do(ctx, func() (err error) {
+		r.out, err = r.athena.GetQueryResultsWithContext(ctx, &athena.GetQueryResultsInput{
+			QueryExecutionId: aws.String(r.queryID),
+			MaxResults:       aws.Int64(1),
+		})
+		return err
 	})
-	errCh <- err
+	if err != nil {
+		errCh <- wrapAPIError(err)
+		return
+	}
+	if r.out.ResultSet == nil {
+		r.out.ResultSet = &athena.ResultSet{}
+	}
+	if r.out.ResultSet.ResultSetMetadata == nil {
+		r.out.ResultSet.ResultSetMetadata = &athena.ResultSetMetadata{}
+	}
+
+	r.rawResponse.capture(r.out)
+	r.columnInfos.captureResultSet(r.out.ResultSet.ResultSetMetadata.ColumnInfo)
+	errCh <- nil
 }
 
 func (r *rowsDL) nextDownload(dest []driver.Value) error {
-	if r.downloadedRows.cursor >= len(r.downloadedRows.field) {
-		return io.EOF
-	}
-	row := r.downloadedRows.field[r.downloadedRows.cursor]
-	columns := r.out.ResultSet.ResultSetMetadata.ColumnInfo
-	if err := convertRowFromCsv(columns, row, dest); err != nil {
+	row, err := r.records.next()
+	if err != nil {
 		return err
 	}
 
-	r.downloadedRows.cursor++
-	return nil
+	columns := r.out.ResultSet.ResultSetMetadata.ColumnInfo
+	return r.convertOptions.convertRowFromCsv(columns, row, dest)
 }
 
 func (r *rowsDL) Columns() []string {
 	var columns []string
-	for _, colInfo := range r.out.ResultSet.ResultSetMetadata.ColumnInfo {
-		columns = append(columns, *colInfo.Name)
+	for i, colInfo := range r.out.ResultSet.ResultSetMetadata.ColumnInfo {
+		columns = append(columns, columnName(i, colInfo.Name, colInfo.Label))
 	}
 
 	return columns
 }
 
 func (r *rowsDL) ColumnTypeDatabaseTypeName(index int) string {
-	colInfo := r.out.ResultSet.ResultSetMetadata.ColumnInfo[index]
-	if colInfo.Type != nil {
-		return *colInfo.Type
-	}
-	return ""
+	return columnInfoTypeName(r.out.ResultSet.ResultSetMetadata.ColumnInfo[index])
+}
+
+func (r *rowsDL) ColumnTypeScanType(index int) reflect.Type {
+	return r.convertOptions.scanType(aws.StringValue(r.out.ResultSet.ResultSetMetadata.ColumnInfo[index].Type))
+}
+
+func (r *rowsDL) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return columnInfoNullable(r.out.ResultSet.ResultSetMetadata.ColumnInfo[index])
 }
 
 func (r *rowsDL) Next(dest []driver.Value) error {
 	return r.nextDownload(dest)
 }
 
+// QueryID implements QueryIDProvider.
+func (r *rowsDL) QueryID() string {
+	return r.queryID
+}
+
+// Close ends the download of the result.
 func (r *rowsDL) Close() error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	if r.body != nil {
+		r.body.Close()
+	}
 	return nil
 }
 
-func getRecordsForDL(reader io.Reader) ([][]downloadField, error) {
-	records := make([][]downloadField, 0)
+// decodeResult converts a result written in enc to UTF-8.
+// reader is returned as is when enc is nil.
+func decodeResult(reader io.Reader, enc encoding.Encoding) io.Reader {
+	if enc == nil {
+		return reader
+	}
+	return enc.NewDecoder().Reader(reader)
+}
+
+// csvOptions are the options of parsing the result CSV in DL mode.
+type csvOptions struct {
+	// trimSpaceAsNull makes unquoted fields of only whitespace NULL
+	trimSpaceAsNull bool
+
+	// delimiter and quote default to ',' and '"' when they are 0
+	delimiter rune
+	quote     rune
+}
+
+func (opts csvOptions) delimiterRune() rune {
+	if opts.delimiter == 0 {
+		return ','
+	}
+	return opts.delimiter
+}
+
+func (opts csvOptions) quoteRune() rune {
+	if opts.quote == 0 {
+		return '"'
+	}
+	return opts.quote
+}
+
+// isQuoted reports whether field starting with a quote is closed, i.e. it ends
+// with a quote which isn't one of the doubled quotes escaping a quote.
+func (opts csvOptions) isQuoted(field string) bool {
+	quote := string(opts.quoteRune())
+	return len(field) >= 2*len(quote) && strings.HasSuffix(field, quote) && strings.Count(field, quote)%2 == 0
+}
+
+// unquote removes the quotes around a field and unescapes the quotes in it,
+// which are doubled.
+func (opts csvOptions) unquote(field string) string {
+	quote := string(opts.quoteRune())
+	return strings.Replace(field[len(quote):len(field)-len(quote)], quote+quote, quote, -1)
+}
+
+// isNullField reports whether an unquoted field is NULL.
+func (opts csvOptions) isNullField(field string) bool {
+	if opts.trimSpaceAsNull {
+		return strings.TrimSpace(field) == ""
+	}
+	return len(field) == 0
+}
+
+// csvRecordReader reads the result CSV in DL mode one record at a time, so
+// that only the current record of the result is held in memory. A record
+// spans several lines when a quoted field contains newlines.
+type csvRecordReader struct {
+	scanner *bufio.Scanner
+	opts    csvOptions
+}
 
-	scanner := bufio.NewScanner(reader)
+func newCSVRecordReader(reader io.Reader, opts csvOptions) *csvRecordReader {
+	return &csvRecordReader{scanner: bufio.NewScanner(reader), opts: opts}
+}
 
-	// read line by line
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
+// next returns the next record, or io.EOF after the last one.
+func (r *csvRecordReader) next() ([]downloadField, error) {
+	if !r.scanner.Scan() {
+		// e.g. corrupt compressed results
+		if err := r.scanner.Err(); err != nil {
 			return nil, err
 		}
-		b := scanner.Bytes()
-		useDoubleQuote := false
-		delimiter := false
-		field := ""
-		record := make([]downloadField, 0)
-		for {
-			r, width := utf8.DecodeRune(b)
-			if len(field) == 0 {
-				useDoubleQuote = r == '"'
-			}
+		return nil, io.EOF
+	}
 
-			if r == ',' {
-				delimiter = true
-				if useDoubleQuote {
-					delimiter = false
-					if len(field) > 0 && field[len(field)-1:] == string('"') {
-						field = field[1 : len(field)-1]
-						delimiter = true
-					}
-				}
+	// an empty line is a single unquoted empty field, e.g. the NULL of a
+	// result with one column, or a row of a result without columns, whose
+	// field is ignored when the row is converted
+	if len(r.scanner.Bytes()) == 0 {
+		return []downloadField{{isNil: true}}, nil
+	}
+
+	state := r.opts.scanQuotes(quoteStateFieldStart, r.scanner.Bytes())
+	if state != quoteStateQuoted {
+		return r.opts.parseRecord(r.scanner.Bytes()), nil
+	}
+
+	// the newline is in a quoted field, which continues on the next line
+	record := append([]byte(nil), r.scanner.Bytes()...)
+	for state == quoteStateQuoted && r.scanner.Scan() {
+		record = append(append(record, '\n'), r.scanner.Bytes()...)
+		state = r.opts.scanQuotes(state, r.scanner.Bytes())
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r.opts.parseRecord(record), nil
+}
+
+// quoteState is where a line of the result CSV ends relative to the quotes.
+type quoteState int
+
+const (
+	quoteStateFieldStart quoteState = iota
+	quoteStateUnquoted
+	quoteStateQuoted
+	// quoteStateClosed is after a quote in a quoted field, which either
+	// closes the field or escapes a quote following it
+	quoteStateClosed
+)
+
+// scanQuotes returns the quoteState after line, which starts in state.
+func (opts csvOptions) scanQuotes(state quoteState, line []byte) quoteState {
+	delimiter, quote := opts.delimiterRune(), opts.quoteRune()
+	for len(line) > 0 {
+		r, width := utf8.DecodeRune(line)
+		line = line[width:]
+
+		switch state {
+		case quoteStateFieldStart:
+			switch r {
+			case quote:
+				state = quoteStateQuoted
+			case delimiter:
+			default:
+				state = quoteStateUnquoted
+			}
+		case quoteStateUnquoted:
+			if r == delimiter {
+				state = quoteStateFieldStart
+			}
+		case quoteStateQuoted:
+			if r == quote {
+				state = quoteStateClosed
+			}
+		case quoteStateClosed:
+			switch r {
+			case quote:
+				state = quoteStateQuoted
+			case delimiter:
+				state = quoteStateFieldStart
+			default:
+				state = quoteStateUnquoted
 			}
+		}
+	}
+	return state
+}
 
-			if delimiter {
-				isNil := !useDoubleQuote && len(field) == 0
-				row := downloadField{
-					isNil: isNil,
-					val:   field,
-				}
-				record = append(record, row)
-				field = ""
+// parseRecord splits a line of the result CSV into its fields.
+func (opts csvOptions) parseRecord(b []byte) []downloadField {
+	delimiterRune, quoteRune := opts.delimiterRune(), opts.quoteRune()
+	useDoubleQuote := false
+	delimiter := false
+	field := ""
+	record := make([]downloadField, 0)
+	for {
+		r, width := utf8.DecodeRune(b)
+		if len(field) == 0 {
+			useDoubleQuote = r == quoteRune
+		}
+
+		if r == delimiterRune {
+			delimiter = true
+			if useDoubleQuote {
 				delimiter = false
-			} else {
-				field += string(r)
-			}
-			if width >= len(b) {
-				if useDoubleQuote {
-					if len(field) > 0 && field[len(field)-1:] == string('"') {
-						field = field[1 : len(field)-1]
-					}
+				if opts.isQuoted(field) {
+					field = opts.unquote(field)
+					delimiter = true
 				}
-				isNil := !useDoubleQuote && len(field) == 0
-				row := downloadField{
-					isNil: isNil,
-					val:   field,
-				}
-				record = append(record, row)
-				break
 			}
-			b = b[width:]
 		}
 
-		records = append(records, record)
+		if delimiter {
+			isNil := !useDoubleQuote && opts.isNullField(field)
+			row := downloadField{
+				isNil: isNil,
+				val:   field,
+			}
+			record = append(record, row)
+			field = ""
+			delimiter = false
+			// a delimiter ending the line is followed by an empty unquoted field
+			useDoubleQuote = false
+		} else {
+			field += string(r)
+		}
+		if width >= len(b) {
+			if useDoubleQuote && opts.isQuoted(field) {
+				field = opts.unquote(field)
+			}
+			isNil := !useDoubleQuote && opts.isNullField(field)
+			row := downloadField{
+				isNil: isNil,
+				val:   field,
+			}
+			record = append(record, row)
+			break
+		}
+		b = b[width:]
 	}
 
-	return records, nil
+	return record
 }
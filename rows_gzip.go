@@ -7,13 +7,12 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"io"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 )
@@ -22,6 +21,10 @@ const (
 	CATALOG_AWS_DATA_CATALOG string = "AwsDataCatalog"
 )
 
+// downloadConcurrencyDefault is how many objects of a CTAS table are
+// downloaded at once by default.
+const downloadConcurrencyDefault = 4
+
 type rowsGzipDL struct {
 	athena     athenaiface.AthenaAPI
 	queryID    string
@@ -35,9 +38,16 @@ type rowsGzipDL struct {
 	db               string
 	catalog          string
 	ctasTableColumns []*athena.Column
+	columnInfos      *columnInfoCapture
+	convertOptions   convertOptions
+	retrier          retrier
+
+	// describeCTASTable reads the ctas table columns without GetTableMetadata
+	describeCTASTable func() ([]*athena.Column, error)
+	logf              func(format string, v ...interface{})
 }
 
-func newRowsGzipDL(cfg rowsConfig) (*rowsGzipDL, error) {
+func newRowsGzipDL(ctx context.Context, cfg rowsConfig) (*rowsGzipDL, error) {
 	r := &rowsGzipDL{
 		athena:     cfg.Athena,
 		queryID:    cfg.QueryID,
@@ -45,20 +55,29 @@ func newRowsGzipDL(cfg rowsConfig) (*rowsGzipDL, error) {
 		ctasTable:  cfg.CTASTable,
 		db:         cfg.DB,
 		catalog:    cfg.Catalog,
+
+		columnInfos:    cfg.ColumnInfos,
+		convertOptions: cfg.ConvertOptions,
+		retrier:        cfg.Retrier,
+
+		describeCTASTable: cfg.DescribeCTASTable,
+		logf:              cfg.Logf,
 	}
-	err := r.init(cfg)
+	err := r.init(ctx, cfg)
 	return r, err
 }
 
-func (r *rowsGzipDL) init(cfg rowsConfig) error {
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
+func (r *rowsGzipDL) init(ctx context.Context, cfg rowsConfig) error {
+	cancel := context.CancelFunc(func() {})
+	if cfg.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
+	}
 	defer cancel()
 
 	err := make(chan error, 2)
 
 	// download and set in memory
-	go r.downloadCompressedDataAsync(ctx, err, cfg.Session, cfg.OutputLocation)
+	go r.downloadCompressedDataAsync(ctx, err, cfg)
 
 	// get table metadata
 	go r.getTableAsync(ctx, err)
@@ -87,80 +106,152 @@ func (r *rowsGzipDL) init(cfg rowsConfig) error {
 func (r *rowsGzipDL) downloadCompressedDataAsync(
 	ctx context.Context,
 	errCh chan error,
-	sess *session.Session,
-	location string,
+	cfg rowsConfig,
 ) {
-	errCh <- r.downloadCompressedData(sess, location)
+	errCh <- r.downloadCompressedData(ctx, cfg)
 }
 
-func (r *rowsGzipDL) downloadCompressedData(sess *session.Session, location string) error {
-	// remove the first 5 characters "s3://" from location
-	bucketName := location[5:]
+func (r *rowsGzipDL) downloadCompressedData(ctx context.Context, cfg rowsConfig) error {
+	bucketName, prefix, err := parseS3URL(cfg.OutputLocation)
+	if err != nil {
+		return err
+	}
+	manifestKey := fmt.Sprintf("tables/%s-manifest.csv", r.queryID)
+	if prefix != "" {
+		manifestKey = strings.TrimSuffix(prefix, "/") + "/" + manifestKey
+	}
 
 	// get gz file path
-	buff := &aws.WriteAtBuffer{}
+	manifest, err := downloadObject(ctx, cfg.S3, bucketName, manifestKey)
+	if err != nil {
+		return err
+	}
+	cfg.DownloadedBytes.add(len(manifest))
 
-	downloader := s3manager.NewDownloader(sess)
-	_, err := downloader.Download(buff, &s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(fmt.Sprintf("tables/%s-manifest.csv", r.queryID)),
-	})
+	start := len("s3://"+bucketName) + 1 // the path is "s3://bucket/objectKey"
+	objectKeys, err := getObjectKeysForGzip(strings.NewReader(string(manifest)), start)
 	if err != nil {
 		return err
 	}
 
-	start := len(location) + 1 // the path is "location/objectKey"
-	objectKeys, err := getObjectKeysForGzip(strings.NewReader(string(buff.Bytes())), start)
+	objects, err := downloadGzipObjects(ctx, cfg, bucketName, objectKeys)
 	if err != nil {
 		return err
 	}
 
-	for _, objectKey := range objectKeys {
-		buff := &aws.WriteAtBuffer{}
+	// the rows are in the order of the manifest
+	var n int
+	for _, datas := range objects {
+		n += len(datas)
+	}
+	r.downloadedRows = &downloadedRows{data: make([][]string, 0, n)}
+	for _, datas := range objects {
+		r.downloadedRows.data = append(r.downloadedRows.data, datas...)
+	}
 
-		_, err := downloader.Download(buff, &s3.GetObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(objectKey),
-		})
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		bfData := buff.Bytes()
+// downloadGzipObjects downloads and reads the objects of a CTAS table
+// concurrently, up to cfg.DownloadConcurrency at once, and returns their
+// records in the order of objectKeys. The first error cancels the rest.
+func downloadGzipObjects(ctx context.Context, cfg rowsConfig, bucketName string, objectKeys []string) ([][][]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		// decompress gzip
-		gzipReader, err := gzip.NewReader(strings.NewReader(string(bfData)))
-		if err != nil {
-			return err
+	concurrency := cfg.DownloadConcurrency
+	if concurrency <= 0 {
+		concurrency = downloadConcurrencyDefault
+	}
+	slots := make(chan struct{}, concurrency)
+
+	objects := make([][][]string, len(objectKeys))
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i, objectKey := range objectKeys {
+		slots <- struct{}{}
+		if err := ctx.Err(); err != nil {
+			<-slots
+			once.Do(func() { firstErr = err })
+			break
 		}
 
-		datas, err := getRecordsFromGzip(gzipReader)
-		if err != nil {
-			return err
-		}
-		if r.downloadedRows == nil {
-			r.downloadedRows = &downloadedRows{
-				data: make([][]string, 0, len(datas)*len(objectKeys)),
+		wg.Add(1)
+		go func(i int, objectKey string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			datas, err := downloadGzipObject(ctx, cfg, bucketName, objectKey)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
 			}
-		}
-		r.downloadedRows.data = append(r.downloadedRows.data, datas...)
+			objects[i] = datas
+		}(i, objectKey)
 	}
+	wg.Wait()
 
-	return nil
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return objects, nil
+}
+
+func downloadGzipObject(ctx context.Context, cfg rowsConfig, bucketName, objectKey string) ([][]string, error) {
+	bfData, err := downloadObject(ctx, cfg.S3, bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	cfg.DownloadedBytes.add(len(bfData))
+
+	// empty partitions may be written as zero-byte files, which aren't valid gzip
+	if len(bfData) == 0 {
+		return nil, nil
+	}
+
+	// decompress gzip
+	gzipReader, err := gzip.NewReader(strings.NewReader(string(bfData)))
+	if err != nil {
+		return nil, err
+	}
+
+	return getRecordsFromGzip(gzipReader)
 }
 
 func (r *rowsGzipDL) getTableAsync(ctx context.Context, errCh chan error) {
-	data, err := r.athena.GetTableMetadata(&athena.GetTableMetadataInput{
-		CatalogName:  aws.String(r.catalog),
-		DatabaseName: aws.String(r.db),
-		TableName:    aws.String(r.ctasTable),
+	var data *athena.GetTableMetadataOutput
+	err := r.retrier.do(ctx, func() (err error) {
+		data, err = r.athena.GetTableMetadataWithContext(ctx, &athena.GetTableMetadataInput{
+			CatalogName:  aws.String(r.catalog),
+			DatabaseName: aws.String(r.db),
+			TableName:    aws.String(r.ctasTable),
+		})
+		return err
 	})
 	if err != nil {
-		errCh <- err
-		return
+		if !isAccessDenied(err) || r.describeCTASTable == nil {
+			errCh <- wrapAPIError(err)
+			return
+		}
+
+		// fall back to the result metadata of an empty SELECT
+		r.logf("GetTableMetadata is not permitted, reading columns of %s from a query: %v", r.ctasTable, err)
+		r.ctasTableColumns, err = r.describeCTASTable()
+		if err != nil {
+			errCh <- err
+			return
+		}
+	} else {
+		// the columns are declared in the order of the SELECT, which the data
+		// files are written in, so they must be kept in this order
+		r.ctasTableColumns = data.TableMetadata.Columns
 	}
 
-	r.ctasTableColumns = data.TableMetadata.Columns
+	r.columnInfos.captureTable(r.ctasTableColumns)
 	errCh <- nil
 }
 
@@ -170,7 +261,7 @@ func (r *rowsGzipDL) nextCTAS(dest []driver.Value) error {
 	}
 
 	row := r.downloadedRows.data[r.downloadedRows.cursor]
-	if err := convertRowFromTableInfo(r.ctasTableColumns, row, dest); err != nil {
+	if err := r.convertOptions.convertRowFromTableInfo(r.ctasTableColumns, row, dest); err != nil {
 		return err
 	}
 
@@ -189,8 +280,8 @@ func (r *rowsGzipDL) columnTypeDatabaseTypeNameForCTAS(index int) string {
 func (r *rowsGzipDL) Columns() []string {
 	var columns []string
 
-	for _, col := range r.ctasTableColumns {
-		columns = append(columns, *col.Name)
+	for i, col := range r.ctasTableColumns {
+		columns = append(columns, columnName(i, col.Name, nil))
 	}
 
 	return columns
@@ -200,10 +291,25 @@ func (r *rowsGzipDL) ColumnTypeDatabaseTypeName(index int) string {
 	return r.columnTypeDatabaseTypeNameForCTAS(index)
 }
 
+func (r *rowsGzipDL) ColumnTypeScanType(index int) reflect.Type {
+	return r.convertOptions.scanType(r.columnTypeDatabaseTypeNameForCTAS(index))
+}
+
+// ColumnTypeNullable isn't known for the columns of the CTAS table, whose
+// metadata has no nullability.
+func (r *rowsGzipDL) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return false, false
+}
+
 func (r *rowsGzipDL) Next(dest []driver.Value) error {
 	return r.nextCTAS(dest)
 }
 
+// QueryID implements QueryIDProvider.
+func (r *rowsGzipDL) QueryID() string {
+	return r.queryID
+}
+
 func (r *rowsGzipDL) Close() error {
 	return nil
 }
@@ -211,19 +317,19 @@ func (r *rowsGzipDL) Close() error {
 func getObjectKeysForGzip(reader io.Reader, start int) ([]string, error) {
 
 	keys := make([]string, 0)
-	scanner := bufio.NewScanner(reader)
+	scanner := newGzipLineScanner(reader)
 
 	// read line by line
 	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
 		k := scanner.Text()
 		if start > 0 && len(k) > start {
 			k = k[start:]
 		}
 		keys = append(keys, k)
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
 	return keys, nil
 }
@@ -231,26 +337,28 @@ func getObjectKeysForGzip(reader io.Reader, start int) ([]string, error) {
 func getRecordsFromGzip(reader io.Reader) ([][]string, error) {
 	records := make([][]string, 0)
 
-	scanner := bufio.NewScanner(reader)
+	scanner := newGzipLineScanner(reader)
 
-	// read line by line
+	// read line by line, every line is a row since TEXTFILE has no header
 	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
 		b := scanner.Bytes()
-		field := ""
+		if len(b) == 0 {
+			// a single empty field, as in csvRecordReader.next
+			records = append(records, []string{""})
+			continue
+		}
+		var field strings.Builder
 		record := make([]string, 0)
 		for {
 			r, width := utf8.DecodeRune(b)
 			if r == '\001' {
-				record = append(record, field)
-				field = ""
+				record = append(record, field.String())
+				field.Reset()
 			} else {
-				field += string(r)
+				field.WriteRune(r)
 			}
 			if width >= len(b) {
-				record = append(record, field)
+				record = append(record, field.String())
 				break
 			}
 			b = b[width:]
@@ -258,6 +366,21 @@ func getRecordsFromGzip(reader io.Reader) ([][]string, error) {
 
 		records = append(records, record)
 	}
+	// a line too long or an object cut short stops Scan with an error
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
 	return records, nil
 }
+
+// gzipMaxLineSize is the longest line read from the manifest and the
+// objects of GZIP DL mode. Every row of a TEXTFILE table is a line, so rows
+// of long strings or large collections easily exceed bufio.MaxScanTokenSize.
+const gzipMaxLineSize = 16 << 20
+
+func newGzipLineScanner(reader io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), gzipMaxLineSize)
+	return scanner
+}
@@ -1,16 +1,34 @@
 package athena
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
 	"math/rand"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/charmap"
 )
 
 var dummyError = errors.New("dummy error")
@@ -22,6 +40,9 @@ var queryToResultsGenMap = map[string]genQueryResultsOutputByToken{
 	"select_zero":    dummySelectZeroQueryResponse,
 	"show":           dummyShowResponse,
 	"iteration_fail": dummyFailedIterationResponse,
+	"metadata_only":  dummyMetadataOnlyResponse,
+	"no_result_set":  dummyNoResultSetResponse,
+	"header_page":    dummyHeaderPageResponse,
 }
 
 func genColumnInfo(column string) *athena.ColumnInfo {
@@ -190,11 +211,61 @@ func dummyFailedIterationResponse(token string) (*athena.GetQueryResultsOutput,
 	}
 }
 
+func dummyMetadataOnlyResponse(_ string) (*athena.GetQueryResultsOutput, error) {
+	return &athena.GetQueryResultsOutput{
+		ResultSet: &athena.ResultSet{
+			ResultSetMetadata: &athena.ResultSetMetadata{
+				ColumnInfo: []*athena.ColumnInfo{genColumnInfo("rows")},
+			},
+		},
+	}, nil
+}
+
+func dummyNoResultSetResponse(_ string) (*athena.GetQueryResultsOutput, error) {
+	return &athena.GetQueryResultsOutput{}, nil
+}
+
+func dummyHeaderPageResponse(token string) (*athena.GetQueryResultsOutput, error) {
+	columns := []*athena.ColumnInfo{
+		genColumnInfo("first_name"),
+		genColumnInfo("last_name"),
+	}
+	switch token {
+	case "":
+		var nextToken = "page_1"
+		return &athena.GetQueryResultsOutput{
+			NextToken: &nextToken,
+			ResultSet: &athena.ResultSet{
+				ResultSetMetadata: &athena.ResultSetMetadata{
+					ColumnInfo: columns,
+				},
+				Rows: []*athena.Row{
+					genRow(true, columns),
+				},
+			},
+		}, nil
+	case "page_1":
+		return &athena.GetQueryResultsOutput{
+			ResultSet: &athena.ResultSet{
+				ResultSetMetadata: &athena.ResultSetMetadata{
+					ColumnInfo: columns,
+				},
+				Rows: []*athena.Row{
+					genRow(false, columns),
+					genRow(false, columns),
+				},
+			},
+		}, nil
+	default:
+		return nil, dummyError
+	}
+}
+
 type mockAthenaClient struct {
 	athenaiface.AthenaAPI
 }
 
-func (m *mockAthenaClient) GetQueryResults(query *athena.GetQueryResultsInput) (*athena.GetQueryResultsOutput, error) {
+func (m *mockAthenaClient) GetQueryResultsWithContext(_ aws.Context, query *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
 	var nextToken = ""
 	if query.NextToken != nil {
 		nextToken = *query.NextToken
@@ -235,6 +306,27 @@ func TestRows_Next(t *testing.T) {
 			expectedResultsSize: 9,
 			expectedError:       nil,
 		},
+		{
+			desc:                "insert query, header skipped, metadata only, no error",
+			queryID:             "metadata_only",
+			skipHeader:          true,
+			expectedResultsSize: 0,
+			expectedError:       nil,
+		},
+		{
+			desc:                "insert query, header skipped, no result set, no error",
+			queryID:             "no_result_set",
+			skipHeader:          true,
+			expectedResultsSize: 0,
+			expectedError:       nil,
+		},
+		{
+			desc:                "select query, header alone on the first page, 2 rows, no error",
+			queryID:             "header_page",
+			skipHeader:          true,
+			expectedResultsSize: 2,
+			expectedError:       nil,
+		},
 		{
 			desc:          "failed during calling next",
 			queryID:       "iteration_fail",
@@ -243,11 +335,12 @@ func TestRows_Next(t *testing.T) {
 		},
 	}
 	for _, test := range tests {
-		r, _ := newRows(rowsConfig{
+		r, err := newRows(context.Background(), rowsConfig{
 			Athena:     new(mockAthenaClient),
 			QueryID:    test.queryID,
 			SkipHeader: test.skipHeader,
 		})
+		require.NoError(t, err, test.desc)
 
 		var firstName, lastName string
 		cnt := 0
@@ -267,6 +360,26 @@ func TestRows_Next(t *testing.T) {
 	}
 }
 
+// getRecordsForDL reads every record of a result CSV.
+func getRecordsForDL(reader io.Reader, opts csvOptions) ([][]downloadField, error) {
+	return readRecords(newCSVRecordReader(reader, opts))
+}
+
+// readRecords reads the records left in records.
+func readRecords(records *csvRecordReader) ([][]downloadField, error) {
+	ret := make([][]downloadField, 0)
+	for {
+		record, err := records.next()
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, record)
+	}
+}
+
 func Test_getRecordsForDL(t *testing.T) {
 
 	tests := []struct {
@@ -275,6 +388,11 @@ func Test_getRecordsForDL(t *testing.T) {
 		want    [][]downloadField
 		wantErr bool
 	}{
+		{
+			name:  "empty lines of a single column",
+			param: "\"a\"\n\n\"\"\n",
+			want:  [][]downloadField{{{val: "a"}}, {{isNil: true}}, {{val: ""}}},
+		},
 		{
 			name:  "test",
 			param: ",\"1\"\n\"\",\"9\"\n\"hoge, hoge\",\"10\"",
@@ -307,10 +425,24 @@ func Test_getRecordsForDL(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:  "escaped quotes",
+			param: "\"{\"\"a\"\":\"\"b\"\"}\",\"\"\"\"\"\"",
+			want: [][]downloadField{
+				{
+					{
+						val: `{"a":"b"}`,
+					},
+					{
+						val: `""`,
+					},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := getRecordsForDL(strings.NewReader(tt.param))
+			got, err := getRecordsForDL(strings.NewReader(tt.param), csvOptions{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getRecordsForDL() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -326,3 +458,1047 @@ func Test_getRecordsForDL(t *testing.T) {
 		})
 	}
 }
+
+func Test_csvResultObject(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        rowsConfig
+		wantBucket string
+		wantKey    string
+		wantErr    bool
+	}{
+		{
+			name:       "default key",
+			cfg:        rowsConfig{QueryID: "abc", OutputLocation: "s3://bucket"},
+			wantBucket: "bucket",
+			wantKey:    "abc.csv",
+		},
+		{
+			name:       "default key with prefix",
+			cfg:        rowsConfig{QueryID: "abc", OutputLocation: "s3://bucket/results/"},
+			wantBucket: "bucket",
+			wantKey:    "results/abc.csv",
+		},
+		{
+			name: "custom template",
+			cfg: rowsConfig{
+				QueryID:           "abc",
+				OutputLocation:    "s3://bucket/results",
+				ResultKeyTemplate: "owner/{queryID}/result.csv",
+			},
+			wantBucket: "bucket",
+			wantKey:    "results/owner/abc/result.csv",
+		},
+		{
+			name: "result location from athena",
+			cfg: rowsConfig{
+				QueryID:           "abc",
+				OutputLocation:    "s3://bucket/results",
+				ResultKeyTemplate: "owner/{queryID}/result.csv",
+				ResultLocation:    "s3://other-bucket/wg/abc.csv",
+			},
+			wantBucket: "other-bucket",
+			wantKey:    "wg/abc.csv",
+		},
+		{
+			name:    "invalid location",
+			cfg:     rowsConfig{QueryID: "abc", OutputLocation: "bucket/results"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, key, err := csvResultObject(tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantBucket, bucket)
+			assert.Equal(t, tt.wantKey, key)
+		})
+	}
+}
+
+func Test_columnInfoTypeName(t *testing.T) {
+	colInfo := func(columnType string, precision int64) *athena.ColumnInfo {
+		c := genColumnInfo("col")
+		c.Type = &columnType
+		c.Precision = &precision
+		return c
+	}
+
+	tests := []struct {
+		colInfo *athena.ColumnInfo
+		want    string
+	}{
+		{colInfo: colInfo("varchar", 2147483647), want: "varchar"},
+		{colInfo: colInfo("varchar", 255), want: "varchar(255)"},
+		{colInfo: colInfo("char", 10), want: "char(10)"},
+		{colInfo: colInfo("integer", 10), want: "integer"},
+		{colInfo: colInfo("decimal", 11), want: "decimal"},
+		{colInfo: &athena.ColumnInfo{}, want: ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, columnInfoTypeName(tt.colInfo))
+	}
+}
+
+func TestRows_ColumnTypeDatabaseTypeName_length(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults([]*athena.ColumnInfo{genColumnInfo("name")}),
+	}
+	client.results.ResultSet.ResultSetMetadata.ColumnInfo[0].Precision = aws.Int64(255)
+
+	r, err := newRows(context.Background(), rowsConfig{Athena: client, QueryID: "varchar", SkipHeader: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "varchar(255)", r.(driver.RowsColumnTypeDatabaseTypeName).ColumnTypeDatabaseTypeName(0))
+}
+
+func TestRows_columnInfos(t *testing.T) {
+	columns := []*athena.ColumnInfo{genColumnInfo("first_name"), genColumnInfo("last_name")}
+	columns[1].TableName = aws.String("users")
+	client := &mockAthenaConnClient{results: genResults(columns)}
+	wantResultSet := []ColumnInfo{
+		{
+			Name: "first_name", Label: "first_name", Type: "varchar", CatalogName: "hive",
+			Precision: 2147483647, Nullable: "UNKNOWN", CaseSensitive: true,
+		},
+		{
+			Name: "last_name", Label: "last_name", Type: "varchar", CatalogName: "hive", TableName: "users",
+			Precision: 2147483647, Nullable: "UNKNOWN", CaseSensitive: true,
+		},
+	}
+
+	t.Run("api", func(t *testing.T) {
+		capture := &columnInfoCapture{}
+		_, err := newRowsAPI(context.Background(), rowsConfig{Athena: client, QueryID: "api", SkipHeader: true, ColumnInfos: capture})
+		require.NoError(t, err)
+		assert.Equal(t, wantResultSet, capture.infos)
+	})
+
+	t.Run("dl", func(t *testing.T) {
+		capture := &columnInfoCapture{}
+		r := &rowsDL{athena: client, queryID: "dl", columnInfos: capture}
+		errCh := make(chan error, 1)
+		r.getQueryResultsAsyncForCsv(context.Background(), errCh)
+		require.NoError(t, <-errCh)
+		assert.Equal(t, wantResultSet, capture.infos)
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		capture := &columnInfoCapture{}
+		r := &rowsGzipDL{athena: new(mockAthenaSchemaClient), ctasTable: "tmp_ctas", columnInfos: capture}
+		errCh := make(chan error, 1)
+		r.getTableAsync(context.Background(), errCh)
+		require.NoError(t, <-errCh)
+		assert.Equal(t, []ColumnInfo{
+			{Name: "id", Type: "bigint"},
+			{Name: "name", Type: "string"},
+		}, capture.infos)
+	})
+}
+
+func TestColumnInfos(t *testing.T) {
+	client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	ctx := SetCaptureColumnInfos(context.Background())
+	rows, err := db.QueryContext(ctx, "SELECT name FROM t")
+	require.NoError(t, err)
+	require.NoError(t, DrainRows(rows))
+
+	infos, ok := ColumnInfos(ctx)
+	require.True(t, ok)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "name", infos[0].Name)
+
+	_, ok = ColumnInfos(context.Background())
+	assert.False(t, ok)
+}
+
+func Test_getRecordsForDL_encoding(t *testing.T) {
+	// "café","naïve" in ISO-8859-1
+	latin1 := "\"caf\xe9\",\"na\xefve\"\n"
+
+	got, err := getRecordsForDL(decodeResult(strings.NewReader(latin1), charmap.ISO8859_1), csvOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, [][]downloadField{{{val: "café"}, {val: "naïve"}}}, got)
+
+	got, err = getRecordsForDL(decodeResult(strings.NewReader("\"café\"\n"), nil), csvOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, [][]downloadField{{{val: "café"}}}, got)
+}
+
+type mockAthenaAccessDeniedClient struct {
+	*mockAthenaConnClient
+}
+
+func (m mockAthenaAccessDeniedClient) GetTableMetadataWithContext(aws.Context, *athena.GetTableMetadataInput, ...request.Option) (*athena.GetTableMetadataOutput, error) {
+	return nil, awserr.New("AccessDeniedException", "not authorized to perform athena:GetTableMetadata", nil)
+}
+
+func TestRowsGzipDL_getTableAccessDenied(t *testing.T) {
+	client := mockAthenaAccessDeniedClient{&mockAthenaConnClient{
+		results: genResults([]*athena.ColumnInfo{
+			genTypedColumnInfo("id", "integer"),
+			genTypedColumnInfo("name", "varchar"),
+		}),
+	}}
+	var logs bytes.Buffer
+	c := &conn{athena: client, logger: log.New(&logs, "", 0)}
+
+	r := &rowsGzipDL{
+		athena:            client,
+		ctasTable:         "tmp_ctas",
+		downloadedRows:    &downloadedRows{data: [][]string{{"1", "foo"}, {"2", "\\N"}}},
+		describeCTASTable: c.describeCTASTable(context.Background(), "tmp_ctas"),
+		logf:              c.logf,
+	}
+	errCh := make(chan error, 1)
+	r.getTableAsync(context.Background(), errCh)
+	require.NoError(t, <-errCh)
+
+	assert.Equal(t, "SELECT * FROM tmp_ctas LIMIT 0", *client.startInputs[0].QueryString)
+	assert.Contains(t, logs.String(), "GetTableMetadata is not permitted")
+	assert.Equal(t, []string{"id", "name"}, r.Columns())
+
+	var rows [][]driver.Value
+	for {
+		dest := make([]driver.Value, 2)
+		if err := r.Next(dest); err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		rows = append(rows, dest)
+	}
+	assert.Equal(t, [][]driver.Value{{int64(1), "foo"}, {int64(2), nil}}, rows)
+
+	r = &rowsGzipDL{athena: client, ctasTable: "tmp_ctas"}
+	r.getTableAsync(context.Background(), errCh)
+	assert.True(t, isAccessDenied(<-errCh))
+}
+
+type mockS3Client struct {
+	s3iface.S3API
+
+	// objects maps "bucket/key" to the content
+	objects map[string][]byte
+}
+
+func (m *mockS3Client) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	obj, ok := m.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(obj)),
+		ContentLength: aws.Int64(int64(len(obj))),
+	}, nil
+}
+
+func gzipData(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestRows_downloadedBytes(t *testing.T) {
+	t.Run("dl", func(t *testing.T) {
+		csv := []byte("\"name\"\n\"foo\"\n\"bar\"\n")
+		downloaded := &downloadedBytes{}
+		r, err := newRowsDL(context.Background(), rowsConfig{
+			Athena:          &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})},
+			S3:              &mockS3Client{objects: map[string][]byte{"bucket/results/dl.csv": csv}},
+			QueryID:         "dl",
+			OutputLocation:  "s3://bucket/results",
+			Timeout:         timeOutLimitDefault,
+			DownloadedBytes: downloaded,
+		})
+		require.NoError(t, err)
+		defer r.Close()
+		records, err := readRecords(r.records)
+		require.NoError(t, err)
+		assert.Len(t, records, 2)
+		assert.Equal(t, int64(len(csv)), downloaded.get())
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		manifest := []byte("s3://bucket/tables/gz/1.gz\ns3://bucket/tables/gz/2.gz\n")
+		data1 := gzipData(t, "1\001foo\n")
+		data2 := gzipData(t, "2\001bar\n")
+		downloaded := &downloadedBytes{}
+		r, err := newRowsGzipDL(context.Background(), rowsConfig{
+			Athena: new(mockAthenaSchemaClient),
+			S3: &mockS3Client{objects: map[string][]byte{
+				"bucket/tables/gz-manifest.csv": manifest,
+				"bucket/tables/gz/1.gz":         data1,
+				"bucket/tables/gz/2.gz":         data2,
+			}},
+			QueryID:         "gz",
+			OutputLocation:  "s3://bucket",
+			Timeout:         timeOutLimitDefault,
+			DownloadedBytes: downloaded,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(manifest)+len(data1)+len(data2)), downloaded.get())
+		assert.Equal(t, [][]string{{"1", "foo"}, {"2", "bar"}}, r.downloadedRows.data)
+	})
+}
+
+func TestRowsGzipDL_zeroByteObjects(t *testing.T) {
+	manifest := []byte("s3://bucket/tables/gz/1.gz\ns3://bucket/tables/gz/2.gz\n")
+	r, err := newRowsGzipDL(context.Background(), rowsConfig{
+		Athena: new(mockAthenaSchemaClient),
+		S3: &mockS3Client{objects: map[string][]byte{
+			"bucket/tables/gz-manifest.csv": manifest,
+			"bucket/tables/gz/1.gz":         {},
+			"bucket/tables/gz/2.gz":         gzipData(t, "2\001bar\n"),
+		}},
+		QueryID:        "gz",
+		OutputLocation: "s3://bucket",
+		Timeout:        timeOutLimitDefault,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"2", "bar"}}, r.downloadedRows.data)
+
+	// every partition is empty
+	r, err = newRowsGzipDL(context.Background(), rowsConfig{
+		Athena: new(mockAthenaSchemaClient),
+		S3: &mockS3Client{objects: map[string][]byte{
+			"bucket/tables/gz-manifest.csv": []byte("s3://bucket/tables/gz/1.gz\n"),
+			"bucket/tables/gz/1.gz":         {},
+		}},
+		QueryID:        "gz",
+		OutputLocation: "s3://bucket",
+		Timeout:        timeOutLimitDefault,
+	})
+	require.NoError(t, err)
+	dest := make([]driver.Value, 2)
+	assert.Equal(t, io.EOF, r.Next(dest))
+}
+
+func TestRowsGzipDL_longLines(t *testing.T) {
+	newRows := func(data []byte) (*rowsGzipDL, error) {
+		return newRowsGzipDL(context.Background(), rowsConfig{
+			Athena: new(mockAthenaSchemaClient),
+			S3: &mockS3Client{objects: map[string][]byte{
+				"bucket/tables/gz-manifest.csv": []byte("s3://bucket/tables/gz/1.gz\n"),
+				"bucket/tables/gz/1.gz":         data,
+			}},
+			QueryID:        "gz",
+			OutputLocation: "s3://bucket",
+			Timeout:        timeOutLimitDefault,
+		})
+	}
+
+	// longer than bufio.MaxScanTokenSize
+	long := strings.Repeat("a", 1<<20)
+	r, err := newRows(gzipData(t, "1\001"+long+"\n2\001bar\n"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"1", long}, {"2", "bar"}}, r.downloadedRows.data)
+
+	_, err = newRows(gzipData(t, "1\001"+strings.Repeat("a", gzipMaxLineSize)+"\n"))
+	assert.Equal(t, bufio.ErrTooLong, err)
+
+	_, err = getObjectKeysForGzip(strings.NewReader(strings.Repeat("a", gzipMaxLineSize+1)), 0)
+	assert.Equal(t, bufio.ErrTooLong, err)
+}
+
+func TestRowsGzipDL_truncatedObject(t *testing.T) {
+	data := gzipData(t, "1\001foo\n2\001bar\n")
+	_, err := newRowsGzipDL(context.Background(), rowsConfig{
+		Athena: new(mockAthenaSchemaClient),
+		S3: &mockS3Client{objects: map[string][]byte{
+			"bucket/tables/gz-manifest.csv": []byte("s3://bucket/tables/gz/1.gz\n"),
+			"bucket/tables/gz/1.gz":         data[:len(data)-4],
+		}},
+		QueryID:        "gz",
+		OutputLocation: "s3://bucket",
+		Timeout:        timeOutLimitDefault,
+	})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestRows_ColumnsWithoutName(t *testing.T) {
+	labeled := genColumnInfo("labeled")
+	labeled.Name = nil
+	unnamed := genColumnInfo("")
+	unnamed.Name = nil
+	unnamed.Label = nil
+	columns := []*athena.ColumnInfo{genColumnInfo("name"), labeled, unnamed}
+	want := []string{"name", "labeled", "_col2"}
+
+	api := &rowsAPI{out: genResults(columns)}
+	assert.Equal(t, want, api.Columns())
+
+	dl := &rowsDL{out: genResults(columns)}
+	assert.Equal(t, want, dl.Columns())
+
+	gz := &rowsGzipDL{ctasTableColumns: []*athena.Column{{Name: aws.String("name")}, {}, {Name: aws.String("")}}}
+	assert.Equal(t, []string{"name", "_col1", "_col2"}, gz.Columns())
+}
+
+func Test_getRecordsForDL_delimiterAndQuote(t *testing.T) {
+	tsv := "'name'\t'note'\n'a\tb'\t'it''s'\n\t'x, \"y\"'\n"
+	got, err := getRecordsForDL(strings.NewReader(tsv), csvOptions{delimiter: '\t', quote: '\''})
+	require.NoError(t, err)
+	assert.Equal(t, [][]downloadField{
+		{{val: "name"}, {val: "note"}},
+		{{val: "a\tb"}, {val: "it's"}},
+		{{isNil: true}, {val: `x, "y"`}},
+	}, got)
+
+	r, err := newRowsDL(context.Background(), rowsConfig{
+		Athena:         &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name"), genColumnInfo("note")})},
+		S3:             &mockS3Client{objects: map[string][]byte{"bucket/tsv.csv": []byte(tsv)}},
+		QueryID:        "tsv",
+		OutputLocation: "s3://bucket",
+		Timeout:        timeOutLimitDefault,
+		CSVDelimiter:   '\t',
+		CSVQuote:       '\'',
+	})
+	require.NoError(t, err)
+	dest := make([]driver.Value, 2)
+	require.NoError(t, r.Next(dest))
+	assert.Equal(t, []driver.Value{"a\tb", "it's"}, dest)
+}
+
+func Test_getRecordsForDL_trimSpaceAsNull(t *testing.T) {
+	csv := ",\"a\",  ,\"  \"\n\" \",\t,x, x \n"
+
+	got, err := getRecordsForDL(strings.NewReader(csv), csvOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, [][]downloadField{
+		{{isNil: true}, {val: "a"}, {val: "  "}, {val: "  "}},
+		{{val: " "}, {val: "\t"}, {val: "x"}, {val: " x "}},
+	}, got)
+
+	got, err = getRecordsForDL(strings.NewReader(csv), csvOptions{trimSpaceAsNull: true})
+	require.NoError(t, err)
+	assert.Equal(t, [][]downloadField{
+		{{isNil: true}, {val: "a"}, {val: "  ", isNil: true}, {val: "  "}},
+		{{val: " "}, {val: "\t", isNil: true}, {val: "x"}, {val: " x "}},
+	}, got)
+}
+
+func TestRowsDL_resultCompression(t *testing.T) {
+	csv := "\"name\"\n\"foo\"\n"
+	tests := []struct {
+		name        string
+		location    string
+		object      []byte
+		compression ResultCompression
+		wantErr     bool
+	}{
+		{name: "uncompressed", location: "s3://bucket/dl.csv", object: []byte(csv)},
+		{name: "gzip by key", location: "s3://bucket/dl.csv.gz", object: gzipData(t, csv)},
+		{name: "gzip by content", location: "s3://bucket/dl.csv", object: gzipData(t, csv)},
+		{name: "gzip hint", location: "s3://bucket/dl.csv", object: gzipData(t, csv), compression: ResultCompressionGzip},
+		{name: "none hint", location: "s3://bucket/dl.csv.gz", object: []byte(csv), compression: ResultCompressionNone},
+		{name: "corrupt gzip", location: "s3://bucket/dl.csv.gz", object: gzipData(t, csv)[:20], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, key, err := parseS3URL(tt.location)
+			require.NoError(t, err)
+			r, err := newRowsDL(context.Background(), rowsConfig{
+				Athena:            &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})},
+				S3:                &mockS3Client{objects: map[string][]byte{bucket + "/" + key: tt.object}},
+				QueryID:           "dl",
+				ResultLocation:    tt.location,
+				Timeout:           timeOutLimitDefault,
+				ResultCompression: tt.compression,
+			})
+			if err == nil {
+				defer r.Close()
+			}
+			var records [][]downloadField
+			if err == nil {
+				// the result is decompressed as the rows are read
+				records, err = readRecords(r.records)
+			}
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, [][]downloadField{{{val: "foo"}}}, records)
+		})
+	}
+}
+
+// mockAthenaProjectedClient reports the columns of a CTAS table of
+// "SELECT name, id FROM t", where t declares id before name.
+type mockAthenaProjectedClient struct {
+	*mockAthenaConnClient
+}
+
+func (m mockAthenaProjectedClient) GetTableMetadataWithContext(_ aws.Context, input *athena.GetTableMetadataInput, _ ...request.Option) (*athena.GetTableMetadataOutput, error) {
+	return &athena.GetTableMetadataOutput{
+		TableMetadata: &athena.TableMetadata{
+			Name: input.TableName,
+			Columns: []*athena.Column{
+				{Name: aws.String("name"), Type: aws.String("varchar")},
+				{Name: aws.String("id"), Type: aws.String("integer")},
+			},
+		},
+	}, nil
+}
+
+func TestRows_projectedColumnOrder(t *testing.T) {
+	client := mockAthenaProjectedClient{&mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{genTypedColumnInfo("name", "varchar"), genTypedColumnInfo("id", "integer")},
+			[]*string{aws.String("foo"), aws.String("1")},
+		),
+	}}
+
+	for _, mode := range []ResultMode{ResultModeAPI, ResultModeDL, ResultModeGzipDL} {
+		c := &conn{
+			athena: client,
+			s3: &mockS3Client{objects: map[string][]byte{
+				"bucket/query_2.csv":                 []byte("\"name\",\"id\"\n\"foo\",\"1\"\n"),
+				"bucket/tables/query_3-manifest.csv": []byte("s3://bucket/tables/query_3/1.gz\n"),
+				"bucket/tables/query_3/1.gz":         gzipData(t, "foo\0011\n"),
+			}},
+			OutputLocation: "s3://bucket",
+			resultMode:     mode,
+			timeout:        timeOutLimitDefault,
+		}
+
+		rows, err := c.QueryContext(context.Background(), "SELECT name, id FROM t", nil)
+		require.NoError(t, err, mode)
+		assert.Equal(t, []string{"name", "id"}, rows.Columns(), mode)
+
+		dest := make([]driver.Value, 2)
+		require.NoError(t, rows.Next(dest), mode)
+		assert.Equal(t, []driver.Value{"foo", int64(1)}, dest, mode)
+		assert.Equal(t, io.EOF, rows.Next(dest), mode)
+	}
+}
+
+func TestRows_rowProfiler(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{genTypedColumnInfo("id", "integer")},
+			[]*string{aws.String("1")}, []*string{aws.String("2")}, []*string{aws.String("3")},
+		),
+	}
+	var indexes []int
+	db := newMockDB(t, client, Config{
+		RowProfiler: func(rowIndex int, dur time.Duration) {
+			assert.True(t, dur >= 0)
+			indexes = append(indexes, rowIndex)
+		},
+	})
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id FROM t")
+	require.NoError(t, err)
+	types, err := rows.ColumnTypes()
+	require.NoError(t, err)
+	assert.Equal(t, "integer", types[0].DatabaseTypeName())
+	require.NoError(t, DrainRows(rows))
+	assert.Equal(t, []int{0, 1, 2}, indexes)
+}
+
+func TestRows_json(t *testing.T) {
+	columns := []*athena.ColumnInfo{genTypedColumnInfo("doc", "json")}
+	client := &mockAthenaConnClient{results: genResults(columns, []*string{aws.String(`{"a":1,"b":"x \"y\""}`)})}
+
+	for _, mode := range []ResultMode{ResultModeAPI, ResultModeDL} {
+		c := &conn{
+			athena:         client,
+			s3:             &mockS3Client{objects: map[string][]byte{"bucket/query_2.csv": []byte("\"doc\"\n\"{\"\"a\"\":1,\"\"b\"\":\"\"x \\\"\"y\\\"\"\"\"}\"\n")}},
+			OutputLocation: "s3://bucket",
+			resultMode:     mode,
+			timeout:        timeOutLimitDefault,
+		}
+
+		rows, err := c.QueryContext(context.Background(), `SELECT json_parse('{"a":1}') AS doc`, nil)
+		require.NoError(t, err, mode)
+		dest := make([]driver.Value, 1)
+		require.NoError(t, rows.Next(dest), mode)
+		assert.Equal(t, []byte(`{"a":1,"b":"x \"y\""}`), dest[0], mode)
+	}
+
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+	var doc json.RawMessage
+	require.NoError(t, db.QueryRow("SELECT doc FROM t").Scan(&doc))
+	var v struct {
+		A int    `json:"a"`
+		B string `json:"b"`
+	}
+	require.NoError(t, json.Unmarshal(doc, &v))
+	assert.Equal(t, 1, v.A)
+	assert.Equal(t, `x "y"`, v.B)
+
+	var s string
+	require.NoError(t, db.QueryRow("SELECT doc FROM t").Scan(&s))
+	assert.Equal(t, `{"a":1,"b":"x \"y\""}`, s)
+}
+
+func TestRows_decimalMode(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(
+			[]*athena.ColumnInfo{genTypedColumnInfo("amount", "decimal")},
+			[]*string{aws.String("0.1000000000000000055511151231257827")},
+		),
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	var amount interface{}
+	require.NoError(t, db.QueryRowContext(SetDecimalMode(context.Background()), "SELECT amount FROM t").Scan(&amount))
+	require.IsType(t, decimal.Decimal{}, amount)
+	assert.Equal(t, "0.1000000000000000055511151231257827", amount.(decimal.Decimal).String())
+
+	// float64 by default
+	var f float64
+	require.NoError(t, db.QueryRowContext(context.Background(), "SELECT amount FROM t").Scan(&f))
+	assert.Equal(t, 0.1, f)
+}
+
+func TestColumnInfos_joinedTables(t *testing.T) {
+	column := func(name, table string) *athena.ColumnInfo {
+		c := genColumnInfo(name)
+		c.CatalogName = aws.String("awsdatacatalog")
+		c.SchemaName = aws.String("sampledb")
+		c.TableName = aws.String(table)
+		return c
+	}
+	client := &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{column("id", "users"), column("id", "orders")})}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	ctx := SetCaptureColumnInfos(context.Background())
+	rows, err := db.QueryContext(ctx, "SELECT u.id, o.id FROM users u JOIN orders o ON o.user_id = u.id")
+	require.NoError(t, err)
+	require.NoError(t, DrainRows(rows))
+
+	infos, ok := ColumnInfos(ctx)
+	require.True(t, ok)
+	require.Len(t, infos, 2)
+	for i, table := range []string{"users", "orders"} {
+		assert.Equal(t, "id", infos[i].Name)
+		assert.Equal(t, "awsdatacatalog", infos[i].CatalogName)
+		assert.Equal(t, "sampledb", infos[i].SchemaName)
+		assert.Equal(t, table, infos[i].TableName)
+	}
+}
+
+// mockAthenaZeroColumnClient reports CTAS tables without columns.
+type mockAthenaZeroColumnClient struct {
+	*mockAthenaPagingClient
+}
+
+func (m mockAthenaZeroColumnClient) GetTableMetadataWithContext(_ aws.Context, input *athena.GetTableMetadataInput, _ ...request.Option) (*athena.GetTableMetadataOutput, error) {
+	return &athena.GetTableMetadataOutput{
+		TableMetadata: &athena.TableMetadata{Name: input.TableName},
+	}, nil
+}
+
+func TestRows_zeroColumns(t *testing.T) {
+	tests := []struct {
+		mode    ResultMode
+		objects map[string][]byte
+		want    int
+	}{
+		{mode: ResultModeAPI, want: 2},
+		{mode: ResultModeDL, objects: map[string][]byte{
+			"bucket/results/query_1.csv": []byte("\n\n\n"),
+		}, want: 2},
+		{mode: ResultModeDL, objects: map[string][]byte{
+			"bucket/results/query_1.csv": []byte(""),
+		}, want: 0},
+		{mode: ResultModeGzipDL, objects: map[string][]byte{
+			"bucket/results/tables/query_1-manifest.csv": []byte("s3://bucket/results/tables/query_1/part-0.gz\n"),
+			"bucket/results/tables/query_1/part-0.gz":    gzipData(t, "\n\n"),
+		}, want: 2},
+	}
+	for _, test := range tests {
+		client := mockAthenaZeroColumnClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{
+			results: genResults(nil, []*string{}, []*string{}),
+		}}}
+		db := newHarnessDB(t, client, test.objects, test.mode)
+
+		rows, err := db.QueryContext(context.Background(), "SELECT")
+		require.NoError(t, err, test.mode)
+		columns, err := rows.Columns()
+		require.NoError(t, err, test.mode)
+		assert.Empty(t, columns, test.mode)
+
+		n := 0
+		for rows.Next() {
+			require.NoError(t, rows.Scan(), test.mode)
+			n++
+		}
+		require.NoError(t, rows.Err(), test.mode)
+		assert.Equal(t, test.want, n, test.mode)
+		rows.Close()
+		db.Close()
+	}
+}
+
+// mockAthenaMixedCaseClient reports the columns of its results as those of
+// CTAS tables, keeping their case.
+type mockAthenaMixedCaseClient struct {
+	*mockAthenaPagingClient
+}
+
+func (m mockAthenaMixedCaseClient) GetTableMetadataWithContext(_ aws.Context, input *athena.GetTableMetadataInput, _ ...request.Option) (*athena.GetTableMetadataOutput, error) {
+	var columns []*athena.Column
+	for _, col := range m.results.ResultSet.ResultSetMetadata.ColumnInfo {
+		columns = append(columns, &athena.Column{Name: col.Name, Type: col.Type})
+	}
+	return &athena.GetTableMetadataOutput{
+		TableMetadata: &athena.TableMetadata{Name: input.TableName, Columns: columns},
+	}, nil
+}
+
+func TestRows_normalizeColumnNames(t *testing.T) {
+	tests := []struct {
+		mode    ResultMode
+		objects map[string][]byte
+	}{
+		{mode: ResultModeAPI},
+		{mode: ResultModeDL, objects: map[string][]byte{
+			"bucket/results/query_1.csv": []byte("\"UserID\",\"name\"\n\"1\",\"a\"\n"),
+		}},
+		{mode: ResultModeGzipDL, objects: map[string][]byte{
+			"bucket/results/tables/query_1-manifest.csv": []byte("s3://bucket/results/tables/query_1/part-0.gz\n"),
+			"bucket/results/tables/query_1/part-0.gz":    gzipData(t, "1\001a\n"),
+		}},
+	}
+	for _, test := range tests {
+		for _, normalize := range []bool{false, true} {
+			client := mockAthenaMixedCaseClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{
+				results: genResults([]*athena.ColumnInfo{genColumnInfo("UserID"), genColumnInfo("name")},
+					[]*string{aws.String("1"), aws.String("a")}),
+			}}}
+			db := newHarnessDB(t, client, test.objects, test.mode)
+
+			ctx := context.Background()
+			want := []string{"UserID", "name"}
+			if normalize {
+				ctx = SetNormalizeColumnNames(ctx)
+				want = []string{"userid", "name"}
+			}
+
+			rows, err := db.QueryContext(ctx, `SELECT user_id AS "UserID", name FROM t`)
+			require.NoError(t, err, test.mode)
+			columns, err := rows.Columns()
+			require.NoError(t, err, test.mode)
+			assert.Equal(t, want, columns, test.mode)
+			types, err := rows.ColumnTypes()
+			require.NoError(t, err, test.mode)
+			assert.Equal(t, "varchar", types[0].DatabaseTypeName(), test.mode)
+			rows.Close()
+			db.Close()
+		}
+	}
+}
+
+// closeRecorder records whether the body of an S3 object was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+type mockStreamingS3Client struct {
+	s3iface.S3API
+	body *closeRecorder
+}
+
+func (m *mockStreamingS3Client) GetObjectWithContext(_ aws.Context, _ *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{Body: m.body}, nil
+}
+
+func TestRowsDL_streaming(t *testing.T) {
+	var csv strings.Builder
+	csv.WriteString("\"name\"\n")
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&csv, "\"name%d\"\n", i)
+	}
+	client := &mockStreamingS3Client{body: &closeRecorder{Reader: strings.NewReader(csv.String())}}
+	downloaded := &downloadedBytes{}
+	r, err := newRowsDL(context.Background(), rowsConfig{
+		Athena:          &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})},
+		S3:              client,
+		QueryID:         "dl",
+		OutputLocation:  "s3://bucket/results",
+		Timeout:         timeOutLimitDefault,
+		DownloadedBytes: downloaded,
+	})
+	require.NoError(t, err)
+
+	dest := make([]driver.Value, 1)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Next(dest))
+		assert.Equal(t, fmt.Sprintf("name%d", i), dest[0])
+	}
+	// only the beginning of the result has been read
+	assert.Less(t, downloaded.get(), int64(csv.Len()/10))
+
+	n := 3
+	for r.Next(dest) == nil {
+		n++
+	}
+	assert.Equal(t, 100000, n)
+	assert.Equal(t, int64(csv.Len()), downloaded.get())
+
+	require.NoError(t, r.Close())
+	assert.True(t, client.body.closed)
+}
+
+func TestRowsDL_timeout(t *testing.T) {
+	for _, timeout := range []uint{0, timeOutLimitDefault} {
+		client := &mockStreamingS3Client{body: &closeRecorder{Reader: strings.NewReader("\"name\"\n\"a\"\n\"b\"\n")}}
+		r, err := newRowsDL(context.Background(), rowsConfig{
+			Athena:         &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})},
+			S3:             client,
+			QueryID:        "dl",
+			OutputLocation: "s3://bucket/results",
+			Timeout:        timeout,
+		})
+		require.NoError(t, err, timeout)
+
+		// the timeout doesn't limit reading the rows, and 0 is no limit
+		body := r.body.(*resumableBody)
+		_, hasDeadline := body.ctx.Deadline()
+		assert.False(t, hasDeadline, timeout)
+		assert.NoError(t, body.ctx.Err(), timeout)
+
+		dest := make([]driver.Value, 1)
+		require.NoError(t, r.Next(dest), timeout)
+		assert.Equal(t, "a", dest[0])
+		require.NoError(t, r.Close())
+		assert.Error(t, body.ctx.Err(), "closing the rows ends the download")
+	}
+}
+
+// failingBody fails after reading n bytes.
+type failingBody struct {
+	io.Reader
+	n int
+}
+
+func (b *failingBody) Read(p []byte) (int, error) {
+	if b.n == 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	if len(p) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.Reader.Read(p)
+	b.n -= n
+	return n, err
+}
+
+func (b *failingBody) Close() error { return nil }
+
+// mockResumingS3Client serves ranges of data, failing the first failures
+// responses after failAfter bytes.
+type mockResumingS3Client struct {
+	s3iface.S3API
+	data      []byte
+	failAfter int
+	failures  int
+	inputs    []*s3.GetObjectInput
+}
+
+func (m *mockResumingS3Client) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	m.inputs = append(m.inputs, input)
+	var offset int
+	if input.Range != nil {
+		fmt.Sscanf(*input.Range, "bytes=%d-", &offset)
+	}
+	body := &failingBody{Reader: bytes.NewReader(m.data[offset:]), n: len(m.data)}
+	if len(m.inputs) <= m.failures {
+		body.n = m.failAfter
+	}
+	return &s3.GetObjectOutput{Body: body, ETag: aws.String(`"etag"`)}, nil
+}
+
+func TestRowsDL_resumeDownload(t *testing.T) {
+	var csv strings.Builder
+	csv.WriteString("\"name\"\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&csv, "\"name%d\"\n", i)
+	}
+	newRows := func(client s3iface.S3API) *rowsDL {
+		r, err := newRowsDL(context.Background(), rowsConfig{
+			Athena:         &mockAthenaConnClient{results: genResults([]*athena.ColumnInfo{genColumnInfo("name")})},
+			S3:             client,
+			QueryID:        "dl",
+			OutputLocation: "s3://bucket/results",
+			Timeout:        timeOutLimitDefault,
+		})
+		require.NoError(t, err)
+		return r
+	}
+
+	client := &mockResumingS3Client{data: []byte(csv.String()), failAfter: 50000, failures: 1}
+	r := newRows(client)
+	defer r.Close()
+	records, err := readRecords(r.records)
+	require.NoError(t, err)
+	require.Len(t, records, 10000)
+	for i, record := range records {
+		assert.Equal(t, []downloadField{{val: fmt.Sprintf("name%d", i)}}, record)
+	}
+	require.Len(t, client.inputs, 2)
+	assert.Equal(t, "bytes=50000-", aws.StringValue(client.inputs[1].Range))
+	assert.Equal(t, `"etag"`, aws.StringValue(client.inputs[1].IfMatch))
+
+	// the download is given up after maxResumes
+	client = &mockResumingS3Client{data: []byte(csv.String()), failAfter: 10000, failures: maxResumes + 1}
+	r = newRows(client)
+	defer r.Close()
+	_, err = readRecords(r.records)
+	assert.EqualError(t, err, "connection reset by peer")
+	assert.Len(t, client.inputs, maxResumes+1)
+}
+
+// mockConcurrentS3Client serves objects with delays, recording how many are
+// downloaded at once.
+type mockConcurrentS3Client struct {
+	mockS3Client
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	requested   int
+}
+
+func (m *mockConcurrentS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	m.inFlight++
+	m.requested++
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.inFlight--
+		m.mu.Unlock()
+	}()
+
+	// later objects finish first
+	var n int
+	fmt.Sscanf(*input.Key, "tables/gz/%d.gz", &n)
+	select {
+	case <-time.After(time.Duration(20-n) * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return m.mockS3Client.GetObjectWithContext(ctx, input, opts...)
+}
+
+func TestRowsGzipDL_concurrentDownload(t *testing.T) {
+	objects := map[string][]byte{}
+	var manifest strings.Builder
+	var want [][]string
+	for i := 1; i <= 12; i++ {
+		fmt.Fprintf(&manifest, "s3://bucket/tables/gz/%d.gz\n", i)
+		objects[fmt.Sprintf("bucket/tables/gz/%d.gz", i)] = gzipData(t, fmt.Sprintf("%d\001a\n%d\001b\n", i, i))
+		want = append(want, []string{fmt.Sprint(i), "a"}, []string{fmt.Sprint(i), "b"})
+	}
+	objects["bucket/tables/gz-manifest.csv"] = []byte(manifest.String())
+
+	client := &mockConcurrentS3Client{mockS3Client: mockS3Client{objects: objects}}
+	r, err := newRowsGzipDL(context.Background(), rowsConfig{
+		Athena:              new(mockAthenaSchemaClient),
+		S3:                  client,
+		QueryID:             "gz",
+		OutputLocation:      "s3://bucket",
+		Timeout:             timeOutLimitDefault,
+		DownloadConcurrency: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, want, r.downloadedRows.data)
+	assert.Equal(t, 3, client.maxInFlight)
+
+	// an error cancels the other downloads
+	delete(objects, "bucket/tables/gz/2.gz")
+	client = &mockConcurrentS3Client{mockS3Client: mockS3Client{objects: objects}}
+	_, err = newRowsGzipDL(context.Background(), rowsConfig{
+		Athena:              new(mockAthenaSchemaClient),
+		S3:                  client,
+		QueryID:             "gz",
+		OutputLocation:      "s3://bucket",
+		Timeout:             timeOutLimitDefault,
+		DownloadConcurrency: 3,
+	})
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Less(t, client.requested, 13)
+}
+
+func Test_getRecordsForDL_embeddedNewlines(t *testing.T) {
+	csv := "\"id\",\"note\"\n" +
+		"\"1\",\"first line\nsecond line\"\n" +
+		"\"2\",\"a \"\"quoted\"\"\nline\",\n" +
+		"\"3\",\"\n\n\"\n" +
+		"\"4\",\"\"\n" +
+		"\"5\",\n"
+	got, err := getRecordsForDL(strings.NewReader(csv), csvOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, [][]downloadField{
+		{{val: "id"}, {val: "note"}},
+		{{val: "1"}, {val: "first line\nsecond line"}},
+		{{val: "2"}, {val: "a \"quoted\"\nline"}, {isNil: true}},
+		{{val: "3"}, {val: "\n\n"}},
+		{{val: "4"}, {val: ""}},
+		{{val: "5"}, {isNil: true}},
+	}, got)
+
+	// a quote left open at the end of the result ends the record
+	got, err = getRecordsForDL(strings.NewReader("\"1\",\"open\nfield"), csvOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, [][]downloadField{{{val: "1"}, {val: "\"open\nfield"}}}, got)
+}
+
+// mockAthenaContextClient fails the calls made with a done context, as the SDK does.
+type mockAthenaContextClient struct {
+	*mockAthenaPagingClient
+}
+
+func (m mockAthenaContextClient) GetQueryResultsWithContext(ctx aws.Context, input *athena.GetQueryResultsInput, opts ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.mockAthenaPagingClient.GetQueryResultsWithContext(ctx, input, opts...)
+}
+
+func TestRows_contextOfNextPages(t *testing.T) {
+	client := mockAthenaContextClient{&mockAthenaPagingClient{
+		mockAthenaConnClient: &mockAthenaConnClient{
+			results: genResults([]*athena.ColumnInfo{genColumnInfo("name")},
+				[]*string{aws.String("a")}, []*string{aws.String("b")}, []*string{aws.String("c")}),
+		},
+		pageSize: 2,
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := newRowsAPI(ctx, rowsConfig{Athena: client, QueryID: "api", SkipHeader: true})
+	require.NoError(t, err)
+
+	dest := make([]driver.Value, 1)
+	require.NoError(t, r.Next(dest))
+	assert.Equal(t, "a", dest[0])
+
+	// the next page isn't fetched once the query's context is done
+	cancel()
+	assert.Equal(t, context.Canceled, r.Next(dest))
+	assert.Len(t, client.resultInputs, 1)
+}
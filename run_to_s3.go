@@ -0,0 +1,48 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// RunToS3 runs query on db and returns the S3 location Athena wrote its result
+// to, e.g. "s3://bucket/results/<query ID>.csv", along with the statistics of
+// the query, without downloading the result, e.g. to hand it to another job.
+// The result mode doesn't apply, so the query is never wrapped in CTAS.
+func RunToS3(ctx context.Context, db *sql.DB, query string) (string, Statistics, error) {
+	var location string
+	var stats Statistics
+	err := withConn(ctx, db, func(c *conn) error {
+		var err error
+		location, stats, err = c.runToS3(ctx, query)
+		return err
+	})
+	return location, stats, err
+}
+
+func (c *conn) runToS3(ctx context.Context, query string) (string, Statistics, error) {
+	if c.readOnly && !isReadOnlyQuery(query) {
+		return "", Statistics{}, ErrReadOnly
+	}
+
+	if err := c.slots.acquire(ctx); err != nil {
+		return "", Statistics{}, err
+	}
+	defer c.slots.release()
+
+	_, execution, _, err := c.execute(ctx, query, c.waitOptions(ctx))
+	if err != nil {
+		return "", Statistics{}, err
+	}
+
+	var stats Statistics
+	stats.capture(execution)
+
+	var location string
+	if execution.ResultConfiguration != nil {
+		location = aws.StringValue(execution.ResultConfiguration.OutputLocation)
+	}
+	return location, stats, nil
+}
@@ -0,0 +1,33 @@
+package athena
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunToS3(t *testing.T) {
+	client := &mockAthenaConnClient{
+		resultLocation: "s3://bucket/results/query_1.csv",
+		statistics:     &athena.QueryExecutionStatistics{DataScannedInBytes: aws.Int64(1024)},
+	}
+	db := newMockDB(t, client, Config{ResultMode: ResultModeGzipDL})
+	defer db.Close()
+
+	location, stats, err := RunToS3(context.Background(), db, "SELECT name FROM t")
+	require.NoError(t, err)
+	assert.Equal(t, "s3://bucket/results/query_1.csv", location)
+	assert.Equal(t, "query_1", stats.QueryID)
+	assert.Equal(t, int64(1024), stats.DataScannedInBytes)
+	// the query isn't wrapped in CTAS, and the result isn't read
+	require.Len(t, client.startInputs, 1)
+	assert.Equal(t, "SELECT name FROM t", *client.startInputs[0].QueryString)
+
+	client.failQuery = func(string) string { return "SYNTAX_ERROR: line 1:8: Column 'x' cannot be resolved" }
+	_, _, err = RunToS3(context.Background(), db, "SELECT x FROM t")
+	assert.EqualError(t, err, "SYNTAX_ERROR: line 1:8: Column 'x' cannot be resolved")
+}
@@ -0,0 +1,63 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// TableColumn is a column of an Athena table as described by its metadata.
+type TableColumn struct {
+	Name    string
+	Type    string
+	Comment string
+}
+
+// GetTableSchema returns the columns of table in the database of db, including
+// each column's comment. Partition keys follow the regular columns.
+// The catalog can be overridden with SetCatalog in ctx.
+func GetTableSchema(ctx context.Context, db *sql.DB, table string) ([]TableColumn, error) {
+	var columns []TableColumn
+	err := withConn(ctx, db, func(c *conn) error {
+		var err error
+		columns, err = c.getTableSchema(ctx, table)
+		return err
+	})
+	return columns, err
+}
+
+func (c *conn) getTableSchema(ctx context.Context, table string) ([]TableColumn, error) {
+	catalog := c.catalog
+	if cat, ok := getCatalog(ctx); ok {
+		catalog = cat
+	}
+
+	var data *athena.GetTableMetadataOutput
+	err := c.retrier().do(ctx, func() (err error) {
+		data, err = c.athena.GetTableMetadataWithContext(ctx, &athena.GetTableMetadataInput{
+			CatalogName:  aws.String(catalog),
+			DatabaseName: aws.String(c.db),
+			TableName:    aws.String(table),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := data.TableMetadata
+	columns := make([]TableColumn, 0, len(metadata.Columns)+len(metadata.PartitionKeys))
+	for _, cols := range [][]*athena.Column{metadata.Columns, metadata.PartitionKeys} {
+		for _, col := range cols {
+			columns = append(columns, TableColumn{
+				Name:    aws.StringValue(col.Name),
+				Type:    aws.StringValue(col.Type),
+				Comment: aws.StringValue(col.Comment),
+			})
+		}
+	}
+
+	return columns, nil
+}
@@ -0,0 +1,57 @@
+package athena
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockAthenaSchemaClient struct {
+	athenaiface.AthenaAPI
+	input *athena.GetTableMetadataInput
+}
+
+func (m *mockAthenaSchemaClient) GetTableMetadataWithContext(_ aws.Context, input *athena.GetTableMetadataInput, _ ...request.Option) (*athena.GetTableMetadataOutput, error) {
+	m.input = input
+	return &athena.GetTableMetadataOutput{
+		TableMetadata: &athena.TableMetadata{
+			Name: input.TableName,
+			Columns: []*athena.Column{
+				{Name: aws.String("id"), Type: aws.String("bigint"), Comment: aws.String("primary key")},
+				{Name: aws.String("name"), Type: aws.String("string")},
+			},
+			PartitionKeys: []*athena.Column{
+				{Name: aws.String("dt"), Type: aws.String("string"), Comment: aws.String("partition date")},
+			},
+		},
+	}, nil
+}
+
+func TestGetTableSchema(t *testing.T) {
+	client := new(mockAthenaSchemaClient)
+	c := &conn{athena: client, db: "sampledb", catalog: CATALOG_AWS_DATA_CATALOG}
+
+	columns, err := c.getTableSchema(context.Background(), "commented")
+	require.NoError(t, err)
+	assert.Equal(t, []TableColumn{
+		{Name: "id", Type: "bigint", Comment: "primary key"},
+		{Name: "name", Type: "string", Comment: ""},
+		{Name: "dt", Type: "string", Comment: "partition date"},
+	}, columns)
+	assert.Equal(t, "sampledb", *client.input.DatabaseName)
+	assert.Equal(t, CATALOG_AWS_DATA_CATALOG, *client.input.CatalogName)
+
+	_, err = c.getTableSchema(SetTimout(context.Background(), "other_catalog"), "commented")
+	require.NoError(t, err)
+	assert.Equal(t, "other_catalog", *client.input.CatalogName)
+}
+
+func (m *mockAthenaSchemaClient) GetTableMetadata(input *athena.GetTableMetadataInput) (*athena.GetTableMetadataOutput, error) {
+	return m.GetTableMetadataWithContext(context.Background(), input)
+}
@@ -0,0 +1,98 @@
+package athena
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// StatementType is the kind of a statement, e.g. to tell whether it returns
+// rows worth reading.
+type StatementType string
+
+const (
+	// StatementTypeSelect is a SELECT, including one with a WITH clause
+	StatementTypeSelect StatementType = "SELECT"
+
+	// StatementTypeCTAS is a CREATE TABLE AS SELECT
+	StatementTypeCTAS StatementType = "CTAS"
+
+	// StatementTypeDML modifies data, e.g. INSERT INTO or UNLOAD
+	StatementTypeDML StatementType = "DML"
+
+	// StatementTypeDDL defines or describes tables, e.g. CREATE TABLE or SHOW TABLES
+	StatementTypeDDL StatementType = "DDL"
+
+	// StatementTypeUtility is any other statement Athena runs, e.g. EXPLAIN
+	StatementTypeUtility StatementType = "UTILITY"
+)
+
+// substatementTypes are the StatementTypes of the DML substatement types of Athena.
+var substatementTypes = map[string]StatementType{
+	"SELECT":                 StatementTypeSelect,
+	"CREATE_TABLE_AS_SELECT": StatementTypeCTAS,
+}
+
+// statementTypeOf returns the StatementType of a finished execution of query
+// as reported by Athena, or as classified from query when it isn't reported.
+func statementTypeOf(execution *athena.QueryExecution, query string) StatementType {
+	if execution != nil {
+		switch aws.StringValue(execution.StatementType) {
+		case athena.StatementTypeDdl:
+			return StatementTypeDDL
+		case athena.StatementTypeUtility:
+			return StatementTypeUtility
+		case athena.StatementTypeDml:
+			if sub := aws.StringValue(execution.SubstatementType); sub != "" {
+				if typ, ok := substatementTypes[sub]; ok {
+					return typ
+				}
+				return StatementTypeDML
+			}
+		}
+	}
+
+	return classifyQuery(query)
+}
+
+// dmlQueryRegex matches the statements modifying data.
+var dmlQueryRegex = regexp.MustCompile(`(?i)^(INSERT|UPDATE|DELETE|MERGE|UNLOAD|VACUUM|OPTIMIZE)\b`)
+
+// classifyQuery returns the StatementType of query by its leading keywords.
+func classifyQuery(query string) StatementType {
+	switch {
+	case isSelectQuery(query):
+		return StatementTypeSelect
+	case isCTASQuery(query):
+		return StatementTypeCTAS
+	case isDDLQuery(query):
+		return StatementTypeDDL
+	case dmlQueryRegex.MatchString(trimLeadingComments(query)):
+		return StatementTypeDML
+	}
+	return StatementTypeUtility
+}
+
+// statementTypeCapture holds the StatementType of the last query run with a context.
+type statementTypeCapture struct {
+	mu  sync.Mutex
+	typ StatementType
+}
+
+func (s *statementTypeCapture) set(typ StatementType) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.typ = typ
+	s.mu.Unlock()
+}
+
+func (s *statementTypeCapture) get() StatementType {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.typ
+}
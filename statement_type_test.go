@@ -0,0 +1,87 @@
+package athena
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_classifyQuery(t *testing.T) {
+	tests := map[string]StatementType{
+		"SELECT * FROM t":                                StatementTypeSelect,
+		"WITH a AS (SELECT 1) SELECT * FROM a":           StatementTypeSelect,
+		"-- comment\nselect 1":                           StatementTypeSelect,
+		"CREATE TABLE t2 AS SELECT * FROM t":             StatementTypeCTAS,
+		"CREATE TABLE t (id int) LOCATION 's3://bucket'": StatementTypeDDL,
+		"DROP TABLE t":                                   StatementTypeDDL,
+		"SHOW TABLES":                                    StatementTypeDDL,
+		"INSERT INTO t SELECT * FROM u":                  StatementTypeDML,
+		"UNLOAD (SELECT * FROM t) TO 's3://bucket/'":     StatementTypeDML,
+		"EXPLAIN SELECT * FROM t":                        StatementTypeUtility,
+	}
+	for query, want := range tests {
+		assert.Equal(t, want, classifyQuery(query), query)
+	}
+}
+
+func Test_statementTypeOf(t *testing.T) {
+	execution := func(typ, sub string) *athena.QueryExecution {
+		e := &athena.QueryExecution{StatementType: aws.String(typ)}
+		if sub != "" {
+			e.SubstatementType = aws.String(sub)
+		}
+		return e
+	}
+
+	tests := []struct {
+		execution *athena.QueryExecution
+		query     string
+		want      StatementType
+	}{
+		{execution("DML", "SELECT"), "(SELECT 1)", StatementTypeSelect},
+		{execution("DML", "CREATE_TABLE_AS_SELECT"), "CREATE TABLE t2 AS SELECT 1", StatementTypeCTAS},
+		{execution("DML", "INSERT"), "INSERT INTO t VALUES (1)", StatementTypeDML},
+		{execution("DDL", "CREATE_TABLE"), "CREATE TABLE t (id int)", StatementTypeDDL},
+		{execution("UTILITY", "EXPLAIN"), "EXPLAIN SELECT 1", StatementTypeUtility},
+		// classified from the query when Athena doesn't report it
+		{execution("DML", ""), "INSERT INTO t VALUES (1)", StatementTypeDML},
+		{&athena.QueryExecution{}, "SELECT 1", StatementTypeSelect},
+		{nil, "SHOW TABLES", StatementTypeDDL},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, statementTypeOf(tt.execution, tt.query), tt.query)
+	}
+}
+
+func TestLastStatementType(t *testing.T) {
+	client := mockAthenaHarnessClient{&mockAthenaPagingClient{mockAthenaConnClient: &mockAthenaConnClient{
+		results: genResults(harnessColumns),
+	}}}
+	objects := map[string][]byte{
+		"bucket/results/tables/query_1-manifest.csv": []byte(""),
+	}
+
+	db := newHarnessDB(t, client, objects, ResultModeGzipDL)
+	defer db.Close()
+
+	// the SELECT run by CTAS in GZIP DL mode
+	ctx := SetCaptureStatementType(context.Background())
+	rows, err := db.QueryContext(ctx, "SELECT id, name, note FROM t")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	typ, ok := LastStatementType(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, StatementTypeSelect, typ)
+
+	_, err = db.ExecContext(ctx, "INSERT INTO t SELECT * FROM u")
+	require.NoError(t, err)
+	typ, _ = LastStatementType(ctx)
+	assert.Equal(t, StatementTypeDML, typ)
+
+	_, ok = LastStatementType(context.Background())
+	assert.False(t, ok)
+}
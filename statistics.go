@@ -0,0 +1,71 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// Statistics are the statistics Athena reports for a finished query.
+type Statistics struct {
+	QueryID string
+
+	DataScannedInBytes int64
+
+	// The time breakdown of the query. TotalExecutionTimeInMillis is
+	// roughly the sum of the queue, planning, engine execution and
+	// service processing times.
+	QueryQueueTimeInMillis        int64
+	QueryPlanningTimeInMillis     int64
+	EngineExecutionTimeInMillis   int64
+	ServiceProcessingTimeInMillis int64
+	TotalExecutionTimeInMillis    int64
+
+	// ReusedPreviousResult is whether the result of a previous execution was returned.
+	ReusedPreviousResult bool
+
+	// SubmittedAt and CompletedAt are when Athena received the query and
+	// when it finished, so their difference includes the time in the queue.
+	SubmittedAt time.Time
+	CompletedAt time.Time
+}
+
+// capture copies the statistics of execution.
+func (s *Statistics) capture(execution *athena.QueryExecution) {
+	if s == nil || execution == nil {
+		return
+	}
+
+	s.QueryID = aws.StringValue(execution.QueryExecutionId)
+	if status := execution.Status; status != nil {
+		s.SubmittedAt = aws.TimeValue(status.SubmissionDateTime)
+		s.CompletedAt = aws.TimeValue(status.CompletionDateTime)
+	}
+	if stats := execution.Statistics; stats != nil {
+		s.DataScannedInBytes = aws.Int64Value(stats.DataScannedInBytes)
+		s.QueryQueueTimeInMillis = aws.Int64Value(stats.QueryQueueTimeInMillis)
+		s.QueryPlanningTimeInMillis = aws.Int64Value(stats.QueryPlanningTimeInMillis)
+		s.EngineExecutionTimeInMillis = aws.Int64Value(stats.EngineExecutionTimeInMillis)
+		s.ServiceProcessingTimeInMillis = aws.Int64Value(stats.ServiceProcessingTimeInMillis)
+		s.TotalExecutionTimeInMillis = aws.Int64Value(stats.TotalExecutionTimeInMillis)
+		if reuse := stats.ResultReuseInformation; reuse != nil {
+			s.ReusedPreviousResult = aws.BoolValue(reuse.ReusedPreviousResult)
+		}
+	}
+}
+
+// QueryWithStats runs query on db and returns its rows along with the
+// statistics of the query, which are complete by the time it returns.
+// In GZIP DL Mode they are the statistics of the CTAS query.
+func QueryWithStats(ctx context.Context, db *sql.DB, query string) (*sql.Rows, Statistics, error) {
+	var stats Statistics
+	rows, err := db.QueryContext(SetStatsCollector(ctx, &stats), query)
+	if err != nil {
+		return nil, Statistics{}, err
+	}
+
+	return rows, stats, nil
+}
@@ -0,0 +1,120 @@
+package athena
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryWithStats(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults([]*athena.ColumnInfo{genTypedColumnInfo("name", "varchar")}, []*string{aws.String("a")}),
+		statistics: &athena.QueryExecutionStatistics{
+			DataScannedInBytes:          aws.Int64(1024),
+			EngineExecutionTimeInMillis: aws.Int64(300),
+			TotalExecutionTimeInMillis:  aws.Int64(420),
+		},
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	rows, stats, err := QueryWithStats(context.Background(), db, "SELECT name FROM t")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	assert.Equal(t, Statistics{
+		QueryID:                     "query_1",
+		DataScannedInBytes:          1024,
+		EngineExecutionTimeInMillis: 300,
+		TotalExecutionTimeInMillis:  420,
+	}, stats)
+
+	var names []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"a"}, names)
+}
+
+func TestStatistics_timeBreakdown(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults([]*athena.ColumnInfo{genTypedColumnInfo("name", "varchar")}),
+		statistics: &athena.QueryExecutionStatistics{
+			DataScannedInBytes:            aws.Int64(0),
+			QueryQueueTimeInMillis:        aws.Int64(120),
+			QueryPlanningTimeInMillis:     aws.Int64(80),
+			EngineExecutionTimeInMillis:   aws.Int64(300),
+			ServiceProcessingTimeInMillis: aws.Int64(25),
+			TotalExecutionTimeInMillis:    aws.Int64(445),
+			ResultReuseInformation:        &athena.ResultReuseInformation{ReusedPreviousResult: aws.Bool(true)},
+		},
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	rows, stats, err := QueryWithStats(context.Background(), db, "SELECT name FROM t")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	assert.Equal(t, Statistics{
+		QueryID:                       "query_1",
+		QueryQueueTimeInMillis:        120,
+		QueryPlanningTimeInMillis:     80,
+		EngineExecutionTimeInMillis:   300,
+		ServiceProcessingTimeInMillis: 25,
+		TotalExecutionTimeInMillis:    445,
+		ReusedPreviousResult:          true,
+	}, stats)
+}
+
+func TestSetStatsCollector(t *testing.T) {
+	client := &mockAthenaConnClient{
+		results: genResults(nil),
+		statistics: &athena.QueryExecutionStatistics{
+			DataScannedInBytes:          aws.Int64(2048),
+			EngineExecutionTimeInMillis: aws.Int64(150),
+			TotalExecutionTimeInMillis:  aws.Int64(200),
+		},
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	var stats Statistics
+	_, err := db.ExecContext(SetStatsCollector(context.Background(), &stats), "INSERT INTO t SELECT * FROM u")
+	require.NoError(t, err)
+
+	assert.Equal(t, Statistics{
+		QueryID:                     "query_1",
+		DataScannedInBytes:          2048,
+		EngineExecutionTimeInMillis: 150,
+		TotalExecutionTimeInMillis:  200,
+	}, stats)
+}
+
+func TestStatistics_times(t *testing.T) {
+	submitted := time.Date(2021, 4, 1, 9, 0, 0, 0, time.UTC)
+	completed := submitted.Add(95 * time.Second)
+	client := &mockAthenaConnClient{
+		results:   genResults([]*athena.ColumnInfo{genTypedColumnInfo("name", "varchar")}),
+		submitted: &submitted,
+		completed: &completed,
+	}
+	db := newMockDB(t, client, Config{})
+	defer db.Close()
+
+	rows, stats, err := QueryWithStats(context.Background(), db, "SELECT name FROM t")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	assert.Equal(t, submitted, stats.SubmittedAt)
+	assert.Equal(t, completed, stats.CompletedAt)
+	assert.Equal(t, 95*time.Second, stats.CompletedAt.Sub(stats.SubmittedAt))
+}
@@ -1,12 +1,20 @@
 package athena
 
 import (
+	"context"
 	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/shopspring/decimal"
 )
 
 const (
@@ -18,9 +26,44 @@ const (
 
 const nullStringResultModeGzipDL string = "\\N"
 
-func convertRow(columns []*athena.ColumnInfo, in []*athena.Datum, ret []driver.Value) error {
+// convertOptions are the options of converting values.
+type convertOptions struct {
+	// decimal makes decimal values decimal.Decimal rather than float64
+	decimal bool
+
+	// geometry makes geometry values Geometry rather than their WKT string
+	geometry bool
+
+	// rawBytes makes every value the []byte of its text, without parsing it
+	rawBytes bool
+}
+
+// getConvertOptions returns the options set to the context of a query.
+func getConvertOptions(ctx context.Context) convertOptions {
+	decimalMode, _ := getDecimalMode(ctx)
+	geometryMode, _ := getGeometryMode(ctx)
+	rawBytesMode, _ := getRawBytesMode(ctx)
+	return convertOptions{decimal: decimalMode, geometry: geometryMode, rawBytes: rawBytesMode}
+}
+
+// rawBytesValue returns the bytes of a value, nil for NULL.
+func rawBytesValue(rawValue *string) driver.Value {
+	if rawValue == nil {
+		return nil
+	}
+	return []byte(*rawValue)
+}
+
+func (opts convertOptions) convertRow(columns []*athena.ColumnInfo, in []*athena.Datum, ret []driver.Value) error {
+	if len(columns) == 0 {
+		return nil
+	}
 	for i, val := range in {
-		coerced, err := convertValue(*columns[i].Type, val.VarCharValue)
+		if opts.rawBytes {
+			ret[i] = rawBytesValue(val.VarCharValue)
+			continue
+		}
+		coerced, err := opts.convertValue(*columns[i].Type, val.VarCharValue)
 		if err != nil {
 			return err
 		}
@@ -31,15 +74,33 @@ func convertRow(columns []*athena.ColumnInfo, in []*athena.Datum, ret []driver.V
 	return nil
 }
 
-func convertRowFromTableInfo(columns []*athena.Column, in []string, ret []driver.Value) error {
+func (opts convertOptions) convertRowFromTableInfo(columns []*athena.Column, in []string, ret []driver.Value) error {
+	if len(columns) == 0 {
+		return nil
+	}
 	for i, val := range in {
+		if opts.rawBytes {
+			if val == nullStringResultModeGzipDL {
+				ret[i] = nil
+			} else {
+				ret[i] = []byte(val)
+			}
+			continue
+		}
+
 		var coerced interface{}
 		var err error
 		if val == nullStringResultModeGzipDL {
 			var nullVal *string
-			coerced, err = convertValue(*columns[i].Type, nullVal)
+			coerced, err = opts.convertValue(*columns[i].Type, nullVal)
+		} else if isArrayType(*columns[i].Type) {
+			coerced, err = opts.convertTextFileArray(*columns[i].Type, val)
+		} else if isMapType(*columns[i].Type) {
+			coerced, err = opts.convertTextFileMap(*columns[i].Type, val)
+		} else if isRowType(*columns[i].Type) {
+			coerced, err = opts.convertTextFileRow(*columns[i].Type, val)
 		} else {
-			coerced, err = convertValue(*columns[i].Type, &val)
+			coerced, err = opts.convertValue(*columns[i].Type, &val)
 		}
 		if err != nil {
 			return err
@@ -51,15 +112,27 @@ func convertRowFromTableInfo(columns []*athena.Column, in []string, ret []driver
 	return nil
 }
 
-func convertRowFromCsv(columns []*athena.ColumnInfo, in []downloadField, ret []driver.Value) error {
+func (opts convertOptions) convertRowFromCsv(columns []*athena.ColumnInfo, in []downloadField, ret []driver.Value) error {
+	if len(columns) == 0 {
+		return nil
+	}
 	for i, df := range in {
+		if opts.rawBytes {
+			if df.isNil {
+				ret[i] = nil
+			} else {
+				ret[i] = []byte(df.val)
+			}
+			continue
+		}
+
 		var coerced interface{}
 		var err error
 		if df.isNil {
 			var nullVal *string
-			coerced, err = convertValue(*columns[i].Type, nullVal)
+			coerced, err = opts.convertValue(*columns[i].Type, nullVal)
 		} else {
-			coerced, err = convertValue(*columns[i].Type, &df.val)
+			coerced, err = opts.convertValue(*columns[i].Type, &df.val)
 		}
 		if err != nil {
 			return err
@@ -71,23 +144,48 @@ func convertRowFromCsv(columns []*athena.ColumnInfo, in []downloadField, ret []d
 	return nil
 }
 
+// convertValue converts a value of athenaType with the default options.
 func convertValue(athenaType string, rawValue *string) (interface{}, error) {
+	return convertOptions{}.convertValue(athenaType, rawValue)
+}
+
+func (opts convertOptions) convertValue(athenaType string, rawValue *string) (interface{}, error) {
 	if rawValue == nil {
 		return nil, nil
 	}
 
-	if len(athenaType) > 7 && athenaType[:7] == "decimal" {
-		athenaType = "decimal"
+	val := *rawValue
+	if isRowType(athenaType) {
+		return opts.convertRowValue(athenaType, val)
+	}
+	if isArrayType(athenaType) {
+		return opts.convertArrayValue(athenaType, val)
+	}
+	if isMapType(athenaType) {
+		return opts.convertMapValue(athenaType, val)
 	}
 
-	val := *rawValue
+	// parameters such as decimal(11,5) or varchar(255) don't affect the conversion
+	if i := strings.IndexAny(athenaType, "(<"); i > 0 {
+		athenaType = athenaType[:i]
+	}
+
+	// a quoted empty field isn't a value of these types, e.g. "" of an
+	// UNLOAD quoting every field, so it's read as NULL rather than failing
+	if val == "" && isEmptyAsNullType(athenaType) {
+		return nil, nil
+	}
+
+	// every integer type is returned as int64
 	switch athenaType {
+	case "tinyint":
+		return parseInt(athenaType, val, 8)
 	case "smallint":
-		return strconv.ParseInt(val, 10, 16)
+		return parseInt(athenaType, val, 16)
 	case "integer", "int":
-		return strconv.ParseInt(val, 10, 32)
+		return parseInt(athenaType, val, 32)
 	case "bigint":
-		return strconv.ParseInt(val, 10, 64)
+		return parseInt(athenaType, val, 64)
 	case "boolean":
 		switch val {
 		case "true":
@@ -98,17 +196,138 @@ func convertValue(athenaType string, rawValue *string) (interface{}, error) {
 		return nil, fmt.Errorf("cannot parse '%s' as boolean", val)
 	case "float":
 		return strconv.ParseFloat(val, 32)
-	case "double", "decimal":
+	case "double":
+		return strconv.ParseFloat(val, 64)
+	case "decimal":
+		if opts.decimal {
+			return decimal.NewFromString(val)
+		}
 		return strconv.ParseFloat(val, 64)
 	case "varchar", "string":
 		return val, nil
+	case "char":
+		// char values are padded with spaces to the length of the type
+		return strings.TrimRight(val, " "), nil
 	case "timestamp":
 		return time.Parse(TimestampLayout, val)
 	case "timestamp with time zone":
 		return time.Parse(TimestampWithTimeZoneLayout, val)
 	case "date":
 		return time.Parse(DateLayout, val)
+	case "varbinary", "binary":
+		return decodeBinary(val)
+	case "geometry":
+		if opts.geometry {
+			return parseGeometry(val)
+		}
+		return val, nil
+	case "json":
+		// returned as []byte, which can be scanned into a json.RawMessage as well as a string
+		if !json.Valid([]byte(val)) {
+			return nil, fmt.Errorf("cannot parse '%s' as json", val)
+		}
+		return []byte(val), nil
 	default:
-		panic(fmt.Errorf("unknown type `%s` with value %s", athenaType, val))
+		// e.g. uniontype or interval, which have no Go type
+		return nil, fmt.Errorf("unknown type `%s` with value %s", athenaType, val)
+	}
+}
+
+// isEmptyAsNullType reports whether an empty string of athenaType, without
+// parameters, is converted to nil.
+func isEmptyAsNullType(athenaType string) bool {
+	switch athenaType {
+	case "tinyint", "smallint", "integer", "int", "bigint",
+		"float", "double", "decimal", "boolean",
+		"timestamp", "timestamp with time zone", "date":
+		return true
+	}
+	return false
+}
+
+// Go types of the values convertValue returns, for ColumnTypeScanType.
+var (
+	scanTypeInt64     = reflect.TypeOf(int64(0))
+	scanTypeFloat64   = reflect.TypeOf(float64(0))
+	scanTypeBool      = reflect.TypeOf(false)
+	scanTypeString    = reflect.TypeOf("")
+	scanTypeBytes     = reflect.TypeOf([]byte(nil))
+	scanTypeTime      = reflect.TypeOf(time.Time{})
+	scanTypeDecimal   = reflect.TypeOf(decimal.Decimal{})
+	scanTypeGeometry  = reflect.TypeOf(Geometry{})
+	scanTypeArray     = reflect.TypeOf([]interface{}(nil))
+	scanTypeMap       = reflect.TypeOf(map[string]interface{}(nil))
+	scanTypeInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// scanType returns the Go type of the values of athenaType convertValue
+// returns, or interface{} for unknown types.
+func (opts convertOptions) scanType(athenaType string) reflect.Type {
+	if opts.rawBytes {
+		return scanTypeBytes
+	}
+	if isRowType(athenaType) || isMapType(athenaType) {
+		return scanTypeMap
+	}
+	if isArrayType(athenaType) {
+		return scanTypeArray
+	}
+
+	if i := strings.IndexAny(athenaType, "(<"); i > 0 {
+		athenaType = athenaType[:i]
+	}
+
+	switch athenaType {
+	case "tinyint", "smallint", "integer", "int", "bigint":
+		return scanTypeInt64
+	case "boolean":
+		return scanTypeBool
+	case "float", "double":
+		return scanTypeFloat64
+	case "decimal":
+		if opts.decimal {
+			return scanTypeDecimal
+		}
+		return scanTypeFloat64
+	case "varchar", "string", "char":
+		return scanTypeString
+	case "timestamp", "timestamp with time zone", "date":
+		return scanTypeTime
+	case "varbinary", "binary", "json":
+		return scanTypeBytes
+	case "geometry":
+		if opts.geometry {
+			return scanTypeGeometry
+		}
+		return scanTypeString
+	}
+	return scanTypeInterface
+}
+
+// hexBinaryRegex matches binary values rendered as hex bytes separated by
+// spaces, e.g. `68 69`, which base64 can't contain.
+var hexBinaryRegex = regexp.MustCompile(`^[0-9a-fA-F]{2}( [0-9a-fA-F]{2})*$`)
+
+// decodeBinary decodes a binary value written either as hex bytes, as in the
+// result CSV of DL mode, or as base64, as in the result set of API mode and
+// the TEXTFILE tables of GZIP DL mode.
+func decodeBinary(val string) ([]byte, error) {
+	if hexBinaryRegex.MatchString(val) {
+		return hex.DecodeString(strings.Replace(val, " ", "", -1))
+	}
+
+	b, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse '%s' as varbinary: %w", val, err)
+	}
+	return b, nil
+}
+
+// parseInt parses an integer of athenaType, rejecting values out of its range.
+func parseInt(athenaType, val string, bitSize int) (interface{}, error) {
+	i, err := strconv.ParseInt(val, 10, bitSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse '%s' as %s: %w", val, athenaType, err)
 	}
+	return i, nil
 }
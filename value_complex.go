@@ -0,0 +1,437 @@
+package athena
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nullComplexElement is how Athena renders a NULL nested in a complex value.
+const nullComplexElement = "null"
+
+// rowField is a field of a `row(...)` type.
+type rowField struct {
+	name       string
+	athenaType string
+}
+
+// isRowType reports whether athenaType is a row, including the struct<...>
+// of Hive DDL, which GetTableMetadata reports for the tables of GZIP DL mode.
+func isRowType(athenaType string) bool {
+	return athenaType == "row" || strings.HasPrefix(athenaType, "row(") || strings.HasPrefix(athenaType, "struct<")
+}
+
+// parseRowType parses `row(a integer, b row(c varchar))` into its fields.
+// Unnamed fields are named `field0`, `field1`... as Athena does.
+// It returns no fields for a bare `row` without a definition.
+func parseRowType(athenaType string) ([]rowField, error) {
+	if athenaType == "row" {
+		return nil, nil
+	}
+	if strings.HasPrefix(athenaType, "struct<") {
+		return parseStructType(athenaType)
+	}
+	if !strings.HasSuffix(athenaType, ")") {
+		return nil, fmt.Errorf("invalid row type `%s`", athenaType)
+	}
+
+	var fields []rowField
+	for i, def := range splitTopLevel(athenaType[len("row("):len(athenaType)-1], ',') {
+		def = strings.TrimSpace(def)
+		field := rowField{name: fmt.Sprintf("field%d", i), athenaType: def}
+		if sp := strings.IndexByte(def, ' '); sp > 0 && !strings.ContainsAny(def[:sp], "(<") {
+			field.name = strings.Trim(def[:sp], `"`)
+			field.athenaType = strings.TrimSpace(def[sp+1:])
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// parseStructType parses `struct<a:int,b:struct<c:string>>` into its fields.
+func parseStructType(athenaType string) ([]rowField, error) {
+	if !strings.HasSuffix(athenaType, ">") {
+		return nil, fmt.Errorf("invalid struct type `%s`", athenaType)
+	}
+
+	var fields []rowField
+	for _, def := range splitTopLevel(athenaType[len("struct<"):len(athenaType)-1], ',') {
+		colon := strings.IndexByte(def, ':')
+		if colon <= 0 {
+			return nil, fmt.Errorf("invalid struct type `%s`", athenaType)
+		}
+		fields = append(fields, rowField{
+			name:       strings.TrimSpace(def[:colon]),
+			athenaType: strings.TrimSpace(def[colon+1:]),
+		})
+	}
+
+	return fields, nil
+}
+
+// convertRowValue converts `{a=1, b={c=x}}` into a map keyed by field name.
+// Field values are converted with the field types of athenaType. When the
+// type has no field definitions, the values are left as strings.
+func (opts convertOptions) convertRowValue(athenaType, val string) (map[string]interface{}, error) {
+	fields, err := parseRowType(athenaType)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(val) < 2 || val[0] != '{' || val[len(val)-1] != '}' {
+		return nil, fmt.Errorf("cannot parse '%s' as %s", val, athenaType)
+	}
+	body := val[1 : len(val)-1]
+
+	ret := make(map[string]interface{})
+	if fields == nil {
+		if body == "" {
+			return ret, nil
+		}
+		for _, elem := range splitElements(body, ',') {
+			elem = strings.TrimPrefix(elem, " ")
+			eq := strings.IndexByte(elem, '=')
+			if eq < 0 {
+				return nil, fmt.Errorf("cannot parse '%s' as %s", val, athenaType)
+			}
+			ret[elem[:eq]] = nullableElement(elem[eq+1:])
+		}
+		return ret, nil
+	}
+
+	for i, field := range fields {
+		prefix := field.name + "="
+		if !strings.HasPrefix(body, prefix) {
+			return nil, fmt.Errorf("cannot parse '%s' as %s", val, athenaType)
+		}
+		body = body[len(prefix):]
+
+		// a value ends where the next field starts, so values may contain commas
+		raw := body
+		if i < len(fields)-1 {
+			end := indexElement(body, ", "+fields[i+1].name+"=")
+			if end < 0 {
+				return nil, fmt.Errorf("cannot parse '%s' as %s", val, athenaType)
+			}
+			raw, body = body[:end], body[end+len(", "):]
+		}
+
+		if raw == nullComplexElement {
+			ret[field.name] = nil
+			continue
+		}
+		coerced, err := opts.convertValue(field.athenaType, &raw)
+		if err != nil {
+			return nil, err
+		}
+		ret[field.name] = coerced
+	}
+
+	return ret, nil
+}
+
+func isArrayType(athenaType string) bool {
+	return athenaType == "array" || strings.HasPrefix(athenaType, "array<") || strings.HasPrefix(athenaType, "array(")
+}
+
+// arrayElementType returns the element type of `array<varchar>` or
+// `array(varchar)`, or "" for a bare `array` without a definition.
+func arrayElementType(athenaType string) (string, error) {
+	if athenaType == "array" {
+		return "", nil
+	}
+	if !strings.HasSuffix(athenaType, ">") && !strings.HasSuffix(athenaType, ")") {
+		return "", fmt.Errorf("invalid array type `%s`", athenaType)
+	}
+	return strings.TrimSpace(athenaType[len("array<") : len(athenaType)-1]), nil
+}
+
+// convertArrayValue converts `[a, b]` into a []interface{}. Elements are
+// converted with the element type of athenaType, and left as strings when
+// the type has no element definition, as result metadata reports arrays.
+func (opts convertOptions) convertArrayValue(athenaType, val string) ([]interface{}, error) {
+	elemType, err := arrayElementType(athenaType)
+	if err != nil {
+		return nil, err
+	}
+
+	elems, err := parseArrayText(val)
+	if err != nil {
+		return nil, err
+	}
+	if elemType == "" {
+		return elems, nil
+	}
+
+	for i, elem := range elems {
+		raw, ok := elem.(string)
+		if !ok {
+			continue
+		}
+		coerced, err := opts.convertValue(elemType, &raw)
+		if err != nil {
+			return nil, err
+		}
+		elems[i] = coerced
+	}
+	return elems, nil
+}
+
+// textFileCollectionDelimiter separates the elements of a collection in the
+// TEXTFILE tables of GZIP DL mode.
+const textFileCollectionDelimiter = "\002"
+
+// convertTextFileArray converts an array of a TEXTFILE table, whose elements
+// are separated by textFileCollectionDelimiter, into a []interface{}.
+// Nested collections are left as written.
+func (opts convertOptions) convertTextFileArray(athenaType, val string) ([]interface{}, error) {
+	elemType, err := arrayElementType(athenaType)
+	if err != nil {
+		return nil, err
+	}
+
+	elems := make([]interface{}, 0)
+	if val == "" {
+		return elems, nil
+	}
+	for _, raw := range strings.Split(val, textFileCollectionDelimiter) {
+		switch {
+		case raw == nullStringResultModeGzipDL:
+			elems = append(elems, nil)
+		case elemType == "" || isComplexType(elemType):
+			elems = append(elems, raw)
+		default:
+			coerced, err := opts.convertValue(elemType, &raw)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, coerced)
+		}
+	}
+	return elems, nil
+}
+
+// convertTextFileRow converts a row of a TEXTFILE table, whose fields are
+// separated by textFileCollectionDelimiter, into a map keyed by field name.
+// Nested collections are left as written.
+func (opts convertOptions) convertTextFileRow(athenaType, val string) (map[string]interface{}, error) {
+	fields, err := parseRowType(athenaType)
+	if err != nil {
+		return nil, err
+	}
+
+	raws := strings.Split(val, textFileCollectionDelimiter)
+	if len(raws) != len(fields) {
+		return nil, fmt.Errorf("cannot parse '%s' as %s", val, athenaType)
+	}
+
+	ret := make(map[string]interface{})
+	for i, field := range fields {
+		raw := raws[i]
+		switch {
+		case raw == nullStringResultModeGzipDL:
+			ret[field.name] = nil
+		case isComplexType(field.athenaType):
+			ret[field.name] = raw
+		default:
+			coerced, err := opts.convertValue(field.athenaType, &raw)
+			if err != nil {
+				return nil, err
+			}
+			ret[field.name] = coerced
+		}
+	}
+	return ret, nil
+}
+
+func isMapType(athenaType string) bool {
+	return athenaType == "map" || strings.HasPrefix(athenaType, "map<") || strings.HasPrefix(athenaType, "map(")
+}
+
+// mapEntryTypes returns the key and value types of `map<varchar,integer>` or
+// `map(varchar, integer)`, or "" for a bare `map` without a definition.
+func mapEntryTypes(athenaType string) (string, string, error) {
+	if athenaType == "map" {
+		return "", "", nil
+	}
+	if !strings.HasSuffix(athenaType, ">") && !strings.HasSuffix(athenaType, ")") {
+		return "", "", fmt.Errorf("invalid map type `%s`", athenaType)
+	}
+
+	types := splitTopLevel(athenaType[len("map<"):len(athenaType)-1], ',')
+	if len(types) != 2 {
+		return "", "", fmt.Errorf("invalid map type `%s`", athenaType)
+	}
+	return strings.TrimSpace(types[0]), strings.TrimSpace(types[1]), nil
+}
+
+// convertMapValue converts `{a=1, b=2}` into a map keyed by the keys as
+// Athena renders them. Keys and values are checked and converted with the
+// types of athenaType, and values are left as strings when the type has no
+// definition, as result metadata reports maps.
+func (opts convertOptions) convertMapValue(athenaType, val string) (map[string]interface{}, error) {
+	keyType, valueType, err := mapEntryTypes(athenaType)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(val) < 2 || val[0] != '{' || val[len(val)-1] != '}' {
+		return nil, fmt.Errorf("cannot parse '%s' as %s", val, athenaType)
+	}
+	body := val[1 : len(val)-1]
+
+	ret := make(map[string]interface{})
+	if body == "" {
+		return ret, nil
+	}
+
+	// a part without `=` is the rest of a value containing a comma
+	var entries []string
+	for _, part := range splitElements(body, ',') {
+		if len(entries) > 0 && indexElement(part, "=") < 0 {
+			entries[len(entries)-1] += "," + part
+			continue
+		}
+		entries = append(entries, strings.TrimPrefix(part, " "))
+	}
+
+	for _, entry := range entries {
+		eq := indexElement(entry, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("cannot parse '%s' as %s", val, athenaType)
+		}
+		key, value, err := opts.convertMapEntry(keyType, valueType, entry[:eq], entry[eq+1:], false)
+		if err != nil {
+			return nil, err
+		}
+		ret[key] = value
+	}
+	return ret, nil
+}
+
+// convertMapEntry converts a value of valueType, after checking key is of keyType.
+// Values are left as strings when valueType is "", as are complex values of
+// TEXTFILE tables, whose nested delimiters aren't parsed.
+func (opts convertOptions) convertMapEntry(keyType, valueType, key, value string, textFile bool) (string, interface{}, error) {
+	if keyType != "" {
+		if _, err := opts.convertValue(keyType, &key); err != nil {
+			return "", nil, err
+		}
+	}
+
+	null := nullComplexElement
+	if textFile {
+		null = nullStringResultModeGzipDL
+	}
+	switch {
+	case value == null:
+		return key, nil, nil
+	case valueType == "" || textFile && isComplexType(valueType):
+		return key, value, nil
+	}
+	coerced, err := opts.convertValue(valueType, &value)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, coerced, nil
+}
+
+// textFileMapKeyDelimiter separates the key and value of a map entry in the
+// TEXTFILE tables of GZIP DL mode, whose entries are separated by
+// textFileCollectionDelimiter.
+const textFileMapKeyDelimiter = "\003"
+
+// convertTextFileMap converts a map of a TEXTFILE table into a map keyed by
+// the keys as written. Nested collections are left as written.
+func (opts convertOptions) convertTextFileMap(athenaType, val string) (map[string]interface{}, error) {
+	keyType, valueType, err := mapEntryTypes(athenaType)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]interface{})
+	if val == "" {
+		return ret, nil
+	}
+	for _, entry := range strings.Split(val, textFileCollectionDelimiter) {
+		kv := strings.SplitN(entry, textFileMapKeyDelimiter, 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("cannot parse '%s' as %s", val, athenaType)
+		}
+		key, value, err := opts.convertMapEntry(keyType, valueType, kv[0], kv[1], true)
+		if err != nil {
+			return nil, err
+		}
+		ret[key] = value
+	}
+	return ret, nil
+}
+
+func isComplexType(athenaType string) bool {
+	return isArrayType(athenaType) || isMapType(athenaType) || isRowType(athenaType)
+}
+
+func nullableElement(elem string) interface{} {
+	if elem == nullComplexElement {
+		return nil
+	}
+	return elem
+}
+
+// Types nest in every kind of bracket, while values rendered by Athena nest
+// only in the brackets of arrays, maps and rows, so a `<` or `)` in a value
+// is just a character.
+const (
+	typeOpenBrackets   = "(<[{"
+	typeCloseBrackets  = ")>]}"
+	valueOpenBrackets  = "[{"
+	valueCloseBrackets = "]}"
+)
+
+// splitTopLevel splits a type s by sep, ignoring separators nested in brackets.
+func splitTopLevel(s string, sep byte) []string {
+	return splitNested(s, sep, typeOpenBrackets, typeCloseBrackets)
+}
+
+// splitElements splits a value s by sep, ignoring separators nested in
+// arrays, maps and rows.
+func splitElements(s string, sep byte) []string {
+	return splitNested(s, sep, valueOpenBrackets, valueCloseBrackets)
+}
+
+func splitNested(s string, sep byte, open, closing string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.IndexByte(open, s[i]) >= 0:
+			depth++
+		case strings.IndexByte(closing, s[i]) >= 0:
+			depth--
+		case s[i] == sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// indexElement returns the index of the first substr in a value s which
+// isn't nested in arrays, maps and rows, or -1.
+func indexElement(s, substr string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		if depth == 0 && strings.HasPrefix(s[i:], substr) {
+			return i
+		}
+		switch {
+		case strings.IndexByte(valueOpenBrackets, s[i]) >= 0:
+			depth++
+		case strings.IndexByte(valueCloseBrackets, s[i]) >= 0:
+			depth--
+		}
+	}
+	return -1
+}
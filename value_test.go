@@ -0,0 +1,470 @@
+package athena
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_convertValue_row(t *testing.T) {
+	tests := []struct {
+		name       string
+		athenaType string
+		val        string
+		want       interface{}
+	}{
+		{
+			name:       "simple row",
+			athenaType: "row(a integer, b varchar)",
+			val:        "{a=1, b=x, y}",
+			want:       map[string]interface{}{"a": int64(1), "b": "x, y"},
+		},
+		{
+			name:       "nested row",
+			athenaType: "row(a integer, b row(c varchar, d double), e boolean)",
+			val:        "{a=1, b={c=x, d=1.5}, e=true}",
+			want: map[string]interface{}{
+				"a": int64(1),
+				"b": map[string]interface{}{"c": "x", "d": 1.5},
+				"e": true,
+			},
+		},
+		{
+			name:       "null field",
+			athenaType: "row(a integer, b varchar)",
+			val:        "{a=null, b=x}",
+			want:       map[string]interface{}{"a": nil, "b": "x"},
+		},
+		{
+			name:       "unnamed fields",
+			athenaType: "row(integer, varchar)",
+			val:        "{field0=1, field1=x}",
+			want:       map[string]interface{}{"field0": int64(1), "field1": "x"},
+		},
+		{
+			name:       "row without definition",
+			athenaType: "row",
+			val:        "{a=1, b=null}",
+			want:       map[string]interface{}{"a": "1", "b": nil},
+		},
+		{
+			name:       "values with angle brackets",
+			athenaType: "row(a varchar, b varchar)",
+			val:        "{a=1 < 2, b=x}",
+			want:       map[string]interface{}{"a": "1 < 2", "b": "x"},
+		},
+		{
+			name:       "row without definition with angle brackets",
+			athenaType: "row",
+			val:        "{a=<x, b=y>}",
+			want:       map[string]interface{}{"a": "<x", "b": "y>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertValue(tt.athenaType, &tt.val)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	got, err := convertValue("row(a integer)", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	val := "{b=1}"
+	_, err = convertValue("row(a integer)", &val)
+	assert.Error(t, err)
+}
+
+func Test_convertValue_array(t *testing.T) {
+	tests := []struct {
+		name       string
+		athenaType string
+		val        string
+		want       []interface{}
+	}{
+		{name: "result metadata", athenaType: "array", val: "[a, b, c]", want: []interface{}{"a", "b", "c"}},
+		{name: "typed", athenaType: "array<integer>", val: "[1, 2]", want: []interface{}{int64(1), int64(2)}},
+		{name: "parenthesized", athenaType: "array(double)", val: "[1.5]", want: []interface{}{1.5}},
+		{name: "empty", athenaType: "array<varchar>", val: "[]", want: []interface{}{}},
+		{name: "single element", athenaType: "array<varchar>", val: "[a]", want: []interface{}{"a"}},
+		{name: "null element", athenaType: "array<bigint>", val: "[1, null]", want: []interface{}{int64(1), nil}},
+		{
+			name:       "nested",
+			athenaType: "array<array<integer>>",
+			val:        "[[1, 2], [3]]",
+			want:       []interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{int64(3)}},
+		},
+		{
+			name:       "rows",
+			athenaType: "array<row(a integer, b varchar)>",
+			val:        "[{a=1, b=x}, {a=2, b=y}]",
+			want: []interface{}{
+				map[string]interface{}{"a": int64(1), "b": "x"},
+				map[string]interface{}{"a": int64(2), "b": "y"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertValue(tt.athenaType, &tt.val)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	val := "[x]"
+	_, err := convertValue("array<integer>", &val)
+	assert.Error(t, err)
+	val = "x"
+	_, err = convertValue("array", &val)
+	assert.Error(t, err)
+}
+
+func Test_convertValue_map(t *testing.T) {
+	tests := []struct {
+		name       string
+		athenaType string
+		val        string
+		want       map[string]interface{}
+	}{
+		{name: "result metadata", athenaType: "map", val: "{a=1, b=2}", want: map[string]interface{}{"a": "1", "b": "2"}},
+		{name: "typed", athenaType: "map<varchar,integer>", val: "{a=1, b=2}", want: map[string]interface{}{"a": int64(1), "b": int64(2)}},
+		{name: "parenthesized", athenaType: "map(integer, double)", val: "{1=1.5}", want: map[string]interface{}{"1": 1.5}},
+		{name: "empty", athenaType: "map<varchar,varchar>", val: "{}", want: map[string]interface{}{}},
+		{name: "null value", athenaType: "map<varchar,bigint>", val: "{a=null}", want: map[string]interface{}{"a": nil}},
+		{name: "value with =", athenaType: "map<varchar,varchar>", val: "{q=a=b, r=c}", want: map[string]interface{}{"q": "a=b", "r": "c"}},
+		{name: "value with comma", athenaType: "map<varchar,varchar>", val: "{a=x, y, b=z}", want: map[string]interface{}{"a": "x, y", "b": "z"}},
+		{name: "value with angle brackets", athenaType: "map<varchar,varchar>", val: "{k=x>y, j=z}", want: map[string]interface{}{"k": "x>y", "j": "z"}},
+		{name: "key with angle bracket", athenaType: "map", val: "{a<b=1, c=2}", want: map[string]interface{}{"a<b": "1", "c": "2"}},
+		{
+			name:       "nested",
+			athenaType: "map<varchar,array<integer>>",
+			val:        "{a=[1, 2], b=[]}",
+			want:       map[string]interface{}{"a": []interface{}{int64(1), int64(2)}, "b": []interface{}{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertValue(tt.athenaType, &tt.val)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	val := "{a=1}"
+	_, err := convertValue("map<integer,integer>", &val)
+	assert.Error(t, err)
+	val = "a=1"
+	_, err = convertValue("map", &val)
+	assert.Error(t, err)
+}
+
+func Test_convertRowFromTableInfo_array(t *testing.T) {
+	columns := []*athena.Column{
+		{Name: aws.String("ids"), Type: aws.String("array<bigint>")},
+		{Name: aws.String("names"), Type: aws.String("array<varchar>")},
+	}
+
+	dest := make([]driver.Value, 2)
+	require.NoError(t, convertOptions{}.convertRowFromTableInfo(columns, []string{"1\0022\002\\N", ""}, dest))
+	assert.Equal(t, []driver.Value{[]interface{}{int64(1), int64(2), nil}, []interface{}{}}, dest)
+
+	require.NoError(t, convertOptions{}.convertRowFromTableInfo(columns, []string{"\\N", "a, b"}, dest))
+	assert.Equal(t, []driver.Value{nil, []interface{}{"a, b"}}, dest)
+}
+
+func Test_convertRowFromTableInfo_map(t *testing.T) {
+	columns := []*athena.Column{
+		{Name: aws.String("counts"), Type: aws.String("map<varchar,bigint>")},
+		{Name: aws.String("tags"), Type: aws.String("map<varchar,array<varchar>>")},
+	}
+
+	dest := make([]driver.Value, 2)
+	require.NoError(t, convertOptions{}.convertRowFromTableInfo(columns, []string{"a\0031\002b\003\\N", ""}, dest))
+	assert.Equal(t, []driver.Value{map[string]interface{}{"a": int64(1), "b": nil}, map[string]interface{}{}}, dest)
+
+	require.NoError(t, convertOptions{}.convertRowFromTableInfo(columns, []string{"a=b\0031", "k\003x\004y"}, dest))
+	assert.Equal(t, []driver.Value{map[string]interface{}{"a=b": int64(1)}, map[string]interface{}{"k": "x\004y"}}, dest)
+}
+
+func Test_convertRowFromTableInfo_struct(t *testing.T) {
+	columns := []*athena.Column{
+		{Name: aws.String("user"), Type: aws.String("struct<id:bigint,name:string,tags:array<string>>")},
+	}
+
+	dest := make([]driver.Value, 1)
+	require.NoError(t, convertOptions{}.convertRowFromTableInfo(columns, []string{"1\002alice\002a\003b"}, dest))
+	assert.Equal(t, []driver.Value{map[string]interface{}{"id": int64(1), "name": "alice", "tags": "a\003b"}}, dest)
+
+	require.NoError(t, convertOptions{}.convertRowFromTableInfo(columns, []string{"\\N\002bob\002"}, dest))
+	assert.Equal(t, []driver.Value{map[string]interface{}{"id": nil, "name": "bob", "tags": ""}}, dest)
+
+	assert.Error(t, convertOptions{}.convertRowFromTableInfo(columns, []string{"1\002alice"}, dest))
+
+	// the struct of Hive DDL is converted as a row
+	val := "{id=1, name=alice}"
+	got, err := convertValue("struct<id:int,name:string>", &val)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": int64(1), "name": "alice"}, got)
+
+	val = "{id=1, name=<alice>, tags=[a>b]}"
+	got, err = convertValue("struct<id:int,name:string,tags:array<string>>", &val)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": int64(1), "name": "<alice>", "tags": []interface{}{"a>b"}}, got)
+}
+
+func Test_convertValue_unknownType(t *testing.T) {
+	for _, athenaType := range []string{"uniontype<int,string>", "interval day to second", "unknown"} {
+		val := "1"
+		_, err := convertValue(athenaType, &val)
+		assert.EqualError(t, err, fmt.Sprintf("unknown type `%s` with value 1", strings.SplitN(athenaType, "<", 2)[0]), athenaType)
+	}
+}
+
+func Test_convertValue_parameterizedTypes(t *testing.T) {
+	tests := []struct {
+		athenaType string
+		val        string
+		want       interface{}
+	}{
+		{athenaType: "varchar(255)", val: "foo", want: "foo"},
+		{athenaType: "char(3)", val: "foo", want: "foo"},
+		{athenaType: "char(5)", val: "hi   ", want: "hi"},
+		{athenaType: "char(5)", val: " hi  ", want: " hi"},
+		{athenaType: "varchar(5)", val: "hi   ", want: "hi   "},
+		{athenaType: "decimal(11,5)", val: "1.5", want: 1.5},
+	}
+	for _, tt := range tests {
+		got, err := convertValue(tt.athenaType, &tt.val)
+		require.NoError(t, err, tt.athenaType)
+		assert.Equal(t, tt.want, got, tt.athenaType)
+	}
+}
+
+func Test_convertValue_decimal(t *testing.T) {
+	val := "12345678901234567.12345"
+	got, err := convertValue("decimal(22,5)", &val)
+	require.NoError(t, err)
+	assert.IsType(t, float64(0), got)
+
+	opts := convertOptions{decimal: true}
+	got, err = opts.convertValue("decimal(22,5)", &val)
+	require.NoError(t, err)
+	require.IsType(t, decimal.Decimal{}, got)
+	assert.Equal(t, val, got.(decimal.Decimal).StringFixed(5))
+
+	// nested decimals too
+	val = "[1.10, null]"
+	got, err = opts.convertValue("array<decimal(3,2)>", &val)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{decimal.RequireFromString("1.10"), nil}, got)
+
+	val = "x"
+	_, err = opts.convertValue("decimal(3,2)", &val)
+	assert.Error(t, err)
+}
+
+func Test_convertValue_integers(t *testing.T) {
+	tests := []struct {
+		athenaType string
+		val        string
+		want       int64
+	}{
+		{athenaType: "tinyint", val: "-128", want: -128},
+		{athenaType: "smallint", val: "32767", want: 32767},
+		{athenaType: "integer", val: "-2147483648", want: -2147483648},
+		{athenaType: "int", val: "1", want: 1},
+		{athenaType: "bigint", val: "9223372036854775807", want: 9223372036854775807},
+	}
+	for _, tt := range tests {
+		got, err := convertValue(tt.athenaType, &tt.val)
+		require.NoError(t, err, tt.athenaType)
+		assert.Equal(t, tt.want, got, tt.athenaType)
+
+		got, err = convertValue(tt.athenaType, nil)
+		require.NoError(t, err, tt.athenaType)
+		assert.Nil(t, got, tt.athenaType)
+	}
+
+	// out of range values of the sized types are rejected
+	val := "128"
+	_, err := convertValue("tinyint", &val)
+	assert.Error(t, err)
+}
+
+func Test_convertValue_integerRange(t *testing.T) {
+	tests := []struct {
+		athenaType string
+		val        string
+		want       int64
+		wantErr    string
+	}{
+		{athenaType: "tinyint", val: "127", want: 127},
+		{athenaType: "tinyint", val: "-129", wantErr: "cannot parse '-129' as tinyint"},
+		{athenaType: "smallint", val: "-32768", want: -32768},
+		{athenaType: "smallint", val: "32768", wantErr: "cannot parse '32768' as smallint"},
+		{athenaType: "integer", val: "2147483647", want: 2147483647},
+		{athenaType: "integer", val: "2147483648", wantErr: "cannot parse '2147483648' as integer"},
+		{athenaType: "bigint", val: "9223372036854775808", wantErr: "cannot parse '9223372036854775808' as bigint"},
+		{athenaType: "integer", val: "1.5", wantErr: "cannot parse '1.5' as integer"},
+	}
+	for _, tt := range tests {
+		got, err := convertValue(tt.athenaType, &tt.val)
+		if tt.wantErr != "" {
+			require.Error(t, err, tt.val)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.True(t, errors.Is(err, strconv.ErrRange) || errors.Is(err, strconv.ErrSyntax), tt.val)
+			continue
+		}
+		require.NoError(t, err, tt.val)
+		assert.Equal(t, tt.want, got, tt.val)
+	}
+}
+
+func Test_convertValue_varbinary(t *testing.T) {
+	blob := []byte{0x00, 0x01, 0x7f, 0x80, 0xfe, 0xff, 'h', 'i'}
+
+	tests := []struct {
+		name string
+		val  string
+	}{
+		{name: "base64", val: base64.StdEncoding.EncodeToString(blob)},
+		{name: "hex", val: "00 01 7f 80 fe ff 68 69"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertValue("varbinary", &tt.val)
+			require.NoError(t, err)
+			assert.Equal(t, blob, got)
+		})
+	}
+
+	// API and DL mode read the same bytes
+	columns := []*athena.ColumnInfo{genTypedColumnInfo("blob", "varbinary")}
+	api := make([]driver.Value, 1)
+	require.NoError(t, convertOptions{}.convertRow(columns, []*athena.Datum{{VarCharValue: aws.String(tests[0].val)}}, api))
+	dl := make([]driver.Value, 1)
+	require.NoError(t, convertOptions{}.convertRowFromCsv(columns, []downloadField{{val: tests[1].val}}, dl))
+	assert.Equal(t, api, dl)
+
+	val := "AQ=="
+	got, err := convertValue("binary", &val)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01}, got)
+	val = ""
+	got, err = convertValue("varbinary", &val)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{}, got)
+
+	val = "not binary!"
+	_, err = convertValue("varbinary", &val)
+	assert.Error(t, err)
+}
+
+func Test_convertValue_json(t *testing.T) {
+	val := `{"a":[1,2],"b":null}`
+	got, err := convertValue("json", &val)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(val), got)
+
+	val = `{"a":`
+	_, err = convertValue("json", &val)
+	assert.Error(t, err)
+}
+
+func Test_convertRowFromCsv_quotedEmpty(t *testing.T) {
+	columns := []*athena.ColumnInfo{
+		genTypedColumnInfo("id", "bigint"),
+		genTypedColumnInfo("price", "decimal(10,2)"),
+		genTypedColumnInfo("ok", "boolean"),
+		genTypedColumnInfo("created_at", "timestamp"),
+		genTypedColumnInfo("name", "varchar"),
+	}
+	records, err := getRecordsForDL(strings.NewReader("\"1\",\"2.5\",\"true\",\"2020-01-02 03:04:05.678\",\"alice\"\n\"\",\"\",\"\",\"\",\"\"\n"), csvOptions{})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	dest := make([]driver.Value, len(columns))
+	require.NoError(t, convertOptions{}.convertRowFromCsv(columns, records[0], dest))
+	assert.Equal(t, []driver.Value{int64(1), 2.5, true, time.Date(2020, 1, 2, 3, 4, 5, 678000000, time.UTC), "alice"}, dest)
+
+	// quoted empty fields of types other than strings are NULL
+	require.NoError(t, convertOptions{}.convertRowFromCsv(columns, records[1], dest))
+	assert.Equal(t, []driver.Value{nil, nil, nil, nil, ""}, dest)
+}
+
+func Benchmark_convertRowFromCsv(b *testing.B) {
+	columns := []*athena.ColumnInfo{
+		genTypedColumnInfo("id", "bigint"),
+		genTypedColumnInfo("price", "double"),
+		genTypedColumnInfo("created_at", "timestamp"),
+		genTypedColumnInfo("name", "varchar"),
+	}
+	row := []downloadField{{val: "1234567"}, {val: "12.5"}, {val: "2020-01-02 03:04:05.678"}, {val: "alice"}}
+	dest := make([]driver.Value, len(columns))
+
+	for _, opts := range []struct {
+		name string
+		convertOptions
+	}{
+		{"typed", convertOptions{}},
+		{"raw bytes", convertOptions{rawBytes: true}},
+	} {
+		b.Run(opts.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := opts.convertRowFromCsv(columns, row, dest); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func Test_scanType(t *testing.T) {
+	values := map[string]string{
+		"tinyint":                  "1",
+		"smallint":                 "1",
+		"integer":                  "1",
+		"bigint":                   "1",
+		"boolean":                  "true",
+		"float":                    "1.5",
+		"double":                   "1.5",
+		"decimal(11,5)":            "1.5",
+		"varchar(255)":             "a",
+		"char(3)":                  "a  ",
+		"timestamp":                "2020-01-02 03:04:05.678",
+		"timestamp with time zone": "2020-01-02 03:04:05.678 UTC",
+		"date":                     "2020-01-02",
+		"varbinary":                "68 69",
+		"json":                     `{"a":1}`,
+		"geometry":                 "POINT (1 2)",
+		"array(integer)":           "[1, 2]",
+		"map(varchar,integer)":     "{a=1}",
+		"row(a integer)":           "{a=1}",
+	}
+	for _, opts := range []convertOptions{{}, {decimal: true, geometry: true}} {
+		for athenaType, val := range values {
+			converted, err := opts.convertValue(athenaType, &val)
+			require.NoError(t, err, athenaType)
+			assert.Equal(t, reflect.TypeOf(converted), opts.scanType(athenaType), athenaType)
+		}
+	}
+
+	assert.Equal(t, reflect.TypeOf([]byte(nil)), convertOptions{rawBytes: true}.scanType("integer"))
+	assert.Equal(t, reflect.TypeOf((*interface{})(nil)).Elem(), convertOptions{}.scanType("unknown"))
+}